@@ -2,22 +2,51 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"strings"
+
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <mbox-file>\n", os.Args[0])
+	lockMethods := flag.String("lock", "dotlock,flock", "mbox lock methods: comma separated dotlock, flock, fcntl, or none")
+	lockTimeout := flag.Duration("lock-timeout", mboxfile.DefaultLockTimeout, "how long to wait for the mbox lock")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-lock methods] [-lock-timeout duration] <mbox-file>\n", os.Args[0])
 		os.Exit(75) // EX_TEMPFAIL
 	}
-	mboxPath := os.Args[1]
+	mboxPath := flag.Arg(0)
+
+	methods, err := mboxfile.ParseLockMethods(*lockMethods)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -lock: %v\n", err)
+		os.Exit(75)
+	}
+
+	// ロックは既存のファイルにしか取れないので、なければ先に作る
+	f, err := os.OpenFile(mboxPath, os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot create mbox: %v\n", err)
+		os.Exit(75)
+	}
+	f.Close()
+
+	// 他の書き込み（mboxviewd の書き換えや mboxfix）と競合しないようロックを取る
+	lock, err := mboxfile.LockFile(mboxPath, mboxfile.LockOptions{Methods: methods, Timeout: *lockTimeout})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot lock mbox: %v\n", err)
+		os.Exit(75)
+	}
+	defer lock.Unlock()
 
-	// ファイルを排他モードで開く（簡易版）
-	f, err := os.OpenFile(mboxPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	f, err = os.OpenFile(mboxPath, os.O_APPEND|os.O_WRONLY, 0)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "cannot open mbox: %v\n", err)
+		lock.Unlock()
 		os.Exit(75)
 	}
 	defer f.Close()
@@ -33,6 +62,7 @@ func main() {
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "write error: %v\n", err)
 				f.Close()
+				lock.Unlock()
 				os.Exit(75)
 			}
 			if line == "" {
@@ -50,12 +80,14 @@ func main() {
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "write error: %v\n", err)
 			f.Close()
+			lock.Unlock()
 			os.Exit(75)
 		}
 	}
 	if err := scanner.Err(); err != nil {
 		fmt.Fprintf(os.Stderr, "read error: %v\n", err)
 		f.Close()
+		lock.Unlock()
 		os.Exit(75)
 	}
 
@@ -63,5 +95,6 @@ func main() {
 	f.WriteString("\n")
 
 	f.Close()
+	lock.Unlock()
 	os.Exit(0) // EX_OK
 }
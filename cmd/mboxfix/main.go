@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"iter"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/emurenMRz/mboxview/internal/mboxheader"
-	"github.com/emurenMRz/mboxview/internal/server"
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
 )
 
 func main() {
@@ -16,11 +20,13 @@ func main() {
 		inplace       = flag.Bool("inplace", false, "Modify input file in-place (for fix mode)")
 		outPath       = flag.String("out", "", "Output file path (for fix mode)")
 		dryRun        = flag.Bool("dry-run", false, "Simulate fix operation without writing (for fix mode)")
-		removeDeleted = flag.Bool("remove-deleted", false, "Remove messages with Status: D (for fix mode)")
+		removeDeleted = flag.Bool("remove-deleted", false, "Remove messages flagged deleted (Status: D) (for fix mode)")
 		normalize     = flag.Bool("normalize", false, "Normalize headers (for fix mode)")
 		quiet         = flag.Bool("quiet", false, "Suppress non-error output (for fix mode)")
 		msgIndex      = flag.Int("msg", -1, "Message index (for show mode)")
 		inputPath     = flag.String("path", "", "Input mbox file path (required)")
+		lockMethods   = flag.String("lock", "dotlock,flock", "mbox lock methods: comma separated dotlock, flock, fcntl, or none")
+		lockTimeout   = flag.Duration("lock-timeout", mboxfile.DefaultLockTimeout, "How long to wait for the mbox lock")
 	)
 	flag.Parse()
 
@@ -28,128 +34,190 @@ func main() {
 		log.Fatal("Error: -path is required")
 	}
 
-	// Read messages from mbox file
-	messages, ok := server.ReadMessages(*inputPath, nil, nil)
-	if !ok {
-		log.Fatal("Failed to read mbox file")
+	methods, err := mboxfile.ParseLockMethods(*lockMethods)
+	if err != nil {
+		log.Fatal("Error: invalid -lock: ", err)
+	}
+
+	// Lock the mbox file: exclusively when it is rewritten in place, shared
+	// otherwise so that deliveries are not read half-written
+	writing := *mode == "fix" && *inplace && !*dryRun
+	lock, err := mboxfile.LockFile(*inputPath, mboxfile.LockOptions{
+		Methods: methods,
+		Timeout: *lockTimeout,
+		Shared:  !writing,
+	})
+	if err != nil {
+		log.Fatal("Failed to lock mbox file: ", err)
 	}
+	defer lock.Unlock()
+
+	// Open the mbox file; messages are streamed rather than loaded at once
+	f, err := os.Open(*inputPath)
+	if err != nil {
+		log.Fatal("Failed to read mbox file: ", err)
+	}
+	defer f.Close()
+	messages := readMessages(mboxfile.NewReader(f))
 
 	// Process messages based on mode
 	switch *mode {
 	case "validate":
-		validateMessages(messages)
+		err = validateMessages(messages)
 	case "fix":
-		fixMessages(messages, *inputPath, *inplace, *outPath, *dryRun, *removeDeleted, *quiet, *normalize)
+		err = fixMessages(messages, *inputPath, *inplace, *outPath, *dryRun, *removeDeleted, *quiet, *normalize)
 	case "show":
-		showMessage(messages, *msgIndex)
+		err = showMessage(messages, *msgIndex)
 	default:
-		log.Fatal("Error: Unknown mode. Use validate, fix, or show")
+		err = errors.New("Error: Unknown mode. Use validate, fix, or show")
+	}
+	if err != nil {
+		// log.Fatal skips deferred calls, which would leave the lock behind
+		f.Close()
+		lock.Unlock()
+		log.Fatal(err)
+	}
+}
+
+// readMessages yields the messages of r and any read error, which ends the
+// iteration. Data before the first message is reported and skipped.
+func readMessages(r *mboxfile.Reader) iter.Seq2[*mboxfile.Message, error] {
+	return func(yield func(*mboxfile.Message, error) bool) {
+		for message, err := range r.All() {
+			if err == mboxfile.ErrInvalidFormat {
+				log.Println("Warning: skipping data before the first message")
+				continue
+			}
+			if err != nil {
+				yield(nil, fmt.Errorf("Error reading mbox file: %v", err))
+				return
+			}
+			if !yield(message, nil) {
+				return
+			}
+		}
 	}
 }
 
-func validateMessages(messages []string) {
+func validateMessages(messages iter.Seq2[*mboxfile.Message, error]) error {
 	var allResults []mboxheader.ValidationResult
 
-	for i, message := range messages {
-		// Split headers and body
-		_, rest := server.SplitAtFirstNewline(message)
-		headers, _ := server.SplitHeadersFromBody(rest)
+	i := 0
+	for message, err := range messages {
+		if err != nil {
+			return err
+		}
 
 		// Validate headers
-		results := mboxheader.ValidateHeaders(headers, i)
+		results := mboxheader.ValidateHeaders(string(message.Header), i)
 		allResults = append(allResults, results...)
+		i++
 	}
 
 	// Output results
 	outputText(allResults)
+	return nil
 }
 
-func fixMessages(messages []string, inputPath string, inplace bool, outPath string, dryRun, removeDeleted, quiet, normalize bool) {
-	// Filter out deleted messages if requested
-	if removeDeleted {
-		var filteredMessages []string
-		for _, message := range messages {
-			// Split headers and body
-			_, rest := server.SplitAtFirstNewline(message)
-			headers, _ := server.SplitHeadersFromBody(rest)
-
-			// Check for Status: D
-			parsedHeaders := mboxheader.NewParsedMailHeaders(headers)
-			if status, exists := parsedHeaders.GetFieldValue("status"); exists && status == "D" {
-				// Skip this message
-				continue
-			}
-			filteredMessages = append(filteredMessages, message)
+func fixMessages(messages iter.Seq2[*mboxfile.Message, error], inputPath string, inplace bool, outPath string, dryRun, removeDeleted, quiet, normalize bool) error {
+	var out io.Writer = os.Stdout
+	var tempFile *os.File
+	var inputInfo os.FileInfo
+
+	// Choose the destination; in-place fixes go through a temp file that
+	// replaces the input once every message has been written
+	if dryRun {
+		out = io.Discard
+	} else if inplace {
+		var err error
+		if inputInfo, err = os.Stat(inputPath); err != nil {
+			return fmt.Errorf("Error reading input file: %v", err)
 		}
-		messages = filteredMessages
+		// Hidden, so that mail clients do not take it for a mailbox
+		tempFile, err = os.CreateTemp(filepath.Dir(inputPath), ".mboxfix-*.mbox")
+		if err != nil {
+			return fmt.Errorf("Error creating temp file: %v", err)
+		}
+		// Once renamed over the input there is nothing left to remove
+		defer os.Remove(tempFile.Name())
+		defer tempFile.Close()
+		out = tempFile
+	} else if outPath != "" {
+		file, err := os.Create(outPath)
+		if err != nil {
+			return fmt.Errorf("Error creating output file: %v", err)
+		}
+		defer file.Close()
+		out = file
 	}
 
-	// Normalize messages
-	if normalize {
-		var normalizedMessages []string
-		var allResults []mboxheader.ValidationResult
+	var allResults []mboxheader.ValidationResult
+	i := 0
+	for message, err := range messages {
+		if err != nil {
+			return err
+		}
+		headers := string(message.Header)
 
-		for i, message := range messages {
-			// Split headers and body
-			envelopeLine, rest := server.SplitAtFirstNewline(message)
-			headers, body := server.SplitHeadersFromBody(rest)
+		// Filter out deleted messages if requested
+		if removeDeleted {
+			if mboxheader.HeaderFlags(headers).Has(mboxheader.FlagDeleted) {
+				// Skip this message
+				continue
+			}
+		}
 
-			// Normalize headers
-			normalizedMessage, results := mboxheader.NormalizeHeaders(headers, i)
-			normalizedMessages = append(normalizedMessages, envelopeLine+"\n"+normalizedMessage+"\n"+body)
+		// Normalize headers
+		if normalize {
+			var results []mboxheader.ValidationResult
+			headers, results = mboxheader.NormalizeHeaders(headers, i)
 			allResults = append(allResults, results...)
 		}
-		messages = normalizedMessages
 
-		// Output results
-		if !quiet {
-			outputText(allResults)
+		if err := mboxfile.WriteMessage(out, message, []byte(headers)); err != nil {
+			return fmt.Errorf("Error writing to output file: %v", err)
 		}
+		i++
 	}
 
-	// Write to file if not dry-run
-	if !dryRun {
-		if inplace {
-			// Write back to input file
-			writeMessagesToFile(messages, inputPath)
-		} else if outPath != "" {
-			// Write to output file
-			writeMessagesToFile(messages, outPath)
-		} else {
-			// Write to stdout
-			for _, msg := range messages {
-				fmt.Println(msg)
-			}
-		}
+	// Output results
+	if normalize && !quiet {
+		outputText(allResults)
 	}
-}
 
-func showMessage(messages []string, msgIndex int) {
-	if msgIndex < 0 || msgIndex >= len(messages) {
-		log.Fatal("Error: Invalid message index")
+	if tempFile != nil {
+		// Keep the mode and owner the MTA and other readers rely on
+		if err := mboxfile.CopyMode(tempFile, inputInfo); err != nil {
+			return fmt.Errorf("Error setting temp file mode: %v", err)
+		}
+		if err := tempFile.Close(); err != nil {
+			return fmt.Errorf("Error closing temp file: %v", err)
+		}
+		if err := os.Rename(tempFile.Name(), inputPath); err != nil {
+			return fmt.Errorf("Error replacing input file: %v", err)
+		}
 	}
-
-	message := messages[msgIndex]
-	_, rest := server.SplitAtFirstNewline(message)
-	headers, _ := server.SplitHeadersFromBody(rest)
-
-	fmt.Printf("Message %d:\n", msgIndex)
-	fmt.Println(mboxheader.NewParsedMailHeaders(headers))
+	return nil
 }
 
-func writeMessagesToFile(messages []string, path string) {
-	file, err := os.Create(path)
-	if err != nil {
-		log.Fatal("Error creating output file:", err)
+func showMessage(messages iter.Seq2[*mboxfile.Message, error], msgIndex int) error {
+	if msgIndex < 0 {
+		return errors.New("Error: Invalid message index")
 	}
-	defer file.Close()
 
-	for _, msg := range messages {
-		_, err := file.WriteString(msg)
+	i := 0
+	for message, err := range messages {
 		if err != nil {
-			log.Fatal("Error writing to output file:", err)
+			return err
+		}
+		if i == msgIndex {
+			fmt.Printf("Message %d:\n", msgIndex)
+			fmt.Println(mboxheader.NewParsedMailHeaders(string(message.Header)))
+			return nil
 		}
+		i++
 	}
+	return errors.New("Error: Invalid message index")
 }
 
 func outputText(results []mboxheader.ValidationResult) {
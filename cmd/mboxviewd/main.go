@@ -4,8 +4,11 @@ import (
 	"flag"
 	"log"
 	"os"
+	"time"
 
 	"github.com/emurenMRz/mboxview/internal/server"
+	"github.com/emurenMRz/mboxview/internal/signature"
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
 )
 
 func main() {
@@ -16,8 +19,33 @@ func main() {
 	flag.StringVar(&logFile, "log-file", "", "path to log file (default: stdout)")
 	var edit bool
 	flag.BoolVar(&edit, "edit", false, "enable edit mode")
+	var trash string
+	flag.StringVar(&trash, "trash", "", "mailbox to move deleted messages to (default: flag them deleted in place)")
+	var smimeTrust, pgpKeyring string
+	flag.StringVar(&smimeTrust, "smime-trust", "", "PEM file or directory of CA certificates trusted for S/MIME signatures (default: system roots)")
+	flag.StringVar(&pgpKeyring, "pgp-keyring", "", "OpenPGP public keyring file or directory, armored or binary, for PGP signatures")
+	var lockMethods string
+	var lockTimeout time.Duration
+	flag.StringVar(&lockMethods, "lock", "dotlock,flock", "mbox lock methods: comma separated dotlock, flock, fcntl (only with flock), or none")
+	flag.DurationVar(&lockTimeout, "lock-timeout", mboxfile.DefaultLockTimeout, "how long to wait for an mbox lock")
 	flag.Parse()
 
+	methods, err := mboxfile.ParseLockMethods(lockMethods)
+	if err != nil {
+		log.Fatalf("Invalid -lock: %v", err)
+	}
+	// fcntl locks belong to the process: the server's own requests never
+	// conflict under them, and closing any descriptor of a mailbox drops
+	// them, so flock has to keep the server's rewrites apart
+	if methods != mboxfile.LockNone && methods&mboxfile.LockFcntl != 0 && methods&mboxfile.LockFlock == 0 {
+		log.Fatal("Invalid -lock: fcntl needs flock as well in mboxviewd")
+	}
+
+	verifier, err := signature.NewVerifier(smimeTrust, pgpKeyring)
+	if err != nil {
+		log.Fatalf("Failed to load signature trust: %v", err)
+	}
+
 	if logFile != "" {
 		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
@@ -29,6 +57,9 @@ func main() {
 
 	server.RegisterHandlers(mboxDir, staticDir)
 	server.SetEditMode(edit)
+	server.SetTrashMailbox(trash)
+	server.SetVerifier(verifier)
+	server.SetLockOptions(mboxfile.LockOptions{Methods: methods, Timeout: lockTimeout})
 
 	log.Println("Listening on", port)
 	if err := server.ListenAndServe(":" + port); err != nil {
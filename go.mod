@@ -3,7 +3,15 @@ module github.com/emurenMRz/mboxview
 go 1.25.3
 
 require (
+	github.com/ProtonMail/go-crypto v1.5.2
 	github.com/emersion/go-imap v1.2.1
-	github.com/emersion/go-mbox v1.0.4
-	golang.org/x/text v0.3.7
+	github.com/smallstep/pkcs7 v0.2.3
+	golang.org/x/net v0.47.0
+	golang.org/x/text v0.31.0
+)
+
+require (
+	github.com/cloudflare/circl v1.6.3 // indirect
+	golang.org/x/crypto v0.44.0 // indirect
+	golang.org/x/sys v0.38.0 // indirect
 )
@@ -0,0 +1,160 @@
+// Package charset converts message text to UTF-8.
+//
+// Besides the names registered with IANA it knows the aliases mailers
+// actually write, and it detects the encoding of text whose charset is
+// missing or wrong. Detection is aimed at Japanese mail, which was routinely
+// sent as unlabeled ISO-2022-JP, Shift_JIS or EUC-JP, and tells Western
+// 8-bit text apart from it.
+package charset
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// aliases maps charset names seen in mail that the IANA registry lacks, or
+// maps to a narrower encoding than senders meant, to the encoding to use.
+var aliases = map[string]encoding.Encoding{
+	// ASCII labels are decoded as UTF-8, so that 8-bit text behind them is
+	// found invalid and detected
+	"us-ascii":       unicode.UTF8,
+	"ascii":          unicode.UTF8,
+	"ansi_x3.4-1968": unicode.UTF8,
+	"utf8":           unicode.UTF8,
+
+	"iso-2022-jp":   japanese.ISO2022JP,
+	"iso-2022-jp-1": japanese.ISO2022JP,
+	"iso-2022-jp-2": japanese.ISO2022JP,
+	"iso-2022-jp-3": japanese.ISO2022JP,
+	"csiso2022jp":   japanese.ISO2022JP,
+	"cp50220":       japanese.ISO2022JP,
+	"cp50221":       japanese.ISO2022JP,
+	"cp50222":       japanese.ISO2022JP,
+
+	// The Shift_JIS decoder covers the Windows-31J extensions
+	"shift_jis":   japanese.ShiftJIS,
+	"shift-jis":   japanese.ShiftJIS,
+	"sjis":        japanese.ShiftJIS,
+	"x-sjis":      japanese.ShiftJIS,
+	"cp932":       japanese.ShiftJIS,
+	"ms932":       japanese.ShiftJIS,
+	"x-ms-cp932":  japanese.ShiftJIS,
+	"windows-31j": japanese.ShiftJIS,
+	"ms_kanji":    japanese.ShiftJIS,
+
+	"euc-jp":   japanese.EUCJP,
+	"x-euc-jp": japanese.EUCJP,
+	"eucjp":    japanese.EUCJP,
+	"cp51932":  japanese.EUCJP,
+
+	// The EUC-KR decoder covers the Unified Hangul Code extensions
+	"ks_c_5601-1987": korean.EUCKR,
+	"ks_c_5601-1989": korean.EUCKR,
+	"ksc5601":        korean.EUCKR,
+	"cp949":          korean.EUCKR,
+	"windows-949":    korean.EUCKR,
+	"uhc":            korean.EUCKR,
+
+	// GB2312 labels usually hide GBK
+	"gb2312": simplifiedchinese.GBK,
+	"x-gbk":  simplifiedchinese.GBK,
+	"cp936":  simplifiedchinese.GBK,
+	"euc-cn": simplifiedchinese.GBK,
+
+	"big5":   traditionalchinese.Big5,
+	"x-big5": traditionalchinese.Big5,
+	"cp950":  traditionalchinese.Big5,
+}
+
+// Lookup returns the encoding of a charset name, or nil if it is unknown or
+// not supported.
+func Lookup(name string) encoding.Encoding {
+	name = strings.ToLower(strings.Trim(name, " \t\"'"))
+	if name == "" {
+		return nil
+	}
+	if enc, ok := aliases[name]; ok {
+		return enc
+	}
+	if enc, err := ianaindex.MIME.Encoding(name); err == nil && enc != nil {
+		return enc
+	}
+	if enc, err := ianaindex.IANA.Encoding(name); err == nil && enc != nil {
+		return enc
+	}
+	if enc, err := htmlindex.Get(name); err == nil {
+		return enc
+	}
+	return nil
+}
+
+// Decode converts data in the declared charset to UTF-8. The charset is
+// detected instead when none is declared, when the declared one is unknown,
+// and when decoding with it gives invalid text. ISO-2022-JP escape sequences
+// override any declaration, since they appear in no other charset. Text
+// that cannot be decoded is returned with invalid bytes replaced.
+func Decode(data []byte, declared string) string {
+	if isASCII(data) {
+		return string(data)
+	}
+	if hasISO2022JPEscape(data) && !strings.HasPrefix(strings.ToLower(declared), "iso-2022") {
+		if out, ok := decodeWith(japanese.ISO2022JP, data); ok {
+			return out
+		}
+	}
+
+	enc := Lookup(declared)
+	if enc != nil {
+		if out, ok := decodeWith(enc, data); ok {
+			return out
+		}
+	}
+	if detected := Detect(data); detected != nil {
+		if out, ok := decodeWith(detected, data); ok {
+			return out
+		}
+	}
+	if enc != nil {
+		out, _ := decodeWith(enc, data)
+		return out
+	}
+	return strings.ToValidUTF8(string(data), "\uFFFD")
+}
+
+// DecodeHeader converts a raw header value to UTF-8. Values in UTF-8 or
+// ASCII, including RFC 2047 encoded-words, are returned unchanged.
+func DecodeHeader(value string) string {
+	if !strings.Contains(value, "\x1b") && utf8.ValidString(value) {
+		return value
+	}
+	return Decode([]byte(value), "")
+}
+
+// decodeWith decodes data and reports whether the result is free of
+// replacement characters the input did not already contain.
+func decodeWith(enc encoding.Encoding, data []byte) (string, bool) {
+	out, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return strings.ToValidUTF8(string(data), "\uFFFD"), false
+	}
+	return string(out), bytes.Count(out, []byte("\uFFFD")) == bytes.Count(data, []byte("\uFFFD"))
+}
+
+func isASCII(data []byte) bool {
+	for _, c := range data {
+		if c >= 0x80 || c == 0x1b {
+			return false
+		}
+	}
+	return true
+}
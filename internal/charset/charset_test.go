@@ -0,0 +1,100 @@
+package charset
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func encode(t *testing.T, enc encoding.Encoding, s string) []byte {
+	t.Helper()
+	out, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatalf("encode %q: %v", s, err)
+	}
+	return out
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		want encoding.Encoding
+	}{
+		{"UTF-8", unicode.UTF8},
+		{`"utf8"`, unicode.UTF8},
+		{"US-ASCII", unicode.UTF8},
+		{"ISO-2022-JP", japanese.ISO2022JP},
+		{"Shift_JIS", japanese.ShiftJIS},
+		{"cp932", japanese.ShiftJIS},
+		{"x-euc-jp", japanese.EUCJP},
+		{"ISO-8859-1", charmap.ISO8859_1},
+		{"windows-1252", charmap.Windows1252},
+		{"", nil},
+		{"x-unknown", nil},
+	}
+	for _, tt := range tests {
+		if got := Lookup(tt.name); got != tt.want {
+			t.Errorf("Lookup(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	const ja = "日本語のメールです。"
+	tests := []struct {
+		name     string
+		data     []byte
+		declared string
+		want     string
+	}{
+		{"ascii", []byte("hello"), "", "hello"},
+		{"utf-8", []byte(ja), "utf-8", ja},
+		{"iso-2022-jp", encode(t, japanese.ISO2022JP, ja), "iso-2022-jp", ja},
+		{"shift_jis", encode(t, japanese.ShiftJIS, ja), "shift_jis", ja},
+		{"euc-jp", encode(t, japanese.EUCJP, ja), "euc-jp", ja},
+		{"latin-1", encode(t, charmap.ISO8859_1, "Grüße"), "iso-8859-1", "Grüße"},
+
+		{"unlabeled utf-8", []byte(ja), "", ja},
+		{"unlabeled iso-2022-jp", encode(t, japanese.ISO2022JP, ja), "", ja},
+		{"unlabeled shift_jis", encode(t, japanese.ShiftJIS, ja), "", ja},
+		{"unlabeled euc-jp", encode(t, japanese.EUCJP, ja), "", ja},
+		{"unlabeled latin-1", encode(t, charmap.ISO8859_1, "Müller"), "", "Müller"},
+
+		{"iso-2022-jp as us-ascii", encode(t, japanese.ISO2022JP, ja), "us-ascii", ja},
+		{"iso-2022-jp as iso-8859-1", encode(t, japanese.ISO2022JP, ja), "iso-8859-1", ja},
+		{"shift_jis as us-ascii", encode(t, japanese.ShiftJIS, ja), "us-ascii", ja},
+		{"euc-jp as utf-8", encode(t, japanese.EUCJP, ja), "utf-8", ja},
+		{"shift_jis as euc-jp", encode(t, japanese.ShiftJIS, ja), "euc-jp", ja},
+		{"latin-1 as us-ascii", encode(t, charmap.ISO8859_1, "café"), "us-ascii", "café"},
+		{"unknown label", encode(t, japanese.ShiftJIS, ja), "x-unknown", ja},
+
+		{"undecodable", []byte("a \x80\xff"), "utf-8", "a ��"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Decode(tt.data, tt.declared); got != tt.want {
+				t.Errorf("Decode(%q, %q) = %q, want %q", tt.data, tt.declared, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"=?UTF-8?B?5pel5pys?=", "=?UTF-8?B?5pel5pys?="},
+		{"山田 <yamada@example.jp>", "山田 <yamada@example.jp>"},
+		{string(encode(t, japanese.ISO2022JP, "山田")) + " <yamada@example.jp>", "山田 <yamada@example.jp>"},
+		{string(encode(t, charmap.ISO8859_1, "Jürgen")) + " <j@example.de>", "Jürgen <j@example.de>"},
+	}
+	for _, tt := range tests {
+		if got := DecodeHeader(tt.value); got != tt.want {
+			t.Errorf("DecodeHeader(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
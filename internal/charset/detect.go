@@ -0,0 +1,163 @@
+package charset
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// iso2022JPEscapes are the designations switching ISO-2022-JP text into
+// and out of JIS X 0208, JIS X 0212 and JIS X 0201.
+var iso2022JPEscapes = [][]byte{
+	[]byte("\x1b$B"), []byte("\x1b$@"), []byte("\x1b$(D"),
+	[]byte("\x1b(B"), []byte("\x1b(J"), []byte("\x1b(I"),
+}
+
+func hasISO2022JPEscape(data []byte) bool {
+	for _, esc := range iso2022JPEscapes {
+		if bytes.Contains(data, esc) {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect guesses the encoding of data: ISO-2022-JP by its escape sequences,
+// UTF-8 when the text is valid UTF-8, and otherwise Shift_JIS, EUC-JP or
+// Windows-1252 by scoring their byte sequences. It returns nil when the text
+// fits none of them.
+func Detect(data []byte) encoding.Encoding {
+	if hasISO2022JPEscape(data) {
+		return japanese.ISO2022JP
+	}
+	if utf8.Valid(data) {
+		return unicode.UTF8
+	}
+
+	sjis, euc, latin := scoreShiftJIS(data), scoreEUCJP(data), scoreLatin1(data)
+	switch {
+	case sjis <= 0 && euc <= 0 && latin <= 0:
+		return nil
+	case latin > max(sjis, euc):
+		// A short Western word such as "Müller" also reads as a valid
+		// double-byte character
+		return charmap.Windows1252
+	case euc >= sjis:
+		// EUC-JP text is mostly also valid Shift_JIS, read as half-width
+		// katakana, so a tie goes to EUC-JP
+		return japanese.EUCJP
+	default:
+		return japanese.ShiftJIS
+	}
+}
+
+// Scores of the byte sequences met while scanning. Kana are frequent in
+// Japanese text and rare in text misread from the other encoding, so they
+// weigh more than other double-byte characters; invalid sequences weigh
+// heavily against an encoding.
+const (
+	scoreKana    = 3
+	scoreDouble  = 1
+	scoreInvalid = -10
+)
+
+func scoreShiftJIS(data []byte) int {
+	score := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c < 0x80:
+		case c >= 0xa1 && c <= 0xdf:
+			// Half-width katakana: valid, but rarely written in mail
+		case c >= 0x81 && c <= 0x9f || c >= 0xe0 && c <= 0xfc:
+			if i+1 >= len(data) {
+				score += scoreInvalid
+				break
+			}
+			t := data[i+1]
+			if t < 0x40 || t == 0x7f || t > 0xfc {
+				score += scoreInvalid
+				break
+			}
+			i++
+			// Hiragana are 0x829f-0x82f1, katakana 0x8340-0x8396
+			if c == 0x82 && t >= 0x9f && t <= 0xf1 || c == 0x83 && t >= 0x40 && t <= 0x96 {
+				score += scoreKana
+			} else {
+				score += scoreDouble
+			}
+		default:
+			score += scoreInvalid
+		}
+	}
+	return score
+}
+
+func scoreEUCJP(data []byte) int {
+	score := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c < 0x80:
+		case c == 0x8e:
+			// Half-width katakana
+			if i+1 < len(data) && data[i+1] >= 0xa1 && data[i+1] <= 0xdf {
+				i++
+			} else {
+				score += scoreInvalid
+			}
+		case c == 0x8f:
+			// JIS X 0212
+			if i+2 < len(data) && isEUCByte(data[i+1]) && isEUCByte(data[i+2]) {
+				i += 2
+				score += scoreDouble
+			} else {
+				score += scoreInvalid
+			}
+		case isEUCByte(c):
+			if i+1 >= len(data) || !isEUCByte(data[i+1]) {
+				score += scoreInvalid
+				break
+			}
+			i++
+			// Hiragana are row 0xa4, katakana row 0xa5
+			if c == 0xa4 || c == 0xa5 {
+				score += scoreKana
+			} else {
+				score += scoreDouble
+			}
+		default:
+			score += scoreInvalid
+		}
+	}
+	return score
+}
+
+// scoreLatin1 scores data as Windows-1252 text, where non-ASCII bytes are
+// mostly accented letters inside words of ASCII letters. In Japanese text
+// they come in runs instead.
+func scoreLatin1(data []byte) int {
+	isLetter := func(i int) bool {
+		if i < 0 || i >= len(data) {
+			return false
+		}
+		c := data[i] | 0x20
+		return c >= 'a' && c <= 'z'
+	}
+	score := 0
+	for i, c := range data {
+		// À-ÿ but for × and ÷
+		if c >= 0xc0 && c != 0xd7 && c != 0xf7 && (isLetter(i-1) || isLetter(i+1)) {
+			score += scoreKana
+		}
+	}
+	return score
+}
+
+func isEUCByte(c byte) bool {
+	return c >= 0xa1 && c <= 0xfe
+}
@@ -0,0 +1,50 @@
+package charset
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want encoding.Encoding
+	}{
+		{"iso-2022-jp", encode(t, japanese.ISO2022JP, "こんにちは"), japanese.ISO2022JP},
+		{"utf-8", []byte("こんにちは"), unicode.UTF8},
+		{"shift_jis kana", encode(t, japanese.ShiftJIS, "こんにちは"), japanese.ShiftJIS},
+		{"shift_jis kanji", encode(t, japanese.ShiftJIS, "第1回会議"), japanese.ShiftJIS},
+		{"shift_jis mixed", encode(t, japanese.ShiftJIS, "Re: 打ち合わせの件"), japanese.ShiftJIS},
+		{"euc-jp kana", encode(t, japanese.EUCJP, "こんにちは"), japanese.EUCJP},
+		{"euc-jp kanji", encode(t, japanese.EUCJP, "会議室予約"), japanese.EUCJP},
+		{"euc-jp mixed", encode(t, japanese.EUCJP, "Re: 打ち合わせの件"), japanese.EUCJP},
+		{"latin-1 text", encode(t, charmap.ISO8859_1, "Ceci n'est pas une pipe, à été déjà vue."), charmap.Windows1252},
+		{"binary", []byte{0x80, 0xff, 0xfd, 0xfe}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.data); got != tt.want {
+				t.Errorf("Detect(%q) = %v, want %v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDetectShortLatin1 checks that short Western words, whose accented
+// letters also read as valid Shift_JIS or EUC-JP characters, are not taken
+// for Japanese.
+func TestDetectShortLatin1(t *testing.T) {
+	for _, s := range []string{
+		"café", "Müller", "Grüße", "naïve résumé", "Señor", "Ærø", "Öl", "Straße", "crème brûlée", "Zoë",
+	} {
+		data := encode(t, charmap.ISO8859_1, s)
+		if got := Detect(data); got != charmap.Windows1252 {
+			t.Errorf("Detect(%q) = %v, want %v", data, got, charmap.Windows1252)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package ical
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Calendar is what an iCalendar object says about its events.
+type Calendar struct {
+	Method string // REQUEST, CANCEL, REPLY...; empty for a plain calendar
+	Events []Event
+}
+
+// Event is a VEVENT.
+type Event struct {
+	UID          string
+	Summary      string
+	Description  string
+	Location     string
+	Status       string // TENTATIVE, CONFIRMED or CANCELLED
+	Sequence     int
+	Organizer    *Person
+	Attendees    []Person
+	Start        *Time
+	End          *Time    // from DTEND, or DTSTART plus DURATION
+	RecurrenceID *Time    // the occurrence of a recurring event this one replaces
+	Recurrence   []string // RRULE, RDATE and EXDATE lines as written
+}
+
+// Person is an ORGANIZER or ATTENDEE.
+type Person struct {
+	Name   string // CN
+	Email  string
+	Role   string // ROLE, e.g. REQ-PARTICIPANT
+	Status string // PARTSTAT, e.g. ACCEPTED
+	RSVP   bool
+}
+
+// Time is a DATE or DATE-TIME value.
+type Time struct {
+	Value    string    // as written
+	TZID     string    // as written
+	Time     time.Time // in the resolved zone; the wall clock in UTC when Floating
+	AllDay   bool      // a DATE without time of day
+	Floating bool      // no zone given, or the TZID could not be resolved
+}
+
+// Parse reads the METHOD and the events of the first VCALENDAR in data.
+func Parse(data []byte) (*Calendar, error) {
+	root, err := ParseCalendar(data)
+	if err != nil {
+		return nil, err
+	}
+	zones := map[string]*Component{}
+	for _, c := range root.Children {
+		if c.Name == "VTIMEZONE" {
+			zones[c.Text("TZID")] = c
+		}
+	}
+
+	cal := &Calendar{Method: strings.ToUpper(root.Text("METHOD"))}
+	for _, c := range root.Children {
+		if c.Name == "VEVENT" {
+			cal.Events = append(cal.Events, parseEvent(c, zones))
+		}
+	}
+	return cal, nil
+}
+
+func parseEvent(c *Component, zones map[string]*Component) Event {
+	e := Event{
+		UID:         c.Text("UID"),
+		Summary:     c.Text("SUMMARY"),
+		Description: c.Text("DESCRIPTION"),
+		Location:    c.Text("LOCATION"),
+		Status:      strings.ToUpper(c.Text("STATUS")),
+	}
+	e.Sequence, _ = strconv.Atoi(c.Text("SEQUENCE"))
+	if p := c.Get("ORGANIZER"); p != nil {
+		organizer := parsePerson(p)
+		e.Organizer = &organizer
+	}
+	e.Start = parseTime(c.Get("DTSTART"), zones)
+	e.End = parseTime(c.Get("DTEND"), zones)
+	e.RecurrenceID = parseTime(c.Get("RECURRENCE-ID"), zones)
+	if e.End == nil && e.Start != nil {
+		if p := c.Get("DURATION"); p != nil {
+			if d, ok := parseDuration(p.Value); ok {
+				end := *e.Start
+				end.Value = ""
+				end.Time = end.Time.Add(d)
+				e.End = &end
+			}
+		}
+	}
+
+	for _, p := range c.Properties {
+		switch p.Name {
+		case "ATTENDEE":
+			e.Attendees = append(e.Attendees, parsePerson(&p))
+		case "RRULE", "RDATE", "EXDATE":
+			e.Recurrence = append(e.Recurrence, p.String())
+		}
+	}
+	return e
+}
+
+func parsePerson(p *Property) Person {
+	email := strings.TrimSpace(p.Value)
+	if len(email) >= 7 && strings.EqualFold(email[:7], "mailto:") {
+		email = email[7:]
+	}
+	return Person{
+		Name:   strings.Trim(p.Params["CN"], `"`),
+		Email:  email,
+		Role:   strings.ToUpper(p.Params["ROLE"]),
+		Status: strings.ToUpper(p.Params["PARTSTAT"]),
+		RSVP:   strings.EqualFold(p.Params["RSVP"], "TRUE"),
+	}
+}
+
+// String writes the property back as a content line.
+func (p *Property) String() string {
+	var b strings.Builder
+	b.WriteString(p.Name)
+	names := make([]string, 0, len(p.Params))
+	for name := range p.Params {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		v := p.Params[name]
+		if strings.ContainsAny(v, ";:,") {
+			v = `"` + v + `"`
+		}
+		b.WriteString(";" + name + "=" + v)
+	}
+	b.WriteString(":" + p.Value)
+	return b.String()
+}
+
+// parseTime reads a DATE or DATE-TIME property; only the first of a list of
+// values is read.
+func parseTime(p *Property, zones map[string]*Component) *Time {
+	if p == nil {
+		return nil
+	}
+	value, _, _ := strings.Cut(strings.TrimSpace(p.Value), ",")
+	t := &Time{Value: value, TZID: p.Params["TZID"]}
+
+	if strings.EqualFold(p.Params["VALUE"], "DATE") || len(value) == 8 {
+		d, err := time.Parse("20060102", value)
+		if err != nil {
+			return nil
+		}
+		t.Time, t.AllDay = d, true
+		return t
+	}
+	if strings.HasSuffix(value, "Z") {
+		u, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return nil
+		}
+		t.Time = u
+		return t
+	}
+	wall, err := time.Parse("20060102T150405", value)
+	if err != nil {
+		return nil
+	}
+	t.Time = wall
+	if loc := resolveZone(t.TZID, wall, zones); loc != nil {
+		t.Time = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+	} else {
+		t.Floating = true
+	}
+	return t
+}
+
+// resolveZone finds the zone a wall clock time in tzid is in. The zone
+// database is preferred when it knows the name, being exact; otherwise the
+// offset comes from the VTIMEZONE of that name.
+func resolveZone(tzid string, wall time.Time, zones map[string]*Component) *time.Location {
+	if tzid == "" {
+		return nil
+	}
+	// Some producers prefix the IANA name with a path of their own
+	name := tzid
+	if i := strings.Index(name, "/mozilla.org/"); i >= 0 {
+		name = name[i+len("/mozilla.org/"):]
+		if _, rest, ok := strings.Cut(name, "/"); ok {
+			name = rest
+		}
+	}
+	if name != "Local" {
+		if loc, err := time.LoadLocation(strings.TrimPrefix(name, "/")); err == nil {
+			return loc
+		}
+	}
+	if zone, ok := zones[tzid]; ok {
+		if offset, ok := zoneOffset(zone, wall); ok {
+			return time.FixedZone(tzid, offset)
+		}
+	}
+	return nil
+}
+
+// zoneOffset returns the UTC offset in seconds that a VTIMEZONE gives a
+// wall clock time: that of the STANDARD or DAYLIGHT observance which most
+// recently began.
+func zoneOffset(zone *Component, wall time.Time) (int, bool) {
+	var latest time.Time
+	offset, found := 0, false
+	earliest, earliestFrom := time.Time{}, 0
+	for _, o := range zone.Children {
+		if o.Name != "STANDARD" && o.Name != "DAYLIGHT" {
+			continue
+		}
+		to, okTo := parseOffset(o.Text("TZOFFSETTO"))
+		from, okFrom := parseOffset(o.Text("TZOFFSETFROM"))
+		start, err := time.Parse("20060102T150405", strings.TrimSpace(o.Text("DTSTART")))
+		if !okTo || err != nil {
+			continue
+		}
+		if earliest.IsZero() || start.Before(earliest) {
+			earliest = start
+			earliestFrom = to
+			if okFrom {
+				earliestFrom = from
+			}
+		}
+		for _, onset := range onsets(o, start, wall) {
+			if !onset.After(wall) && (!found || onset.After(latest)) {
+				latest, offset, found = onset, to, true
+			}
+		}
+	}
+	if !found && !earliest.IsZero() {
+		// Before the zone's first transition
+		return earliestFrom, true
+	}
+	return offset, found
+}
+
+// onsets returns when an observance began in the year of wall and the year
+// before, as far as its rule can tell, and its first onset.
+func onsets(o *Component, start, wall time.Time) []time.Time {
+	times := []time.Time{start}
+	for _, p := range o.Properties {
+		if p.Name != "RDATE" {
+			continue
+		}
+		for _, v := range strings.Split(p.Value, ",") {
+			if t, err := time.Parse("20060102T150405", strings.TrimSpace(v)); err == nil {
+				times = append(times, t)
+			}
+		}
+	}
+
+	rule := parseRule(o.Text("RRULE"))
+	if rule["FREQ"] != "YEARLY" {
+		return times
+	}
+	month, err := strconv.Atoi(rule["BYMONTH"])
+	if err != nil || month < 1 || month > 12 {
+		return times
+	}
+	var until time.Time
+	if v := rule["UNTIL"]; v != "" {
+		if u, err := time.Parse("20060102T150405Z", v); err == nil {
+			until = u
+		} else if u, err := time.Parse("20060102", v); err == nil {
+			until = u.Add(24*time.Hour - time.Second)
+		}
+	}
+	for _, year := range []int{wall.Year() - 1, wall.Year()} {
+		day, ok := ruleDay(rule, year, time.Month(month))
+		if !ok {
+			continue
+		}
+		t := time.Date(year, time.Month(month), day, start.Hour(), start.Minute(), start.Second(), 0, time.UTC)
+		if t.Before(start) || !until.IsZero() && t.After(until) {
+			continue
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// ruleDay returns the day of the month a yearly transition rule falls on:
+// BYDAY with an ordinal ("2SU", "-1SU"), BYDAY with BYMONTHDAY as older
+// producers write it, or BYMONTHDAY alone.
+func ruleDay(rule map[string]string, year int, month time.Month) (int, bool) {
+	byDay := rule["BYDAY"]
+	var monthDays []int
+	for _, v := range strings.Split(rule["BYMONTHDAY"], ",") {
+		if n, err := strconv.Atoi(v); err == nil {
+			monthDays = append(monthDays, n)
+		}
+	}
+	if byDay == "" {
+		if len(monthDays) == 1 && monthDays[0] > 0 {
+			return monthDays[0], true
+		}
+		return 0, false
+	}
+	if len(byDay) < 2 {
+		return 0, false
+	}
+	weekday, ok := weekdays[byDay[len(byDay)-2:]]
+	if !ok {
+		return 0, false
+	}
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if n, err := strconv.Atoi(byDay[:len(byDay)-2]); err == nil && n != 0 {
+		if n > 0 {
+			first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Weekday()
+			day := 1 + (int(weekday)-int(first)+7)%7 + (n-1)*7
+			return day, day <= lastDay
+		}
+		last := time.Date(year, month, lastDay, 0, 0, 0, 0, time.UTC).Weekday()
+		day := lastDay - (int(last)-int(weekday)+7)%7 + (n+1)*7
+		return day, day >= 1
+	}
+	for _, d := range monthDays {
+		if d >= 1 && d <= lastDay && time.Date(year, month, d, 0, 0, 0, 0, time.UTC).Weekday() == weekday {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+func parseRule(value string) map[string]string {
+	rule := map[string]string{}
+	for _, part := range strings.Split(value, ";") {
+		if k, v, ok := strings.Cut(part, "="); ok {
+			rule[strings.ToUpper(strings.TrimSpace(k))] = strings.ToUpper(strings.TrimSpace(v))
+		}
+	}
+	return rule
+}
+
+// parseOffset reads a UTC offset such as "+0900" or "-043000" in seconds.
+func parseOffset(value string) (int, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) != 5 && len(value) != 7 {
+		return 0, false
+	}
+	sign := 1
+	switch value[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, false
+	}
+	n := 0
+	for i, unit := range []int{3600, 60, 1} {
+		if 1+2*i >= len(value) {
+			break
+		}
+		v, err := strconv.Atoi(value[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, false
+		}
+		n += v * unit
+	}
+	return sign * n, true
+}
+
+// parseDuration reads a DURATION such as "PT1H30M", "P1D" or "-P2W".
+func parseDuration(value string) (time.Duration, bool) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(value, "-"):
+		sign, value = -1, value[1:]
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	}
+	if !strings.HasPrefix(value, "P") || len(value) < 3 {
+		return 0, false
+	}
+	var d time.Duration
+	n, inTime := 0, false
+	digits := false
+	for _, c := range value[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+			digits = true
+			continue
+		case c == 'T':
+			inTime = true
+			continue
+		}
+		if !digits {
+			return 0, false
+		}
+		unit := map[rune]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour}[c]
+		if inTime {
+			unit = map[rune]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}[c]
+		}
+		if unit == 0 {
+			return 0, false
+		}
+		d += time.Duration(n) * unit
+		n, digits = 0, false
+	}
+	if digits {
+		return 0, false
+	}
+	return sign * d, true
+}
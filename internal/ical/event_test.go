@@ -0,0 +1,193 @@
+package ical
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Outlook writes Windows zone names with the rules of the zone.
+const europeZone = `BEGIN:VTIMEZONE
+TZID:W. Europe Standard Time
+BEGIN:STANDARD
+DTSTART:16010101T030000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=10
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:16010101T020000
+TZOFFSETFROM:+0100
+TZOFFSETTO:+0200
+RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=3
+END:DAYLIGHT
+END:VTIMEZONE
+`
+
+func calendar(lines ...string) []byte {
+	return []byte(strings.ReplaceAll("BEGIN:VCALENDAR\n"+strings.Join(lines, "\n")+"\nEND:VCALENDAR\n", "\n", "\r\n"))
+}
+
+func TestParse(t *testing.T) {
+	cal, err := Parse(calendar(
+		"METHOD:request",
+		"BEGIN:VEVENT",
+		"UID:1@example.com",
+		`SUMMARY:Review\, part 2`,
+		"DESCRIPTION:Agenda:\\n- items",
+		"STATUS:confirmed",
+		"SEQUENCE:3",
+		`ORGANIZER;CN="Boss, The":MAILTO:boss@example.com`,
+		"ATTENDEE;CN=Ann;ROLE=req-participant;PARTSTAT=accepted;RSVP=TRUE:mailto:ann@example.com",
+		"ATTENDEE:mailto:bob@example.com",
+		"DTSTART:20240301T090000Z",
+		"DURATION:PT1H30M",
+		"RRULE:FREQ=WEEKLY;COUNT=4",
+		"EXDATE:20240308T090000Z",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cal.Method != "REQUEST" || len(cal.Events) != 1 {
+		t.Fatalf("got METHOD %q and %d events", cal.Method, len(cal.Events))
+	}
+	e := cal.Events[0]
+	if e.Summary != "Review, part 2" || e.Description != "Agenda:\n- items" || e.Status != "CONFIRMED" || e.Sequence != 3 {
+		t.Errorf("event = %+v", e)
+	}
+	if e.Organizer == nil || *e.Organizer != (Person{Name: "Boss, The", Email: "boss@example.com"}) {
+		t.Errorf("organizer = %+v", e.Organizer)
+	}
+	want := []Person{
+		{Name: "Ann", Email: "ann@example.com", Role: "REQ-PARTICIPANT", Status: "ACCEPTED", RSVP: true},
+		{Email: "bob@example.com"},
+	}
+	if !slices.Equal(e.Attendees, want) {
+		t.Errorf("attendees = %+v, want %+v", e.Attendees, want)
+	}
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	if e.Start == nil || !e.Start.Time.Equal(start) || e.End == nil || !e.End.Time.Equal(start.Add(90*time.Minute)) {
+		t.Errorf("start %+v, end %+v", e.Start, e.End)
+	}
+	if want := []string{"RRULE:FREQ=WEEKLY;COUNT=4", "EXDATE:20240308T090000Z"}; !slices.Equal(e.Recurrence, want) {
+		t.Errorf("recurrence = %q, want %q", e.Recurrence, want)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		want     string // RFC 3339
+		allDay   bool
+		floating bool
+	}{
+		{"UTC", "DTSTART:20240301T090000Z", "2024-03-01T09:00:00Z", false, false},
+		{"all day", "DTSTART;VALUE=DATE:20240301", "2024-03-01T00:00:00Z", true, false},
+		{"floating", "DTSTART:20240301T090000", "2024-03-01T09:00:00Z", false, true},
+		{"IANA zone", "DTSTART;TZID=Asia/Tokyo:20240301T090000", "2024-03-01T09:00:00+09:00", false, false},
+		{"Mozilla zone", "DTSTART;TZID=/mozilla.org/20070129_1/Asia/Tokyo:20240301T090000", "2024-03-01T09:00:00+09:00", false, false},
+		{"VTIMEZONE in winter", "DTSTART;TZID=W. Europe Standard Time:20240115T100000", "2024-01-15T10:00:00+01:00", false, false},
+		{"VTIMEZONE in summer", "DTSTART;TZID=W. Europe Standard Time:20240715T100000", "2024-07-15T10:00:00+02:00", false, false},
+		{"after the change to summer time", "DTSTART;TZID=W. Europe Standard Time:20240331T030000", "2024-03-31T03:00:00+02:00", false, false},
+		{"before the change back", "DTSTART;TZID=W. Europe Standard Time:20241027T020000", "2024-10-27T02:00:00+02:00", false, false},
+		{"unknown zone", "DTSTART;TZID=Nowhere:20240301T090000", "2024-03-01T09:00:00Z", false, true},
+		{"first of a list", "DTSTART:20240301T090000Z,20240302T090000Z", "2024-03-01T09:00:00Z", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal, err := Parse(calendar(europeZone, "BEGIN:VEVENT", tt.line, "END:VEVENT"))
+			if err != nil || len(cal.Events) != 1 {
+				t.Fatalf("Parse: %v", err)
+			}
+			got := cal.Events[0].Start
+			if got == nil {
+				t.Fatal("no start")
+			}
+			if s := got.Time.Format(time.RFC3339); s != tt.want || got.AllDay != tt.allDay || got.Floating != tt.floating {
+				t.Errorf("got %s all day %v floating %v, want %s %v %v", s, got.AllDay, got.Floating, tt.want, tt.allDay, tt.floating)
+			}
+		})
+	}
+	for _, line := range []string{"DTSTART:2024-03-01", "DTSTART:20241301T090000Z", "DTSTART:"} {
+		cal, _ := Parse(calendar("BEGIN:VEVENT", line, "END:VEVENT"))
+		if got := cal.Events[0].Start; got != nil {
+			t.Errorf("%q read as %+v, want nil", line, got)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"PT1H30M", 90 * time.Minute, true},
+		{"P1D", 24 * time.Hour, true},
+		{"P1DT12H", 36 * time.Hour, true},
+		{"-P2W", -14 * 24 * time.Hour, true},
+		{"+PT15S", 15 * time.Second, true},
+		{"pt5m", 5 * time.Minute, true},
+		{"P", 0, false},
+		{"PT", 0, false},
+		{"P1H", 0, false},
+		{"PT1", 0, false},
+		{"PTH", 0, false},
+		{"1H", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseDuration(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{"+0900", 9 * 3600, true},
+		{"-0430", -(4*3600 + 30*60), true},
+		{"+053045", 5*3600 + 30*60 + 45, true},
+		{"0900", 0, false},
+		{"+09", 0, false},
+		{"+09x0", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseOffset(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseOffset(%q) = %d, %v, want %d, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRuleDay(t *testing.T) {
+	tests := []struct {
+		rule  string
+		month time.Month
+		want  int
+		ok    bool
+	}{
+		{"BYDAY=-1SU", time.March, 31, true},
+		{"BYDAY=-1SU", time.October, 27, true},
+		{"BYDAY=2SU", time.March, 10, true},
+		{"BYDAY=1SU", time.November, 3, true},
+		{"BYDAY=5FR", time.February, 0, false},
+		{"BYDAY=SU;BYMONTHDAY=8,9,10,11,12,13,14", time.March, 10, true},
+		{"BYMONTHDAY=15", time.April, 15, true},
+		{"BYDAY=XX", time.March, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ruleDay(parseRule(tt.rule), 2024, tt.month)
+		if got != tt.want && tt.ok || ok != tt.ok {
+			t.Errorf("ruleDay(%q, 2024, %v) = %d, %v, want %d, %v", tt.rule, tt.month, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Package ical reads the events of iCalendar (RFC 5545) data, as carried by
+// meeting invitations.
+//
+// Only what is needed to describe an invitation is read: the METHOD of the
+// calendar and, for each VEVENT, its summary, people, times and recurrence.
+// Times in a TZID are resolved with the system's zone database when it
+// knows the name, and otherwise with the VTIMEZONE of the same name in the
+// calendar, as Outlook's zone names are not IANA names.
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// Component is a BEGIN/END block such as VCALENDAR, VEVENT or VTIMEZONE.
+type Component struct {
+	Name       string
+	Properties []Property
+	Children   []*Component
+}
+
+// Property is a content line. Names and parameter names are upper case;
+// only the first value of a parameter is kept.
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string // as written, escapes included
+}
+
+// Get returns the first property with the given name.
+func (c *Component) Get(name string) *Property {
+	for i := range c.Properties {
+		if c.Properties[i].Name == name {
+			return &c.Properties[i]
+		}
+	}
+	return nil
+}
+
+// Text returns the unescaped text value of the first property with the
+// given name, or "".
+func (c *Component) Text(name string) string {
+	p := c.Get(name)
+	if p == nil {
+		return ""
+	}
+	return p.Text()
+}
+
+// Text returns the value with the escapes of TEXT values resolved.
+func (p *Property) Text() string {
+	if !strings.Contains(p.Value, `\`) {
+		return p.Value
+	}
+	var b strings.Builder
+	for i := 0; i < len(p.Value); i++ {
+		c := p.Value[i]
+		if c == '\\' && i+1 < len(p.Value) {
+			i++
+			switch p.Value[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(p.Value[i])
+			}
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+var errNoCalendar = errors.New("ical: no VCALENDAR found")
+
+// ParseCalendar reads the first VCALENDAR in data. Unknown and malformed
+// lines are skipped, and components left open at the end are closed.
+func ParseCalendar(data []byte) (*Component, error) {
+	var stack []*Component
+	var calendar *Component
+	for _, line := range unfold(data) {
+		p, ok := parseLine(line)
+		if !ok {
+			continue
+		}
+		switch p.Name {
+		case "BEGIN":
+			c := &Component{Name: strings.ToUpper(p.Value)}
+			if len(stack) == 0 {
+				if c.Name != "VCALENDAR" {
+					continue
+				}
+				calendar = c
+			} else {
+				top := stack[len(stack)-1]
+				top.Children = append(top.Children, c)
+			}
+			stack = append(stack, c)
+		case "END":
+			// Close up to the matching BEGIN, forgiving missing ENDs
+			name := strings.ToUpper(p.Value)
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].Name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			if calendar != nil && len(stack) == 0 {
+				return calendar, nil
+			}
+		default:
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				top.Properties = append(top.Properties, p)
+			}
+		}
+	}
+	if calendar == nil {
+		return nil, errNoCalendar
+	}
+	return calendar, nil
+}
+
+// unfold splits data into content lines, joining folded continuation lines
+// (those starting with a space or tab) to the line before.
+func unfold(data []byte) []string {
+	var lines []string
+	for _, raw := range bytes.Split(data, []byte("\n")) {
+		raw = bytes.TrimSuffix(raw, []byte("\r"))
+		if len(raw) > 0 && (raw[0] == ' ' || raw[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += string(raw[1:])
+			continue
+		}
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+		lines = append(lines, string(raw))
+	}
+	return lines
+}
+
+// parseLine splits a content line, name *(";" param) ":" value, where
+// parameter values may be quoted to hold ":", ";" and ",".
+func parseLine(line string) (Property, bool) {
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return Property{}, false
+	}
+	p := Property{Name: strings.ToUpper(strings.TrimSpace(line[:i])), Params: map[string]string{}}
+	for line[i] == ';' {
+		line = line[i+1:]
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return Property{}, false
+		}
+		name := strings.ToUpper(strings.TrimSpace(line[:eq]))
+		line = line[eq+1:]
+		var values []string
+		for {
+			var v string
+			if strings.HasPrefix(line, `"`) {
+				end := strings.IndexByte(line[1:], '"')
+				if end < 0 {
+					return Property{}, false
+				}
+				v, line = line[1:end+1], line[end+2:]
+			} else {
+				end := strings.IndexAny(line, ",;:")
+				if end < 0 {
+					return Property{}, false
+				}
+				v, line = line[:end], line[end:]
+			}
+			values = append(values, v)
+			if !strings.HasPrefix(line, ",") {
+				break
+			}
+			line = line[1:]
+		}
+		if _, ok := p.Params[name]; !ok {
+			p.Params[name] = values[0]
+		}
+		i = strings.IndexAny(line, ";:")
+		if i != 0 {
+			return Property{}, false
+		}
+	}
+	p.Value = line[i+1:]
+	return p, true
+}
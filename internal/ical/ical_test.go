@@ -0,0 +1,146 @@
+package ical
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestUnfold(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+	}{
+		{"A:1\r\nB:2\r\n", []string{"A:1", "B:2"}},
+		{"DESCRIPTION:long\r\n  line\r\n\tand more\r\n", []string{"DESCRIPTION:long line" + "and more"}},
+		{"A:1\n b\nC:3", []string{"A:1b", "C:3"}},
+		// A fold may split a UTF-8 sequence
+		{"SUMMARY:\xe4\xbc\r\n \x9a\xe8\xad\xb0\r\n", []string{"SUMMARY:会議"}},
+		{"\r\n \r\nA:1\r\n\r\n", []string{"A:1"}},
+		{" orphan\r\nA:1", []string{" orphan", "A:1"}},
+	}
+	for _, tt := range tests {
+		if got := unfold([]byte(tt.data)); !slices.Equal(got, tt.want) {
+			t.Errorf("unfold(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want Property
+		ok   bool
+	}{
+		{
+			line: "summary:Lunch: noon",
+			want: Property{Name: "SUMMARY", Params: map[string]string{}, Value: "Lunch: noon"},
+			ok:   true,
+		},
+		{
+			line: `ATTENDEE;CN="Doe, John";role=REQ-PARTICIPANT;RSVP=TRUE:mailto:john@example.com`,
+			want: Property{Name: "ATTENDEE", Params: map[string]string{
+				"CN": "Doe, John", "ROLE": "REQ-PARTICIPANT", "RSVP": "TRUE",
+			}, Value: "mailto:john@example.com"},
+			ok: true,
+		},
+		{
+			line: `ORGANIZER;SENT-BY="mailto:a@example.com";CN=Boss:mailto:boss@example.com`,
+			want: Property{Name: "ORGANIZER", Params: map[string]string{
+				"SENT-BY": "mailto:a@example.com", "CN": "Boss",
+			}, Value: "mailto:boss@example.com"},
+			ok: true,
+		},
+		{
+			line: `ATTENDEE;MEMBER="mailto:a@x;b","mailto:c@x":mailto:d@x`,
+			want: Property{Name: "ATTENDEE", Params: map[string]string{"MEMBER": "mailto:a@x;b"}, Value: "mailto:d@x"},
+			ok:   true,
+		},
+		{
+			line: "DTSTART;TZID=Tokyo Standard Time;TZID=Other:20240101T090000",
+			want: Property{Name: "DTSTART", Params: map[string]string{"TZID": "Tokyo Standard Time"}, Value: "20240101T090000"},
+			ok:   true,
+		},
+		{line: `ATTENDEE;CN="unterminated:mailto:a@x`},
+		{line: `ATTENDEE;CN="quoted"junk:mailto:a@x`},
+		{line: "ATTENDEE;CN:mailto:a@x"},
+		{line: ":value"},
+		{line: "no colon"},
+	}
+	for _, tt := range tests {
+		got, ok := parseLine(tt.line)
+		if ok != tt.ok || ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLine(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPropertyText(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"plain", "plain"},
+		{`a\, b\; c\nd\Ne\\f`, "a, b; c\nd\ne\\f"},
+		{`trailing\`, `trailing\`},
+	}
+	for _, tt := range tests {
+		p := Property{Value: tt.value}
+		if got := p.Text(); got != tt.want {
+			t.Errorf("Text(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPropertyString(t *testing.T) {
+	for _, line := range []string{
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE",
+		`ATTENDEE;CN="Doe, John";ROLE=CHAIR:mailto:john@example.com`,
+		"EXDATE;TZID=Asia/Tokyo:20240108T090000,20240115T090000",
+	} {
+		p, ok := parseLine(line)
+		if !ok {
+			t.Fatalf("parseLine(%q) failed", line)
+		}
+		if got := p.String(); got != line {
+			t.Errorf("String() = %q, want %q", got, line)
+		}
+	}
+}
+
+func TestParseCalendar(t *testing.T) {
+	data := "X-BEFORE:ignored\r\n" +
+		"BEGIN:VEVENT\r\nUID:outside\r\nEND:VEVENT\r\n" +
+		"BEGIN:VCALENDAR\r\n" +
+		"METHOD:REQUEST\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:one\r\n" +
+		"BEGIN:VALARM\r\n" +
+		"ACTION:DISPLAY\r\n" +
+		"END:VEVENT\r\n" + // VALARM left open
+		"garbage line\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:two\r\n" +
+		"END:VCALENDAR\r\n" +
+		"BEGIN:VCALENDAR\r\nMETHOD:CANCEL\r\nEND:VCALENDAR\r\n"
+	cal, err := ParseCalendar([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cal.Name != "VCALENDAR" || cal.Text("METHOD") != "REQUEST" {
+		t.Fatalf("got %s with METHOD %q", cal.Name, cal.Text("METHOD"))
+	}
+	var uids []string
+	for _, c := range cal.Children {
+		uids = append(uids, c.Name+" "+c.Text("UID"))
+	}
+	if want := []string{"VEVENT one", "VEVENT two"}; !slices.Equal(uids, want) {
+		t.Errorf("children = %q, want %q", uids, want)
+	}
+	if alarms := cal.Children[0].Children; len(alarms) != 1 || alarms[0].Text("ACTION") != "DISPLAY" {
+		t.Errorf("VALARM = %+v", alarms)
+	}
+
+	if _, err := ParseCalendar([]byte("BEGIN:VEVENT\r\nEND:VEVENT\r\n")); err == nil {
+		t.Error("ParseCalendar without VCALENDAR succeeded")
+	}
+}
@@ -0,0 +1,234 @@
+package mboxheader
+
+import (
+	"sort"
+	"strings"
+)
+
+// System flags. They are stored as letters in the Status and X-Status
+// fields, following mutt, Dovecot and Thunderbird; any other flag is a
+// keyword kept in X-Keywords.
+const (
+	FlagSeen     = "seen"     // Status R
+	FlagOld      = "old"      // Status O: no longer new, but not necessarily read
+	FlagDeleted  = "deleted"  // Status D; X-Status D as written by Dovecot is read too
+	FlagAnswered = "answered" // X-Status A
+	FlagFlagged  = "flagged"  // X-Status F
+	FlagDraft    = "draft"    // X-Status T
+)
+
+type flagLetter struct {
+	letter byte
+	flag   string
+}
+
+var (
+	statusLetters  = []flagLetter{{'R', FlagSeen}, {'O', FlagOld}, {'D', FlagDeleted}}
+	xStatusLetters = []flagLetter{{'A', FlagAnswered}, {'F', FlagFlagged}, {'T', FlagDraft}, {'D', FlagDeleted}}
+)
+
+// Flags is the set of flags of a message.
+type Flags map[string]bool
+
+// ParseFlags builds the flag set from the values of the Status, X-Status
+// and X-Keywords fields.
+func ParseFlags(status, xStatus, keywords string) Flags {
+	flags := Flags{}
+	for _, l := range statusLetters {
+		if strings.IndexByte(status, l.letter) != -1 {
+			flags[l.flag] = true
+		}
+	}
+	for _, l := range xStatusLetters {
+		if strings.IndexByte(xStatus, l.letter) != -1 {
+			flags[l.flag] = true
+		}
+	}
+	for _, keyword := range splitKeywords(keywords) {
+		flags[keyword] = true
+	}
+	return flags
+}
+
+// HeaderFlags returns the flags recorded in a raw header block.
+func HeaderFlags(headers string) Flags {
+	h := NewParsedMailHeaders(headers)
+	status, _ := h.GetFieldValue("status")
+	xStatus, _ := h.GetFieldValue("x-status")
+	keywords, _ := h.GetFieldValue("x-keywords")
+	return ParseFlags(status, xStatus, keywords)
+}
+
+// Has reports whether flag is set.
+func (f Flags) Has(flag string) bool {
+	return f[flag]
+}
+
+// List returns the flags in sorted order.
+func (f Flags) List() []string {
+	list := make([]string, 0, len(f))
+	for flag, set := range f {
+		if set {
+			list = append(list, flag)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// IsSystemFlag reports whether flag is stored in Status or X-Status.
+func IsSystemFlag(flag string) bool {
+	switch flag {
+	case FlagSeen, FlagOld, FlagDeleted, FlagAnswered, FlagFlagged, FlagDraft:
+		return true
+	}
+	return false
+}
+
+// NormalizeFlag returns the canonical form of a flag name: system flags are
+// matched case-insensitively. It reports false for names that cannot be
+// stored as a keyword.
+func NormalizeFlag(name string) (string, bool) {
+	if lower := strings.ToLower(name); IsSystemFlag(lower) {
+		return lower, true
+	}
+	if name == "" || strings.ContainsAny(name, " \t\r\n,") {
+		return "", false
+	}
+	for _, r := range name {
+		if r < 0x21 || r > 0x7e {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// UpdateFlags sets the flags in add and clears those in remove, rewriting the
+// Status, X-Status and X-Keywords fields of headers. Letters and keywords it
+// does not know are kept. It reports whether headers changed.
+func UpdateFlags(headers string, add, remove []string) (string, bool) {
+	h := NewParsedMailHeaders(headers)
+	status, _ := h.GetFieldValue("status")
+	xStatus, _ := h.GetFieldValue("x-status")
+	keywords, _ := h.GetFieldValue("x-keywords")
+
+	flags := ParseFlags(status, xStatus, keywords)
+	before := strings.Join(flags.List(), " ")
+	for _, flag := range add {
+		flags[flag] = true
+	}
+	for _, flag := range remove {
+		delete(flags, flag)
+	}
+	if strings.Join(flags.List(), " ") == before {
+		return headers, false
+	}
+
+	// Deleted stays in the field it was found in
+	deletedInXStatus := strings.IndexByte(xStatus, 'D') != -1 && strings.IndexByte(status, 'D') == -1
+
+	newStatus := otherLetters(status, statusLetters)
+	if flags[FlagSeen] {
+		// N and U mark new and unread messages for some tools
+		newStatus = strings.NewReplacer("N", "", "U", "").Replace(newStatus)
+	}
+	for _, l := range statusLetters {
+		if flags[l.flag] && (l.flag != FlagDeleted || !deletedInXStatus) {
+			newStatus += string(l.letter)
+		}
+	}
+	newXStatus := otherLetters(xStatus, xStatusLetters)
+	for _, l := range xStatusLetters {
+		if flags[l.flag] && (l.flag != FlagDeleted || deletedInXStatus) {
+			newXStatus += string(l.letter)
+		}
+	}
+
+	var newKeywords []string
+	for _, flag := range flags.List() {
+		if !IsSystemFlag(flag) {
+			newKeywords = append(newKeywords, flag)
+		}
+	}
+	separator := " "
+	if strings.Contains(keywords, ",") {
+		separator = ", "
+	}
+
+	headers = setField(headers, "Status", newStatus)
+	headers = setField(headers, "X-Status", newXStatus)
+	headers = setField(headers, "X-Keywords", strings.Join(newKeywords, separator))
+	return headers, true
+}
+
+// otherLetters returns the letters of value not described by known.
+func otherLetters(value string, known []flagLetter) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == ' ' || c == '\t' {
+			continue
+		}
+		isKnown := false
+		for _, l := range known {
+			if l.letter == c {
+				isKnown = true
+				break
+			}
+		}
+		if !isKnown {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func splitKeywords(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// setField replaces the first field called name (with its folded lines) by
+// "name: value", appends it when missing, and removes it when value is empty.
+// The existing spelling of the field name is kept.
+func setField(headers, name, value string) string {
+	lines := strings.SplitAfter(headers, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	for i, line := range lines {
+		colon := strings.IndexByte(line, ':')
+		if colon == -1 || !strings.EqualFold(strings.TrimSpace(line[:colon]), name) || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		end := i + 1
+		for end < len(lines) && (strings.HasPrefix(lines[end], " ") || strings.HasPrefix(lines[end], "\t")) {
+			end++
+		}
+		lineEnd := "\n"
+		if strings.HasSuffix(strings.TrimSuffix(lines[end-1], "\n"), "\r") {
+			lineEnd = "\r\n"
+		}
+		var replacement []string
+		if value != "" {
+			replacement = []string{line[:colon] + ": " + value + lineEnd}
+		}
+		lines = append(lines[:i], append(replacement, lines[end:]...)...)
+		return strings.Join(lines, "")
+	}
+
+	if value == "" {
+		return headers
+	}
+	// A new field takes the line ending of the others
+	lineEnd := "\n"
+	if len(lines) > 0 && strings.HasSuffix(lines[0], "\r\n") {
+		lineEnd = "\r\n"
+	}
+	if headers != "" && !strings.HasSuffix(headers, "\n") {
+		headers += lineEnd
+	}
+	return headers + name + ": " + value + lineEnd
+}
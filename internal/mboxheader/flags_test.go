@@ -0,0 +1,145 @@
+package mboxheader
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		status, xStatus, keywords string
+		want                      []string
+	}{
+		{"", "", "", []string{}},
+		{"RO", "", "", []string{FlagOld, FlagSeen}},
+		{"O", "AF", "", []string{FlagAnswered, FlagFlagged, FlagOld}},
+		{"", "D", "", []string{FlagDeleted}},
+		{"RD", "T", "$Label1, work", []string{"$Label1", FlagDeleted, FlagDraft, FlagSeen, "work"}},
+		{"NU", "", "a b\tc", []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		got := ParseFlags(tt.status, tt.xStatus, tt.keywords).List()
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseFlags(%q, %q, %q) = %q, want %q", tt.status, tt.xStatus, tt.keywords, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		headers     string
+		add, remove []string
+		want        string
+		changed     bool
+	}{
+		{
+			name:    "mark read appends Status",
+			headers: "Subject: hi\n",
+			add:     []string{FlagSeen, FlagOld},
+			want:    "Subject: hi\nStatus: RO\n",
+			changed: true,
+		},
+		{
+			name:    "new and unread letters go once seen",
+			headers: "Status: NU\nSubject: hi\n",
+			add:     []string{FlagSeen},
+			want:    "Status: R\nSubject: hi\n",
+			changed: true,
+		},
+		{
+			name:    "unknown letters are kept",
+			headers: "Status: OQ\n",
+			add:     []string{FlagDeleted},
+			want:    "Status: QOD\n",
+			changed: true,
+		},
+		{
+			name:    "flagged and answered go to X-Status",
+			headers: "Status: RO\nX-Status: \n",
+			add:     []string{FlagFlagged, FlagAnswered},
+			want:    "Status: RO\nX-Status: AF\n",
+			changed: true,
+		},
+		{
+			name:    "removing the last letter drops the field",
+			headers: "Subject: hi\nX-Status: F\nTo: a\n",
+			remove:  []string{FlagFlagged},
+			want:    "Subject: hi\nTo: a\n",
+			changed: true,
+		},
+		{
+			name:    "Dovecot deleted stays in X-Status",
+			headers: "Status: RO\nX-Status: D\n",
+			add:     []string{FlagFlagged},
+			want:    "Status: RO\nX-Status: FD\n",
+			changed: true,
+		},
+		{
+			name:    "keywords keep their separator",
+			headers: "X-Keywords: work, $Label1\n",
+			add:     []string{"home"},
+			want:    "X-Keywords: $Label1, home, work\n",
+			changed: true,
+		},
+		{
+			name:    "folded field is replaced whole",
+			headers: "X-Keywords: a\n b\nSubject: hi\n",
+			remove:  []string{"a"},
+			want:    "X-Keywords: b\nSubject: hi\n",
+			changed: true,
+		},
+		{
+			name:    "field name spelling is kept",
+			headers: "STATUS: O\n",
+			add:     []string{FlagSeen},
+			want:    "STATUS: RO\n",
+			changed: true,
+		},
+		{
+			name:    "CRLF headers stay CRLF",
+			headers: "Subject: hi\r\nStatus: O\r\n",
+			add:     []string{FlagSeen, FlagFlagged},
+			want:    "Subject: hi\r\nStatus: RO\r\nX-Status: F\r\n",
+			changed: true,
+		},
+		{
+			name:    "nothing to do",
+			headers: "Status: RO\n",
+			add:     []string{FlagSeen},
+			remove:  []string{FlagDeleted},
+			want:    "Status: RO\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := UpdateFlags(tt.headers, tt.add, tt.remove)
+			if got != tt.want || changed != tt.changed {
+				t.Errorf("got %q, %v, want %q, %v", got, changed, tt.want, tt.changed)
+			}
+		})
+	}
+}
+
+func TestNormalizeFlag(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"Seen", FlagSeen, true},
+		{"DELETED", FlagDeleted, true},
+		{"$Label1", "$Label1", true},
+		{"Work", "Work", true},
+		{"", "", false},
+		{"two words", "", false},
+		{"a,b", "", false},
+		{"仕事", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := NormalizeFlag(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("NormalizeFlag(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -84,3 +84,18 @@ func (h ParsedMailHeaders) GetFieldValue(key string) (string, bool) {
 	}
 	return strings.TrimSpace(strings.Join(h.fields[index].values, " ")), true
 }
+
+// Fields returns the header fields in their original order.
+func (h ParsedMailHeaders) Fields() []ParsedHeaderField {
+	return h.fields
+}
+
+// Name returns the field-name as written in the message.
+func (f ParsedHeaderField) Name() string {
+	return f.name
+}
+
+// Value returns the unfolded field-body.
+func (f ParsedHeaderField) Value() string {
+	return strings.TrimSpace(strings.Join(f.values, " "))
+}
@@ -0,0 +1,222 @@
+package mboxheader
+
+import (
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Received is a parsed Received field (RFC 5321 4.4), as written by one
+// relay on the way. The free-form comments relays add are read for the
+// addresses and TLS details common MTAs put there.
+type Received struct {
+	From       string // the name the sending host gave, or its address literal
+	FromRDNS   string // the sending host's name found by reverse lookup
+	FromIP     string
+	By         string // the receiving host
+	ByIP       string
+	Via        string
+	With       string // the protocol, e.g. ESMTPS
+	ID         string
+	For        string
+	TLS        bool
+	TLSVersion string
+	Cipher     string
+	Time       time.Time // zero if the date is missing or unreadable
+	DateText   string
+}
+
+var (
+	addressLiteralRegex = regexp.MustCompile(`\[(?:IPv6:)?([0-9A-Fa-f:.]+)\]`)
+	tlsVersionRegex     = regexp.MustCompile(`(?i)\b(TLS ?v?1[._]?[0-3]?|SSLv[23])\b`)
+	cipherRegex         = regexp.MustCompile(`(?i)\bcipher[= ]+([A-Z0-9_-]+)`)
+	tlsProtocolRegex    = regexp.MustCompile(`(?i)^(E?SMTP|LMTP|UTF8SMTP|UTF8LMTP)SA?$`)
+)
+
+// ParseReceived reads the value of a Received field. Parts it does not
+// recognize are skipped.
+func ParseReceived(value string) Received {
+	var r Received
+	clauses := value
+	if i := strings.LastIndex(value, ";"); i >= 0 {
+		clauses = value[:i]
+		r.DateText = strings.TrimSpace(value[i+1:])
+		r.Time = parseReceivedDate(r.DateText)
+	}
+
+	var keyword string
+	var afterComment bool
+	var tlsNext bool
+	for _, tok := range tokenizeReceived(clauses) {
+		if strings.HasPrefix(tok, "(") {
+			comment := strings.TrimSpace(tok[1 : len(tok)-1])
+			r.readComment(keyword, comment)
+			afterComment = true
+			continue
+		}
+		switch lower := strings.ToLower(tok); lower {
+		case "from", "by", "via", "with", "id", "for":
+			keyword, afterComment = lower, false
+			continue
+		case "tls":
+			// Exim: "tls <cipher>"
+			r.TLS, tlsNext = true, true
+			continue
+		}
+		if tlsNext {
+			r.Cipher, tlsNext = tok, false
+			continue
+		}
+		switch keyword {
+		case "from":
+			if r.From == "" {
+				r.From = tok
+				if m := addressLiteralRegex.FindStringSubmatch(tok); m != nil {
+					r.FromIP = m[1]
+				}
+			}
+		case "by":
+			if r.By == "" {
+				r.By = tok
+				if m := addressLiteralRegex.FindStringSubmatch(tok); m != nil {
+					r.ByIP = m[1]
+				}
+			}
+		case "via":
+			if r.Via == "" {
+				r.Via = tok
+			}
+		case "with":
+			// "with Microsoft SMTP Server (...)": the words up to a comment
+			if !afterComment {
+				r.With = strings.TrimSpace(r.With + " " + tok)
+			}
+		case "id":
+			if r.ID == "" {
+				r.ID = tok
+			}
+		case "for":
+			if r.For == "" {
+				r.For = strings.Trim(tok, "<>")
+			}
+		}
+	}
+
+	// RFC 3848 protocol names: ESMTPS, ESMTPSA, LMTPS and so on
+	if tlsProtocolRegex.MatchString(r.With) {
+		r.TLS = true
+	}
+	return r
+}
+
+// readComment takes what it can from a comment after the given keyword:
+// the sending host's reverse name and address, and TLS details.
+func (r *Received) readComment(keyword, comment string) {
+	if m := tlsVersionRegex.FindStringSubmatch(comment); m != nil {
+		r.TLS = true
+		if r.TLSVersion == "" {
+			r.TLSVersion = m[1]
+		}
+	}
+	if m := cipherRegex.FindStringSubmatch(comment); m != nil {
+		r.TLS = true
+		if r.Cipher == "" {
+			r.Cipher = m[1]
+		}
+	}
+
+	ip := ""
+	if m := addressLiteralRegex.FindStringSubmatch(comment); m != nil {
+		ip = m[1]
+	} else if parsed := net.ParseIP(strings.Fields(comment + " x")[0]); parsed != nil {
+		// Exchange writes the bare address
+		ip = parsed.String()
+	}
+	switch keyword {
+	case "from":
+		if ip != "" && r.FromIP == "" {
+			r.FromIP = ip
+		}
+		// "name [ip]" or "helo=name" from Exim
+		if r.FromRDNS == "" && ip != "" {
+			if name := strings.Fields(comment)[0]; !strings.HasPrefix(name, "[") && net.ParseIP(name) == nil && name != "unknown" {
+				r.FromRDNS = strings.TrimSuffix(name, ".")
+			}
+		}
+		if r.From == "" || strings.HasPrefix(r.From, "[") {
+			if helo, ok := strings.CutPrefix(comment, "helo="); ok {
+				r.From = strings.Fields(helo + " ")[0]
+			}
+		}
+	case "by":
+		if ip != "" && r.ByIP == "" {
+			r.ByIP = ip
+		}
+	}
+}
+
+// tokenizeReceived splits clauses into words and parenthesized comments,
+// which may nest.
+func tokenizeReceived(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			depth, j := 0, i
+			for ; j < len(s); j++ {
+				if s[j] == '\\' {
+					j++
+				} else if s[j] == '(' {
+					depth++
+				} else if s[j] == ')' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if j >= len(s) {
+				// Unclosed: the rest is the comment
+				tokens = append(tokens, s[i:]+")")
+				return tokens
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\r\n(", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// parseReceivedDate reads the date of a Received field, which some relays
+// write without the day of the week or with a trailing zone comment.
+func parseReceivedDate(s string) time.Time {
+	if t, err := mail.ParseDate(s); err == nil {
+		return t
+	}
+	if i := strings.Index(s, "("); i > 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	for _, layout := range []string{
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		"2 Jan 2006 15:04:05 -0700",
+		"Mon, 2 Jan 2006 15:04:05 MST",
+		"2 Jan 2006 15:04:05 MST",
+		"Mon Jan 2 15:04:05 2006",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,126 @@
+package mboxheader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseReceived(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  Received
+	}{
+		{
+			name: "Postfix",
+			value: "from mail.example.org (mail.example.org [192.0.2.10])" +
+				" (using TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits))" +
+				" (No client certificate requested)" +
+				" by mx.example.com (Postfix) with ESMTPS id 4ABCD123" +
+				" for <user@example.com>; Mon, 1 Apr 2024 10:00:05 +0900 (JST)",
+			want: Received{
+				From: "mail.example.org", FromRDNS: "mail.example.org", FromIP: "192.0.2.10",
+				By: "mx.example.com", With: "ESMTPS", ID: "4ABCD123", For: "user@example.com",
+				TLS: true, TLSVersion: "TLSv1.3", Cipher: "TLS_AES_256_GCM_SHA384",
+				DateText: "Mon, 1 Apr 2024 10:00:05 +0900 (JST)",
+			},
+		},
+		{
+			name: "Postfix with IPv6 and unknown reverse name",
+			value: "from client.example.net (unknown [IPv6:2001:db8::1])" +
+				" by mx.example.com (Postfix) with ESMTP id 4XYZ; Mon, 1 Apr 2024 10:00:05 +0000",
+			want: Received{
+				From: "client.example.net", FromIP: "2001:db8::1",
+				By: "mx.example.com", With: "ESMTP", ID: "4XYZ",
+				DateText: "Mon, 1 Apr 2024 10:00:05 +0000",
+			},
+		},
+		{
+			name: "Exim",
+			value: "from [203.0.113.5] (helo=client.example.net)" +
+				" by mx.example.com with esmtpsa (TLS1.2) tls TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" +
+				" (Exim 4.96) (envelope-from <a@example.net>) id 1rABCD-000123-XY" +
+				" for b@example.com; Tue, 02 Apr 2024 01:02:03 +0000",
+			want: Received{
+				From: "client.example.net", FromIP: "203.0.113.5",
+				By: "mx.example.com", With: "esmtpsa", ID: "1rABCD-000123-XY", For: "b@example.com",
+				TLS: true, TLSVersion: "TLS1.2", Cipher: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+				DateText: "Tue, 02 Apr 2024 01:02:03 +0000",
+			},
+		},
+		{
+			name: "Exchange",
+			value: "from EX01.corp.example.com (10.0.0.1) by EX02.corp.example.com (10.0.0.2)" +
+				" with Microsoft SMTP Server (version=TLS1_2, cipher=TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384)" +
+				" id 15.1.2507.35; Wed, 3 Apr 2024 09:00:00 +0000",
+			want: Received{
+				From: "EX01.corp.example.com", FromIP: "10.0.0.1",
+				By: "EX02.corp.example.com", ByIP: "10.0.0.2",
+				With: "Microsoft SMTP Server", ID: "15.1.2507.35",
+				TLS: true, TLSVersion: "TLS1_2", Cipher: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+				DateText: "Wed, 3 Apr 2024 09:00:00 +0000",
+			},
+		},
+		{
+			name:  "qmail",
+			value: "from unknown (HELO client) (198.51.100.7) by mail.example.com with SMTP; 4 Apr 2024 12:00:01 -0000",
+			want: Received{
+				From: "unknown", FromIP: "198.51.100.7", By: "mail.example.com", With: "SMTP",
+				DateText: "4 Apr 2024 12:00:01 -0000",
+			},
+		},
+		{
+			name:  "qmail local",
+			value: "(qmail 12345 invoked from network); 4 Apr 2024 12:00:00 -0000",
+			want:  Received{DateText: "4 Apr 2024 12:00:00 -0000"},
+		},
+		{
+			name:  "no date",
+			value: "by localhost (Postfix, from userid 1000) id 0123ABC",
+			want:  Received{By: "localhost", ID: "0123ABC"},
+		},
+		{
+			name:  "unclosed comment",
+			value: "from a.example (a.example [192.0.2.1]; Thu, 4 Apr 2024 12:00:00 +0000",
+			want: Received{
+				From: "a.example", FromRDNS: "a.example", FromIP: "192.0.2.1",
+				DateText: "Thu, 4 Apr 2024 12:00:00 +0000",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseReceived(tt.value)
+			got.Time = time.Time{}
+			if got != tt.want {
+				t.Errorf("got\n %+v, want\n %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReceivedDate(t *testing.T) {
+	tests := []struct {
+		text string
+		want string // RFC 3339; empty for none
+	}{
+		{"Mon, 1 Apr 2024 10:00:05 +0900", "2024-04-01T10:00:05+09:00"},
+		{"Mon, 1 Apr 2024 10:00:05 +0900 (JST)", "2024-04-01T10:00:05+09:00"},
+		{"Mon, 01 Apr 2024 10:00:05 +0900 (GMT+09:00)", "2024-04-01T10:00:05+09:00"},
+		{"1 Apr 2024 10:00:05 -0000", "2024-04-01T10:00:05Z"},
+		{"Mon, 1 Apr 2024 10:00:05 GMT", "2024-04-01T10:00:05Z"},
+		{"Mon Apr 1 10:00:05 2024", "2024-04-01T10:00:05Z"},
+		{"yesterday", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := ParseReceived("by mx.example.com; " + tt.text).Time
+		s := ""
+		if !got.IsZero() {
+			s = got.Format(time.RFC3339)
+		}
+		if s != tt.want {
+			t.Errorf("date %q = %q, want %q", tt.text, s, tt.want)
+		}
+	}
+}
@@ -73,15 +73,13 @@ func ValidateHeaders(headers string, msgIndex int) []ValidationResult {
 		}
 	}
 
-	// Check for Status: D
-	if status, exists := parsedHeaders.GetFieldValue("status"); exists {
-		if status == "D" {
-			results = append(results, ValidationResult{
-				MsgIndex: msgIndex,
-				Field:    "Status",
-				Status:   StatusDeleted,
-			})
-		}
+	// Check for the deleted flag (Status: D)
+	if HeaderFlags(headers).Has(FlagDeleted) {
+		results = append(results, ValidationResult{
+			MsgIndex: msgIndex,
+			Field:    "Status",
+			Status:   StatusDeleted,
+		})
 	}
 
 	return results
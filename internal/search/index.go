@@ -0,0 +1,496 @@
+// Package search implements the full-text index behind the search API.
+//
+// An Index holds one inverted index per mailbox. Documents are added and
+// removed by their stable message UID, so the index can be kept up to date
+// incrementally as a mailbox changes, and it is persisted with encoding/gob.
+// Postings keep the positions of their term, so phrases match only where
+// their words follow each other.
+package search
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// indexVersion is bumped whenever the persisted layout, or the text the
+// server indexes, changes.
+const indexVersion = 8
+
+// Field identifies the part of a message a term was found in.
+type Field uint8
+
+const (
+	FieldSubject Field = 1 << iota
+	FieldFrom
+	FieldTo
+	FieldBody
+
+	AnyField = FieldSubject | FieldFrom | FieldTo | FieldBody
+)
+
+// fieldBoost weights matches by where they occur.
+var fieldBoost = map[Field]float64{
+	FieldSubject: 3,
+	FieldFrom:    2,
+	FieldTo:      1.5,
+	FieldBody:    1,
+}
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Document is the decoded text of one message to be indexed.
+type Document struct {
+	UID           string
+	Subject       string
+	From          string
+	To            string
+	Body          string
+	Date          time.Time
+	HasAttachment bool
+}
+
+// Hit is a document matching a query.
+type Hit struct {
+	UID   string
+	Score float64
+	Date  time.Time
+}
+
+type docInfo struct {
+	UID           string
+	Date          time.Time
+	HasAttachment bool
+	Length        int
+	Deleted       bool
+}
+
+type posting struct {
+	Doc       uint32
+	Field     Field
+	TF        uint16
+	Positions []uint32 // ascending
+}
+
+// Index is the inverted index of one mailbox.
+// Size and ModTime record the state of the mbox file it reflects; the caller
+// compares them to decide whether the index needs updating.
+type Index struct {
+	Version  int
+	Size     int64
+	ModTime  int64
+	Docs     []docInfo
+	Postings map[string][]posting
+
+	byUID   map[string]uint32
+	deleted int
+}
+
+// New returns an empty index.
+func New() *Index {
+	return &Index{
+		Version:  indexVersion,
+		Postings: map[string][]posting{},
+		byUID:    map[string]uint32{},
+	}
+}
+
+// Load reads an index saved with Save. A missing, unreadable or outdated file
+// yields an empty index.
+func Load(path string) *Index {
+	f, err := os.Open(path)
+	if err != nil {
+		return New()
+	}
+	defer f.Close()
+
+	var ix Index
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&ix); err != nil || ix.Version != indexVersion {
+		return New()
+	}
+	if ix.Postings == nil {
+		ix.Postings = map[string][]posting{}
+	}
+	ix.byUID = map[string]uint32{}
+	for i, d := range ix.Docs {
+		if d.Deleted {
+			ix.deleted++
+			continue
+		}
+		ix.byUID[d.UID] = uint32(i)
+	}
+	return &ix
+}
+
+// Save writes the index to path atomically.
+func (ix *Index) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Error creating index directory: %v", err)
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "search-*.tmp")
+	if err != nil {
+		return fmt.Errorf("Error creating temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	bw := bufio.NewWriter(tempFile)
+	if err := gob.NewEncoder(bw).Encode(ix); err != nil {
+		return fmt.Errorf("Error encoding index: %v", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("Error writing index: %v", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("Error closing temp file: %v", err)
+	}
+	return os.Rename(tempFile.Name(), path)
+}
+
+// Has reports whether a document with uid is indexed.
+func (ix *Index) Has(uid string) bool {
+	_, ok := ix.byUID[uid]
+	return ok
+}
+
+// UIDs returns the UIDs of all indexed documents.
+func (ix *Index) UIDs() []string {
+	uids := make([]string, 0, len(ix.byUID))
+	for uid := range ix.byUID {
+		uids = append(uids, uid)
+	}
+	return uids
+}
+
+// Add indexes doc, replacing any document with the same UID.
+func (ix *Index) Add(doc Document) {
+	ix.Remove(doc.UID)
+
+	id := uint32(len(ix.Docs))
+	length := 0
+	for _, f := range []struct {
+		field Field
+		text  string
+	}{
+		{FieldSubject, doc.Subject},
+		{FieldFrom, doc.From},
+		{FieldTo, doc.To},
+		{FieldBody, doc.Body},
+	} {
+		tokens := tokenize(f.text, true)
+		if len(tokens) == 0 {
+			continue
+		}
+		length += tokens[len(tokens)-1].pos + 1
+		positions := map[string][]uint32{}
+		for _, t := range tokens {
+			positions[t.text] = append(positions[t.text], uint32(t.pos))
+		}
+		for term, list := range positions {
+			tf := min(len(list), math.MaxUint16)
+			ix.Postings[term] = append(ix.Postings[term], posting{Doc: id, Field: f.field, TF: uint16(tf), Positions: list})
+		}
+	}
+
+	ix.Docs = append(ix.Docs, docInfo{
+		UID:           doc.UID,
+		Date:          doc.Date,
+		HasAttachment: doc.HasAttachment,
+		Length:        length,
+	})
+	ix.byUID[doc.UID] = id
+}
+
+// Remove drops the document with uid. Its postings are reclaimed lazily once
+// removed documents outnumber live ones.
+func (ix *Index) Remove(uid string) {
+	id, ok := ix.byUID[uid]
+	if !ok {
+		return
+	}
+	ix.Docs[id].Deleted = true
+	delete(ix.byUID, uid)
+	ix.deleted++
+
+	if ix.deleted > 1024 && ix.deleted > len(ix.byUID) {
+		ix.compact()
+	}
+}
+
+// compact rewrites the postings without removed documents.
+func (ix *Index) compact() {
+	remap := make([]uint32, len(ix.Docs))
+	var docs []docInfo
+	for i, d := range ix.Docs {
+		if d.Deleted {
+			continue
+		}
+		remap[i] = uint32(len(docs))
+		docs = append(docs, d)
+	}
+	for term, list := range ix.Postings {
+		kept := list[:0]
+		for _, p := range list {
+			if ix.Docs[p.Doc].Deleted {
+				continue
+			}
+			p.Doc = remap[p.Doc]
+			kept = append(kept, p)
+		}
+		if len(kept) == 0 {
+			delete(ix.Postings, term)
+		} else {
+			ix.Postings[term] = kept
+		}
+	}
+	ix.Docs = docs
+	ix.deleted = 0
+	ix.byUID = make(map[string]uint32, len(docs))
+	for i, d := range docs {
+		ix.byUID[d.UID] = uint32(i)
+	}
+}
+
+// Stats are the collection statistics BM25 scores are computed from. Scores
+// of different indexes only compare when computed from the same Stats, so a
+// search over several indexes merges theirs and passes the sum to
+// SearchWith.
+type Stats struct {
+	Docs        int // live documents
+	TotalLength int
+	docFreq     map[termKey]int
+}
+
+// termKey is a token searched in a set of fields.
+type termKey struct {
+	token  string
+	fields Field
+}
+
+// Stats returns the statistics of ix for the tokens of q.
+func (ix *Index) Stats(q Query) Stats {
+	st := Stats{Docs: len(ix.byUID), docFreq: map[termKey]int{}}
+	for _, d := range ix.Docs {
+		if !d.Deleted {
+			st.TotalLength += d.Length
+		}
+	}
+	for _, term := range q.Terms {
+		if term.Negate {
+			continue
+		}
+		fields := term.fields()
+		for _, t := range tokenize(term.Text, false) {
+			key := termKey{t.text, fields}
+			if _, ok := st.docFreq[key]; !ok {
+				st.docFreq[key] = ix.docFreq(t.text, fields)
+			}
+		}
+	}
+	return st
+}
+
+// Merge adds the statistics of another index to st.
+func (st *Stats) Merge(other Stats) {
+	st.Docs += other.Docs
+	st.TotalLength += other.TotalLength
+	if st.docFreq == nil {
+		st.docFreq = map[termKey]int{}
+	}
+	for key, n := range other.docFreq {
+		st.docFreq[key] += n
+	}
+}
+
+// Search returns the documents matching q, best matches first.
+func (ix *Index) Search(q Query) []Hit {
+	return ix.SearchWith(q, ix.Stats(q))
+}
+
+// SearchWith is Search with the scores computed from stats, which must
+// include those of ix.
+func (ix *Index) SearchWith(q Query, stats Stats) []Hit {
+	live := len(ix.byUID)
+	if live == 0 {
+		return nil
+	}
+
+	var scores map[uint32]float64
+	var excluded map[uint32]bool
+	for _, term := range q.Terms {
+		tokens := tokenize(term.Text, false)
+		if len(tokens) == 0 {
+			continue
+		}
+		fields := term.fields()
+		// Every word of the term must occur, and in a phrase in order
+		var matches map[uint32]float64
+		for _, t := range tokens {
+			m := ix.match(t.text, fields, stats)
+			if matches == nil {
+				matches = m
+				continue
+			}
+			for doc := range matches {
+				if s, ok := m[doc]; ok {
+					matches[doc] += s
+				} else {
+					delete(matches, doc)
+				}
+			}
+		}
+		if len(tokens) > 1 && len(matches) > 0 {
+			phrase := ix.phraseDocs(tokens, fields)
+			for doc := range matches {
+				if !phrase[doc] {
+					delete(matches, doc)
+				}
+			}
+		}
+
+		if term.Negate {
+			if excluded == nil {
+				excluded = map[uint32]bool{}
+			}
+			for doc := range matches {
+				excluded[doc] = true
+			}
+			continue
+		}
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		for doc := range scores {
+			if s, ok := matches[doc]; ok {
+				scores[doc] += s
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+
+	if scores == nil {
+		// Only filters or negations: every document is a candidate
+		scores = make(map[uint32]float64, live)
+		for _, id := range ix.byUID {
+			scores[id] = 0
+		}
+	}
+
+	var hits []Hit
+	for id, score := range scores {
+		d := ix.Docs[id]
+		if d.Deleted || excluded[id] {
+			continue
+		}
+		if q.HasAttachment && !d.HasAttachment {
+			continue
+		}
+		if !q.Before.IsZero() && !d.Date.Before(q.Before) {
+			continue
+		}
+		if !q.After.IsZero() && d.Date.Before(q.After) {
+			continue
+		}
+		hits = append(hits, Hit{UID: d.UID, Score: score, Date: d.Date})
+	}
+
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].Score != hits[b].Score {
+			return hits[a].Score > hits[b].Score
+		}
+		return hits[a].Date.After(hits[b].Date)
+	})
+	return hits
+}
+
+// postings returns the live postings of token in one of fields.
+func (ix *Index) postings(token string, fields Field) []posting {
+	var list []posting
+	for _, p := range ix.Postings[token] {
+		if p.Field&fields == 0 || ix.Docs[p.Doc].Deleted {
+			continue
+		}
+		list = append(list, p)
+	}
+	return list
+}
+
+// docFreq returns the number of live documents containing token in one of
+// fields.
+func (ix *Index) docFreq(token string, fields Field) int {
+	docs := map[uint32]bool{}
+	for _, p := range ix.postings(token, fields) {
+		docs[p.Doc] = true
+	}
+	return len(docs)
+}
+
+// match scores every live document containing token in one of fields.
+func (ix *Index) match(token string, fields Field, stats Stats) map[uint32]float64 {
+	weighted := map[uint32]float64{}
+	for _, p := range ix.postings(token, fields) {
+		weighted[p.Doc] += fieldBoost[p.Field] * float64(p.TF)
+	}
+
+	docs := max(stats.Docs, len(ix.byUID))
+	avgLength := float64(stats.TotalLength) / float64(docs)
+	if avgLength == 0 {
+		avgLength = 1
+	}
+	df := float64(max(stats.docFreq[termKey{token, fields}], len(weighted)))
+	idf := math.Log(1 + (float64(docs)-df+0.5)/(df+0.5))
+	for doc, tf := range weighted {
+		norm := 1 - bm25B + bm25B*float64(ix.Docs[doc].Length)/avgLength
+		weighted[doc] = idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+	}
+	return weighted
+}
+
+// phraseDocs returns the documents in which tokens occur at their relative
+// positions within a single field, one of fields.
+func (ix *Index) phraseDocs(tokens []token, fields Field) map[uint32]bool {
+	type place struct {
+		doc   uint32
+		field Field
+	}
+	// Candidate positions of the first token, narrowed token by token
+	starts := map[place][]uint32{}
+	for _, p := range ix.postings(tokens[0].text, fields) {
+		starts[place{p.Doc, p.Field}] = p.Positions
+	}
+	for _, t := range tokens[1:] {
+		offset := uint32(t.pos - tokens[0].pos)
+		next := map[place][]uint32{}
+		for _, p := range ix.postings(t.text, fields) {
+			at := place{p.Doc, p.Field}
+			var kept []uint32
+			for _, start := range starts[at] {
+				if _, found := slices.BinarySearch(p.Positions, start+offset); found {
+					kept = append(kept, start)
+				}
+			}
+			if len(kept) > 0 {
+				next[at] = kept
+			}
+		}
+		starts = next
+	}
+
+	docs := make(map[uint32]bool, len(starts))
+	for at := range starts {
+		docs[at.doc] = true
+	}
+	return docs
+}
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testIndex(docs ...Document) *Index {
+	ix := New()
+	for i, doc := range docs {
+		doc.Date = time.Date(2024, 1, i+1, 0, 0, 0, 0, time.UTC)
+		ix.Add(doc)
+	}
+	return ix
+}
+
+func hitUIDs(hits []Hit) []string {
+	var uids []string
+	for _, h := range hits {
+		uids = append(uids, h.UID)
+	}
+	slices.Sort(uids)
+	return uids
+}
+
+func TestSearch(t *testing.T) {
+	ix := testIndex(
+		Document{UID: "a", Subject: "quarterly report", Body: "the foo bar meeting"},
+		Document{UID: "b", Subject: "lunch", Body: "bar first, then foo", HasAttachment: true},
+		Document{UID: "c", From: "Alice <alice@example.com>", Body: "東京都の会議について"},
+		Document{UID: "d", Subject: "京都 trip", Body: "foo"},
+	)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"foo", []string{"a", "b", "d"}},
+		{"foo bar", []string{"a", "b"}},
+		{`"foo bar"`, []string{"a"}},
+		{`"bar foo"`, nil},
+		{`foo -"foo bar"`, []string{"b", "d"}},
+		{"foo -bar", []string{"d"}},
+		{"京", []string{"c", "d"}},
+		{"都", []string{"c", "d"}},
+		{"東京都", []string{"c"}},
+		{"京都", []string{"c", "d"}},
+		{"都会", nil},
+		{"subject:京都", []string{"d"}},
+		{"from:alice", []string{"c"}},
+		{"subject:foo", nil},
+		{"foo has:attachment", []string{"b"}},
+		{"after:2024-01-03", []string{"c", "d"}},
+		{"-foo", []string{"c"}},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q): %v", tt.query, err)
+		}
+		if got := hitUIDs(ix.Search(q)); !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchPhraseInOneField(t *testing.T) {
+	// The words follow each other only across the subject and body
+	ix := testIndex(Document{UID: "a", Subject: "hello foo", Body: "bar"})
+	q, _ := ParseQuery(`"foo bar"`)
+	if hits := ix.Search(q); len(hits) != 0 {
+		t.Errorf("phrase matched across fields: %v", hits)
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	ix := testIndex(
+		Document{UID: "body", Body: "invoice attached"},
+		Document{UID: "subject", Subject: "invoice"},
+		Document{UID: "none", Body: "nothing here"},
+	)
+	q, _ := ParseQuery("invoice")
+	hits := ix.Search(q)
+	if len(hits) != 2 || hits[0].UID != "subject" {
+		t.Errorf("got %v, want the subject match first", hits)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ix := testIndex(Document{UID: "a", Body: "foo"}, Document{UID: "b", Body: "foo"})
+	ix.Remove("a")
+	ix.Add(Document{UID: "b", Body: "bar"})
+	q, _ := ParseQuery("foo")
+	if hits := ix.Search(q); len(hits) != 0 {
+		t.Errorf("removed or replaced documents found: %v", hits)
+	}
+	if ix.Has("a") || !ix.Has("b") {
+		t.Errorf("Has: a=%v b=%v", ix.Has("a"), ix.Has("b"))
+	}
+}
+
+// Scores computed from merged statistics are those of a single index holding
+// all the documents.
+func TestSearchWithMergedStats(t *testing.T) {
+	first := []Document{
+		{UID: "a", Body: "rare word"},
+		{UID: "b", Body: "common"},
+	}
+	second := []Document{
+		{UID: "c", Body: "rare rare rare"},
+		{UID: "d", Body: "common"},
+		{UID: "e", Body: "common common"},
+		{UID: "f", Body: "common"},
+	}
+	one, two := testIndex(first...), testIndex(second...)
+	all := testIndex(append(first, second...)...)
+
+	q, _ := ParseQuery("rare")
+	stats := one.Stats(q)
+	stats.Merge(two.Stats(q))
+	scores := map[string]float64{}
+	for _, ix := range []*Index{one, two} {
+		for _, h := range ix.SearchWith(q, stats) {
+			scores[h.UID] = h.Score
+		}
+	}
+	want := all.Search(q)
+	if len(scores) != len(want) {
+		t.Fatalf("got %d hits, want %d", len(scores), len(want))
+	}
+	for _, h := range want {
+		if math.Abs(scores[h.UID]-h.Score) > 1e-9 {
+			t.Errorf("%s: merged score %v, single index %v", h.UID, scores[h.UID], h.Score)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	ix := testIndex(Document{UID: "a", Body: "東京 foo bar"}, Document{UID: "b", Body: "foo"})
+	ix.Remove("b")
+	ix.Size, ix.ModTime = 10, 20
+	path := filepath.Join(t.TempDir(), "search", "box.gob")
+	if err := ix.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := Load(path)
+	if loaded.Size != 10 || loaded.ModTime != 20 || !loaded.Has("a") || loaded.Has("b") {
+		t.Fatalf("loaded index differs: %+v", loaded)
+	}
+	for _, query := range []string{`"foo bar"`, "京"} {
+		q, _ := ParseQuery(query)
+		if got := hitUIDs(loaded.Search(q)); !slices.Equal(got, []string{"a"}) {
+			t.Errorf("Search(%q) after Load = %q", query, got)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Term is one word or quoted phrase of a query.
+type Term struct {
+	Text   string
+	Field  Field // 0 matches any field
+	Negate bool  // the document must not contain Text
+}
+
+// Query is a parsed search query. All terms must match.
+type Query struct {
+	Terms         []Term
+	Before        time.Time // only documents dated before this
+	After         time.Time // only documents dated on or after this
+	HasAttachment bool
+}
+
+// fields returns the fields t is searched in.
+func (t Term) fields() Field {
+	if t.Field == 0 {
+		return AnyField
+	}
+	return t.Field
+}
+
+var fieldQualifiers = map[string]Field{
+	"from":    FieldFrom,
+	"to":      FieldTo,
+	"subject": FieldSubject,
+	"body":    FieldBody,
+}
+
+var dateLayouts = []string{"2006-01-02", "2006/01/02", "2006-01", "2006"}
+
+// ParseQuery parses a query string. Words and "quoted phrases" are matched
+// anywhere; the qualifiers from:, to:, subject: and body: restrict a term to
+// one field, before: and after: take a date (YYYY-MM-DD), has:attachment keeps
+// messages with attachments, and a leading "-" excludes a term.
+// Unknown qualifiers are searched as plain text.
+func ParseQuery(s string) (Query, error) {
+	var q Query
+	for _, tok := range splitQuery(s) {
+		negate := false
+		if strings.HasPrefix(tok, "-") && len(tok) > 1 {
+			negate = true
+			tok = tok[1:]
+		}
+
+		name, value, found := strings.Cut(tok, ":")
+		if !found || value == "" {
+			q.Terms = append(q.Terms, Term{Text: unquote(tok), Negate: negate})
+			continue
+		}
+		name = strings.ToLower(name)
+		value = unquote(value)
+
+		if field, ok := fieldQualifiers[name]; ok {
+			q.Terms = append(q.Terms, Term{Text: value, Field: field, Negate: negate})
+			continue
+		}
+		switch name {
+		case "before", "after":
+			t, err := parseQueryDate(value)
+			if err != nil {
+				return Query{}, fmt.Errorf("invalid %s: date %q", name, value)
+			}
+			if name == "before" {
+				q.Before = t
+			} else {
+				q.After = t
+			}
+		case "has":
+			if strings.ToLower(value) != "attachment" {
+				return Query{}, fmt.Errorf("unsupported has:%s", value)
+			}
+			q.HasAttachment = true
+		default:
+			q.Terms = append(q.Terms, Term{Text: unquote(tok), Negate: negate})
+		}
+	}
+	return q, nil
+}
+
+// Words returns the text of the positive terms, for highlighting snippets.
+func (q Query) Words() []string {
+	var words []string
+	for _, t := range q.Terms {
+		if !t.Negate && t.Text != "" {
+			words = append(words, t.Text)
+		}
+	}
+	return words
+}
+
+// IsEmpty reports whether the query has neither terms nor filters.
+func (q Query) IsEmpty() bool {
+	return len(q.Terms) == 0 && q.Before.IsZero() && q.After.IsZero() && !q.HasAttachment
+}
+
+// splitQuery splits on whitespace outside double quotes, keeping the quotes.
+func splitQuery(s string) []string {
+	var tokens []string
+	var cur strings.Builder
+	inQuote := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			cur.WriteRune(r)
+		case !inQuote && (r == ' ' || r == '\t' || r == '\n' || r == '　'):
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens
+}
+
+func unquote(s string) string {
+	return strings.Trim(s, `"`)
+}
+
+func parseQueryDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
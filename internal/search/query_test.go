@@ -0,0 +1,64 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	}
+	tests := []struct {
+		query string
+		want  Query
+	}{
+		{
+			query: "foo bar",
+			want:  Query{Terms: []Term{{Text: "foo"}, {Text: "bar"}}},
+		},
+		{
+			query: `"foo bar" -baz`,
+			want:  Query{Terms: []Term{{Text: "foo bar"}, {Text: "baz", Negate: true}}},
+		},
+		{
+			query: `from:alice subject:"weekly report" -to:bob`,
+			want: Query{Terms: []Term{
+				{Text: "alice", Field: FieldFrom},
+				{Text: "weekly report", Field: FieldSubject},
+				{Text: "bob", Field: FieldTo, Negate: true},
+			}},
+		},
+		{
+			query: "after:2024/01/01 before:2024-02 has:attachment",
+			want:  Query{After: day(2024, 1, 1), Before: day(2024, 2, 1), HasAttachment: true},
+		},
+		{
+			query: "会議　資料 url:http://example.com",
+			want:  Query{Terms: []Term{{Text: "会議"}, {Text: "資料"}, {Text: "url:http://example.com"}}},
+		},
+		{
+			query: "- from:",
+			want:  Query{Terms: []Term{{Text: "-"}, {Text: "from:"}}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseQuery(%q) =\n %+v, want\n %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{"before:yesterday", "after:2024-13-01", "has:star"} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q) succeeded, want an error", query)
+		}
+	}
+}
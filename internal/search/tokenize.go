@@ -0,0 +1,82 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Tokenize splits text into search terms.
+//
+// Text is NFKC-normalized (folding full-width alphanumerics) and lowercased.
+// Runs of letters and digits become one term each. Scripts written without
+// spaces (Han, Hiragana, Katakana, Hangul) are split into overlapping
+// character bigrams instead, so that any substring of two or more characters
+// can be found; a single character stays a term of its own.
+func Tokenize(text string) []string {
+	var terms []string
+	for _, t := range tokenize(text, false) {
+		terms = append(terms, t.text)
+	}
+	return terms
+}
+
+// token is a term and its position in the text. A word takes one position
+// and a run of CJK characters one per character, the bigram starting at a
+// character sharing its position.
+type token struct {
+	text string
+	pos  int
+}
+
+// tokenize returns the terms of text as Tokenize does, with their positions.
+// With unigrams set, every character of a CJK run is returned as well, so
+// that the index can answer single-character queries.
+func tokenize(text string, unigrams bool) []token {
+	var tokens []token
+	var word []rune
+	var cjk []rune
+	pos := 0
+
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, token{string(word), pos})
+			word = word[:0]
+			pos++
+		}
+	}
+	flushCJK := func() {
+		for i := range cjk {
+			if unigrams || len(cjk) == 1 {
+				tokens = append(tokens, token{string(cjk[i]), pos + i})
+			}
+			if i+1 < len(cjk) {
+				tokens = append(tokens, token{string(cjk[i : i+2]), pos + i})
+			}
+		}
+		pos += len(cjk)
+		cjk = cjk[:0]
+	}
+
+	for _, r := range strings.ToLower(norm.NFKC.String(text)) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) || r == 'ー'
+}
@@ -0,0 +1,45 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, World!", []string{"hello", "world"}},
+		{"ＡＢＣ１２３ def", []string{"abc123", "def"}},
+		{"e-mail v2.0", []string{"e", "mail", "v2", "0"}},
+		{"東京都", []string{"東京", "京都"}},
+		{"京", []string{"京"}},
+		{"メールを送信", []string{"メー", "ール", "ルを", "を送", "送信"}},
+		{"会議room A", []string{"会議", "room", "a"}},
+		{"  ...  ", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizePositions(t *testing.T) {
+	tests := []struct {
+		text     string
+		unigrams bool
+		want     []token
+	}{
+		{"foo bar, baz", false, []token{{"foo", 0}, {"bar", 1}, {"baz", 2}}},
+		{"東京 tower", false, []token{{"東京", 0}, {"tower", 2}}},
+		{"東京都", true, []token{{"東", 0}, {"東京", 0}, {"京", 1}, {"京都", 1}, {"都", 2}}},
+		{"a京b", true, []token{{"a", 0}, {"京", 1}, {"b", 2}}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text, tt.unigrams); !slices.Equal(got, tt.want) {
+			t.Errorf("tokenize(%q, %v) = %v, want %v", tt.text, tt.unigrams, got, tt.want)
+		}
+	}
+}
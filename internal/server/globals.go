@@ -1,5 +1,13 @@
 package server
 
+import (
+	"github.com/emurenMRz/mboxview/internal/signature"
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
+)
+
 // package-level shared state
 var basePath string
 var editMode bool
+var lockOptions mboxfile.LockOptions
+var trashMailbox string
+var verifier *signature.Verifier
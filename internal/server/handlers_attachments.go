@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// preferredExtensions overrides mime.ExtensionsByType, which sorts the
+// extensions alphabetically, for common types of unnamed parts.
+var preferredExtensions = map[string]string{
+	"text/plain":     ".txt",
+	"text/html":      ".html",
+	"image/jpeg":     ".jpg",
+	"message/rfc822": ".eml",
+	"text/calendar":  ".ics",
+}
+
+// attachmentHandler serves GET /api/mailboxes/{name}/emails/{id}/attachments/{partId},
+// streaming the transfer-decoded content of one part.
+func attachmentHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string, partID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	root, _, ok := loadMessageParts(w, r, mailboxName, emailIdStr)
+	if !ok {
+		return
+	}
+	part := root.find(partID)
+	if part == nil || partID == "" || len(part.Children) > 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := part.decoded()
+
+	contentType := part.MediaType
+	if charset := part.Params["charset"]; charset != "" && strings.HasPrefix(contentType, "text/") {
+		contentType = mime.FormatMediaType(contentType, map[string]string{"charset": charset})
+	}
+	filename := part.filename()
+	if filename == "" {
+		filename = "part-" + partID
+		if ext, ok := preferredExtensions[part.MediaType]; ok {
+			filename += ext
+		} else if exts, _ := mime.ExtensionsByType(part.MediaType); len(exts) > 0 {
+			filename += exts[0]
+		}
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	// Attachments are untrusted: never let the browser run them on our origin
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Write(data)
+}
+
+// embeddedMessageHandler serves GET /api/mailboxes/{name}/emails/{id}/messages/{partId},
+// the content of an enclosed message as if it were an email of its own. It
+// takes the parameters of the email content route.
+func embeddedMessageHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string, partID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	opts, err := parseDisplayOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	root, entry, ok := loadMessageParts(w, r, mailboxName, emailIdStr)
+	if !ok {
+		return
+	}
+	part := root.find(partID)
+	if part == nil || part.Message == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	message := embeddedMessage(part)
+	opts.baseURL = attachmentURL(mailboxName, entry.UID)
+	prepareContent(&message.EmailContent, message.root, opts)
+	if r.URL.Query().Get("structure") == "1" {
+		message.Structure = part.Message.structure()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// loadMessageParts resolves an email and parses its MIME structure, writing
+// an error response and returning false on failure.
+func loadMessageParts(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) (*mimePart, indexEntry, bool) {
+	mboxPath, entry, ok := resolveEmail(w, r, mailboxName, emailIdStr)
+	if !ok {
+		return nil, indexEntry{}, false
+	}
+
+	msg, closer, err := openMessage(mboxPath, entry)
+	if err != nil {
+		log.Printf("Error reading message %s in %s: %v", emailIdStr, mailboxName, err)
+		http.Error(w, "Error reading mbox", http.StatusInternalServerError)
+		return nil, indexEntry{}, false
+	}
+	defer closer.Close()
+
+	root, err := parseMIMEMessage(msg)
+	if err != nil {
+		log.Printf("Error reading message %s in %s: %v", emailIdStr, mailboxName, err)
+		http.Error(w, "Error reading mbox", http.StatusInternalServerError)
+		return nil, indexEntry{}, false
+	}
+	return root, entry, true
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
+)
+
+// bouncesHandler serves GET /api/mailboxes/{name}/bounces: the delivery
+// status notifications in a mailbox and, per recipient, how often delivery
+// failed or was delayed. Only messages indexed as multipart/report are read.
+func bouncesHandler(w http.ResponseWriter, r *http.Request, mailboxName string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mboxPath, err := mailboxPath(mailboxName)
+	if err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	if _, err := os.Stat(mboxPath); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Hold a shared lock so the file cannot be rewritten under the offsets
+	// of the index while the reports are read
+	opts := lockOptions
+	opts.Shared = true
+	lock, err := mboxfile.LockFile(mboxPath, opts)
+	if err != nil {
+		log.Printf("Error locking %s: %v", mboxPath, err)
+		http.Error(w, "Error reading mbox", http.StatusInternalServerError)
+		return
+	}
+	defer lock.Unlock()
+
+	idx, ok := loadIndexOrError(w, r, mboxPath)
+	if !ok {
+		return
+	}
+	f, err := os.Open(mboxPath)
+	if err != nil {
+		log.Printf("Error opening %s: %v", mboxPath, err)
+		http.Error(w, "Error reading mbox", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	resp := BouncesResponse{Reports: []BounceReport{}, Recipients: []BounceRecipient{}}
+	byAddress := map[string]*BounceRecipient{}
+	latest := map[string]time.Time{}
+	for i, entry := range idx.Entries {
+		if !entry.Report || !entry.listed() {
+			continue
+		}
+		msg, err := readMessageAt(f, entry)
+		if err != nil {
+			log.Printf("Error reading message %s in %s: %v", entry.UID, mailboxName, err)
+			continue
+		}
+		root, err := parseMIMEMessage(msg)
+		if err != nil {
+			log.Printf("Error reading message %s in %s: %v", entry.UID, mailboxName, err)
+			continue
+		}
+		report, ok := deliveryReport(root)
+		if !ok || report.Type != "delivery-status" {
+			continue
+		}
+		resp.Reports = append(resp.Reports, BounceReport{Email: entry.email(i), Report: report})
+
+		for _, rcpt := range report.Recipients {
+			if rcpt.Action != "failed" && rcpt.Action != "delayed" {
+				continue
+			}
+			address := strings.ToLower(cmp.Or(rcpt.OriginalRecipient, rcpt.FinalRecipient))
+			b := byAddress[address]
+			if b == nil {
+				b = &BounceRecipient{Address: address}
+				byAddress[address] = b
+			}
+			if rcpt.Action == "failed" {
+				b.Failed++
+			} else {
+				b.Delayed++
+			}
+			if t, seen := latest[address]; !seen || !entry.Timestamp.Before(t) {
+				latest[address] = entry.Timestamp
+				b.LastAction = rcpt.Action
+				b.LastStatus = rcpt.Status
+				b.LastDiagnostic = rcpt.DiagnosticCode
+				b.LastUID = entry.UID
+				b.LastDate = ""
+				if !entry.Timestamp.IsZero() {
+					b.LastDate = entry.Timestamp.Format(time.RFC3339)
+				}
+			}
+		}
+	}
+
+	for _, b := range byAddress {
+		resp.Recipients = append(resp.Recipients, *b)
+	}
+	slices.SortFunc(resp.Recipients, func(a, b BounceRecipient) int {
+		return cmp.Or(b.Failed-a.Failed, b.Delayed-a.Delayed, strings.Compare(a.Address, b.Address))
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
+)
+
+type RenameMailboxRequest struct {
+	Name string `json:"name"` // new full name
+}
+
+// mailboxInfoHandler serves GET /api/mailboxes/{name}, the tree node of one
+// mailbox or folder.
+func mailboxInfoHandler(w http.ResponseWriter, r *http.Request, mailboxName string) {
+	path, err := mailboxPath(mailboxName)
+	if err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := mailboxName
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	node := &MailboxNode{Name: name, Path: mailboxName}
+	if fi.IsDir() {
+		node.Children, err = mailboxTree(path, mailboxName+"/", true)
+		if err != nil {
+			http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		node.Selectable = true
+		fillCounts(node, path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node)
+}
+
+// createMailboxHandler serves POST /api/mailboxes/{name}. A name ending in
+// the delimiter creates a folder instead of an empty mailbox. Missing parent
+// folders are created.
+func createMailboxHandler(w http.ResponseWriter, r *http.Request, mailboxName string) {
+	folder := strings.HasSuffix(mailboxName, "/")
+	path, err := mailboxPath(strings.TrimSuffix(mailboxName, "/"))
+	if err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	if _, err := os.Lstat(path); err == nil {
+		http.Error(w, "Mailbox already exists", http.StatusConflict)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("Error creating folder for %s: %v", path, err)
+		http.Error(w, "Cannot create mailbox here", http.StatusConflict)
+		return
+	}
+
+	if folder {
+		err = os.Mkdir(path, 0755)
+	} else {
+		var f *os.File
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+		if err == nil {
+			err = f.Close()
+		}
+	}
+	if os.IsExist(err) {
+		http.Error(w, "Mailbox already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating %s: %v", path, err)
+		http.Error(w, "Error creating mailbox", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// renameMailboxHandler serves POST /api/mailboxes/{name}/rename. Renaming a
+// folder moves every mailbox inside it; the persisted indexes move along.
+func renameMailboxHandler(w http.ResponseWriter, r *http.Request, mailboxName string) {
+	var req RenameMailboxRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	oldPath, err := mailboxPath(mailboxName)
+	if err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	newPath, err := mailboxPath(req.Name)
+	if err != nil {
+		http.Error(w, "Invalid new mailbox name", http.StatusBadRequest)
+		return
+	}
+	fi, err := os.Lstat(oldPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := os.Lstat(newPath); err == nil {
+		http.Error(w, "Mailbox already exists", http.StatusConflict)
+		return
+	}
+	if strings.HasPrefix(newPath, oldPath+string(filepath.Separator)) {
+		http.Error(w, "Cannot move a folder into itself", http.StatusBadRequest)
+		return
+	}
+
+	// Keep deliveries and rewrites out while the file changes its name
+	if !fi.IsDir() {
+		lock, err := mboxfile.LockFile(oldPath, lockOptions)
+		if err != nil {
+			log.Printf("%v", err)
+			http.Error(w, "Error locking mbox", http.StatusInternalServerError)
+			return
+		}
+		defer lock.Unlock()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		log.Printf("Error creating folder for %s: %v", newPath, err)
+		http.Error(w, "Cannot move mailbox here", http.StatusConflict)
+		return
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		log.Printf("Error renaming %s: %v", oldPath, err)
+		http.Error(w, "Error renaming mailbox", http.StatusInternalServerError)
+		return
+	}
+	moveState(oldPath, newPath, fi.IsDir())
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteMailboxHandler serves DELETE /api/mailboxes/{name}. Folders must be
+// empty.
+func deleteMailboxHandler(w http.ResponseWriter, r *http.Request, mailboxName string) {
+	path, err := mailboxPath(mailboxName)
+	if err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if fi.IsDir() {
+		if entries, err := os.ReadDir(path); err == nil && len(entries) > 0 {
+			http.Error(w, "Folder is not empty", http.StatusConflict)
+			return
+		}
+		err = os.Remove(path)
+	} else {
+		var lock *mboxfile.Lock
+		lock, err = mboxfile.LockFile(path, lockOptions)
+		if err == nil {
+			err = os.Remove(path)
+			lock.Unlock()
+		}
+	}
+	if err != nil {
+		log.Printf("Error deleting %s: %v", path, err)
+		http.Error(w, "Error deleting mailbox", http.StatusInternalServerError)
+		return
+	}
+	removeState(path, fi.IsDir())
+	w.WriteHeader(http.StatusOK)
+}
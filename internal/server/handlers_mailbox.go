@@ -1,110 +1,151 @@
 package server
 
 import (
+	"cmp"
 	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
 	"log"
-	"mime"
 	"net/http"
-	"net/mail"
-	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 
-	"github.com/emersion/go-imap/utf7"
-	"github.com/emersion/go-mbox"
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
 )
 
-func updateStatusHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string, status string) {
-	// mailboxName coming from API is UTF-8; encode to IMAP-UTF7 to find file on disk
-	encodedMailboxName, err := utf7.Encoding.NewEncoder().String(mailboxName)
+// setEmailFlags adds and removes flags of one message and returns its
+// resulting flags. It answers the request itself on failure.
+func setEmailFlags(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string, add, remove []string) ([]string, bool) {
+	mboxPath, err := mailboxPath(mailboxName)
 	if err != nil {
 		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
-		return
+		return nil, false
 	}
-	mboxPath := filepath.Join(basePath, encodedMailboxName)
 
-	// String of mail id to integer
-	emailId, err := strconv.Atoi(emailIdStr)
-	if err != nil {
+	// Resolve the stable or ordinal ID to the message position
+	idx, ok := loadIndexOrError(w, r, mboxPath)
+	if !ok {
+		return nil, false
+	}
+	emailId, ok := idx.resolve(emailIdStr)
+	if !ok {
 		http.Error(w, "Invalid email ID", http.StatusBadRequest)
-		return
+		return nil, false
+	}
+	// Not a message whose headers could be rewritten
+	if idx.Entries[emailId].Malformed {
+		http.NotFound(w, r)
+		return nil, false
 	}
 
-	// Read the mbox file and parse messages
-	messages, ok := ReadMessages(mboxPath, w, r)
+	// Rewrite the mbox file with the flag fields of the target message updated
+	flags := idx.Entries[emailId].Flags
+	expect := map[int]indexEntry{emailId: idx.Entries[emailId]}
+	_, err = rewriteMailbox(mboxPath, expect, func(i int, headers string) (string, bool) {
+		if i != emailId {
+			return headers, false
+		}
+		newHeaders, updated := mboxheader.UpdateFlags(headers, add, remove)
+		flags = mboxheader.HeaderFlags(newHeaders).List()
+		return newHeaders, updated
+	})
+	if !updateError(w, err) {
+		return nil, false
+	}
+	return flags, true
+}
+
+func markEmailReadHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) {
+	if _, ok := setEmailFlags(w, r, mailboxName, emailIdStr, []string{mboxheader.FlagSeen, mboxheader.FlagOld}, nil); ok {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// deleteEmailHandler flags a message deleted, or moves it to the trash
+// mailbox when one is configured.
+func deleteEmailHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) {
+	mboxPath, entry, ok := resolveEmail(w, r, mailboxName, emailIdStr)
 	if !ok {
 		return
 	}
-
-	if emailId < 0 || emailId >= len(messages) {
-		http.Error(w, "Invalid email ID", http.StatusBadRequest)
+	trash, err := trashPath(mboxPath)
+	if err != nil {
+		log.Printf("Error opening trash mailbox: %v", err)
+		http.Error(w, "Error opening trash mailbox", http.StatusInternalServerError)
 		return
 	}
-
-	// Update the target message
-	targetMsgStr := messages[emailId]
-	// Split envelope line from the rest
-	envelopeLine, rest := SplitAtFirstNewline(targetMsgStr)
-	// Find header part (before body)
-	headers, body := SplitHeadersFromBody(rest)
-	// Update status header
-	newHeaders, updated := updateStatusHeader(headers, status)
-	if !updated {
-		w.WriteHeader(http.StatusOK)
+	if trash == "" {
+		if _, ok := setEmailFlags(w, r, mailboxName, emailIdStr, []string{mboxheader.FlagDeleted}, nil); ok {
+			w.WriteHeader(http.StatusOK)
+		}
 		return
 	}
 
-	// Reconstruct the message
-	messages[emailId] = envelopeLine + "\n" + newHeaders + "\n" + body
-
-	err = updateMBox(mboxPath, messages)
-	if err != nil {
-		log.Printf("%v", err)
-		http.Error(w, "Error updating mbox", http.StatusInternalServerError)
+	n, err := transferMessages(mboxPath, trash, []indexEntry{entry}, true, true)
+	if !updateError(w, err) {
+		return
+	}
+	if n == 0 {
+		http.Error(w, "Mailbox changed; reload and try again", http.StatusConflict)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func markEmailReadHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) {
-	updateStatusHandler(w, r, mailboxName, emailIdStr, "RO")
+type EmailFlagsRequest struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
 }
 
-func deleteEmailHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) {
-	updateStatusHandler(w, r, mailboxName, emailIdStr, "D")
+type EmailFlagsResponse struct {
+	Flags []string `json:"flags"`
 }
 
-func updateMBox(mboxPath string, messages []string) error {
-	tempFile, err := os.CreateTemp(basePath, "mboxview-update-*.mbox")
-	if err != nil {
-		return fmt.Errorf("Error creating temp file: %v", err)
+// emailFlagsHandler serves POST /api/mailboxes/{name}/emails/{id}/flags.
+// Besides the flag names, "starred" is accepted for flagged and "unread" for
+// the absence of seen.
+func emailFlagsHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) {
+	var req EmailFlagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	for _, msgStr := range messages {
-		_, err := tempFile.WriteString(msgStr)
-		if err != nil {
-			return fmt.Errorf("Error writing message to temp file: %v", err)
+	var add, remove []string
+	for _, change := range []struct {
+		names         []string
+		to, inverseTo *[]string
+	}{
+		{req.Add, &add, &remove},
+		{req.Remove, &remove, &add},
+	} {
+		for _, name := range change.names {
+			switch strings.ToLower(name) {
+			case "unread":
+				*change.inverseTo = append(*change.inverseTo, mboxheader.FlagSeen)
+				continue
+			case "starred":
+				name = mboxheader.FlagFlagged
+			}
+			flag, ok := mboxheader.NormalizeFlag(name)
+			if !ok {
+				http.Error(w, "Invalid flag: "+name, http.StatusBadRequest)
+				return
+			}
+			*change.to = append(*change.to, flag)
 		}
 	}
 
-	if err := tempFile.Close(); err != nil {
-		return fmt.Errorf("Error closing temp file: %v", err)
-	}
-
-	if err := os.Rename(filepath.Clean(tempFile.Name()), mboxPath); err != nil {
-		return fmt.Errorf("Error replacing original file: %v", err)
+	flags, ok := setEmailFlags(w, r, mailboxName, emailIdStr, add, remove)
+	if !ok {
+		return
 	}
-
-	return nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(EmailFlagsResponse{Flags: flags})
 }
 
 type BatchDeleteRequest struct {
-	IDs []int `json:"ids"`
+	IDs []EmailRef `json:"ids"`
 }
 
 type BatchDeleteResponse struct {
@@ -124,76 +165,103 @@ func deleteBatchEmailsHandler(w http.ResponseWriter, r *http.Request, mailboxNam
 		return
 	}
 
-	encodedMailboxName, err := utf7.Encoding.NewEncoder().String(mailboxName)
+	mboxPath, err := mailboxPath(mailboxName)
 	if err != nil {
 		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
 		return
 	}
-	mboxPath := filepath.Join(basePath, encodedMailboxName)
 
-	messages, ok := ReadMessages(mboxPath, w, r)
+	idx, ok := loadIndexOrError(w, r, mboxPath)
 	if !ok {
 		return
 	}
 
-	validIDs := make(map[int]bool)
-	for _, id := range req.IDs {
-		if id >= 0 && id < len(messages) {
-			validIDs[id] = true
+	trash, err := trashPath(mboxPath)
+	if err != nil {
+		log.Printf("Error opening trash mailbox: %v", err)
+		http.Error(w, "Error opening trash mailbox", http.StatusInternalServerError)
+		return
+	}
+	if trash != "" {
+		entries, invalid := resolveEntries(idx, req.IDs)
+		n := 0
+		if len(entries) > 0 {
+			n, err = transferMessages(mboxPath, trash, entries, true, true)
+			if !updateError(w, err) {
+				return
+			}
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(BatchDeleteResponse{Deleted: n, Failed: invalid + len(entries) - n})
+		return
 	}
 
-	for id := range validIDs {
-		targetMsgStr := messages[id]
-		envelopeLine, rest := SplitAtFirstNewline(targetMsgStr)
-		headers, body := SplitHeadersFromBody(rest)
-		newHeaders, updated := updateStatusHeader(headers, "D")
-		messages[id] = envelopeLine + "\n" + newHeaders + "\n" + body
-		validIDs[id] = updated
+	updated, failed, err := updateFlagsBatch(mboxPath, idx, req.IDs, []string{mboxheader.FlagDeleted}, nil)
+	if !updateError(w, err) {
+		return
 	}
 
-	err = updateMBox(mboxPath, messages)
-	if err != nil {
-		log.Printf("%v", err)
-		http.Error(w, "Error updating mbox", http.StatusInternalServerError)
-		return
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchDeleteResponse{Deleted: updated, Failed: failed})
+}
+
+// updateFlagsBatch adds and removes flags of the messages named by refs in a
+// single rewrite. It returns how many messages changed, and how many IDs
+// named no message or a message whose flags were already as requested.
+// errMailboxChanged is returned if the mailbox no longer matches idx.
+func updateFlagsBatch(mboxPath string, idx *mailboxIndex, refs []EmailRef, add, remove []string) (int, int, error) {
+	validIDs := make(map[int]bool)
+	expect := make(map[int]indexEntry)
+	invalid := 0
+	for _, ref := range refs {
+		if id, ok := idx.resolve(string(ref)); ok {
+			// Set to true once the rewrite actually updates the message
+			validIDs[id] = false
+			expect[id] = idx.Entries[id]
+		} else {
+			invalid++
+		}
 	}
 
-	response := BatchDeleteResponse{
-		Deleted: 0,
-		Failed:  0,
+	_, err := rewriteMailbox(mboxPath, expect, func(i int, headers string) (string, bool) {
+		if _, ok := validIDs[i]; !ok {
+			return headers, false
+		}
+		newHeaders, updated := mboxheader.UpdateFlags(headers, add, remove)
+		validIDs[i] = updated
+		return newHeaders, updated
+	})
+	if err != nil {
+		return 0, 0, err
 	}
 
+	updated, failed := 0, invalid
 	for _, state := range validIDs {
 		if state {
-			response.Deleted++
+			updated++
 		} else {
-			response.Failed++
+			failed++
 		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	return updated, failed, nil
 }
 
-func mailboxesHandler(w http.ResponseWriter, _ *http.Request) {
-	files, err := os.ReadDir(basePath)
-	if err != nil {
-		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+func mailboxesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("tree") == "1" {
+		nodes, err := mailboxTree(basePath, "", true)
+		if err != nil {
+			http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(nodes)
 		return
 	}
 
-	var mailboxes []string
-	for _, file := range files {
-		if !file.IsDir() {
-			// Files on disk are IMAP-UTF7 encoded; decode to UTF-8 for API response
-			decodedName, err := utf7.Encoding.NewDecoder().String(file.Name())
-			if err != nil {
-				log.Printf("Failed to decode mailbox filename %s: %v", file.Name(), err)
-				continue
-			}
-			mailboxes = append(mailboxes, decodedName)
-		}
+	mailboxes, err := listMailboxNames()
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -201,160 +269,197 @@ func mailboxesHandler(w http.ResponseWriter, _ *http.Request) {
 }
 
 func listEmailsHandler(w http.ResponseWriter, r *http.Request, mailboxName string) {
-	// mailboxName coming from API is UTF-8; encode to IMAP-UTF7 to find file on disk
-	encodedMailboxName, err := utf7.Encoding.NewEncoder().String(mailboxName)
+	opts, err := parseListOptions(r)
 	if err != nil {
-		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	mboxPath := filepath.Join(basePath, encodedMailboxName)
-	f, err := os.Open(mboxPath)
+
+	mboxPath, err := mailboxPath(mailboxName)
 	if err != nil {
-		http.NotFound(w, r)
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	idx, ok := loadIndexOrError(w, r, mboxPath)
+	if !ok {
 		return
 	}
-	defer f.Close()
 
-	var emails []Email
-	reader := mbox.NewReader(f)
-	i := 0
-	for {
-		// reader.NextMessage returns an io.Reader positioned at the start of a message
-		mrReader, err := reader.NextMessage()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Printf("Error reading message in %s: %v", mailboxName, err)
-			i++
+	emails := []Email{}
+	for i, entry := range idx.Entries {
+		if opts.deleted {
+			if entry.Malformed || !entry.hasFlag(mboxheader.FlagDeleted) {
+				continue
+			}
+		} else if !entry.listed() {
 			continue
 		}
+		emails = append(emails, entry.email(i))
+	}
 
-		// Parse the message headers using mail.ReadMessage
-		mr, err := mail.ReadMessage(mrReader)
-		if err != nil {
-			log.Printf("Failed to parse message headers in %s: %v", mailboxName, err)
-			i++
-			continue
-		}
+	sortEmails(emails, opts.sort, opts.desc)
 
-		header := mr.Header
-		status := header.Get("Status")
-		if status == "D" {
-			i++
-			continue
+	// A cursor is the UID of the last email of the previous page
+	offset := opts.offset
+	if opts.cursor != "" {
+		offset = -1
+		for i, email := range emails {
+			if email.UID == opts.cursor {
+				offset = i + 1
+				break
+			}
 		}
-		if status == "" {
-			// ヘッダが無い場合は新着扱い
-			status = "N"
+		if offset < 0 {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
 		}
+	}
 
-		subject := header.Get("Subject")
+	response := EmailList{
+		Total:  len(emails),
+		Offset: offset,
+		Limit:  opts.limit,
+	}
+	page := emails[min(offset, len(emails)):]
+	if opts.limit > 0 && len(page) > opts.limit {
+		page = page[:opts.limit]
+		response.NextCursor = page[len(page)-1].UID
+	}
+	response.Emails = page
 
-		decoder := new(mime.WordDecoder)
-		// Use a WordDecoder with a CharsetReader so encoded-words with non-UTF8
-		// charsets (e.g. ISO-2022-JP) are converted to UTF-8.
-		decoder = &mime.WordDecoder{CharsetReader: charsetReader}
-		decodedSubject, err := decoder.DecodeHeader(subject)
-		if err != nil {
-			decodedSubject = subject
-		}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// listOptions are the paging and sorting parameters of the email list.
+type listOptions struct {
+	limit   int // 0 means no limit
+	offset  int
+	cursor  string
+	sort    string
+	desc    bool
+	deleted bool // list the messages flagged deleted instead
+}
 
-		decodedFrom := decodeAddressList(header.Get("From"), decoder)
+func parseListOptions(r *http.Request) (listOptions, error) {
+	q := r.URL.Query()
+	opts := listOptions{sort: "date"}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, errors.New("Invalid limit")
+		}
+		opts.limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, errors.New("Invalid offset")
+		}
+		opts.offset = n
+	}
+	opts.cursor = q.Get("cursor")
+
+	switch q.Get("deleted") {
+	case "", "0":
+	case "1":
+		opts.deleted = true
+	default:
+		return opts, errors.New("Invalid deleted")
+	}
 
-		// parse Date header into time for sorting
-		dateStr := header.Get("Date")
-		ts := parseDate(dateStr)
+	if v := q.Get("sort"); v != "" {
+		switch v {
+		case "date", "from", "subject", "size":
+			opts.sort = v
+		default:
+			return opts, errors.New("Invalid sort")
+		}
+	}
 
-		emails = append(emails, Email{
-			ID:        i,
-			From:      decodedFrom,
-			Date:      dateStr,
-			Subject:   decodedSubject,
-			Status:    status,
-			Timestamp: ts,
-		})
-		i++
+	// Dates and sizes default to largest first, text fields to A-Z
+	opts.desc = opts.sort == "date" || opts.sort == "size"
+	switch q.Get("order") {
+	case "":
+	case "asc":
+		opts.desc = false
+	case "desc":
+		opts.desc = true
+	default:
+		return opts, errors.New("Invalid order")
 	}
+	return opts, nil
+}
 
-	// sort by Timestamp descending (newest first). Zero timestamps go last.
+// sortEmails orders emails by key. Ties keep file order, and emails without
+// a parseable date always go last.
+func sortEmails(emails []Email, key string, desc bool) {
 	sort.SliceStable(emails, func(a, b int) bool {
-		ta := emails[a].Timestamp
-		tb := emails[b].Timestamp
-		if ta.Equal(tb) {
-			return emails[a].ID < emails[b].ID
+		ea, eb := emails[a], emails[b]
+		var c int
+		switch key {
+		case "date":
+			ta, tb := ea.Timestamp, eb.Timestamp
+			if ta.IsZero() != tb.IsZero() {
+				return tb.IsZero()
+			}
+			c = ta.Compare(tb)
+		case "from":
+			c = strings.Compare(strings.ToLower(ea.From), strings.ToLower(eb.From))
+		case "subject":
+			c = strings.Compare(strings.ToLower(ea.Subject), strings.ToLower(eb.Subject))
+		case "size":
+			c = cmp.Compare(ea.Size, eb.Size)
 		}
-		if ta.IsZero() {
-			return false
+		if c == 0 {
+			return ea.ID < eb.ID
 		}
-		if tb.IsZero() {
-			return true
+		if desc {
+			return c > 0
 		}
-		return ta.After(tb)
+		return c < 0
 	})
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(emails)
 }
 
+// emailContentHandler serves the bodies and attachments of an email. Remote
+// images and CSS in the HTML body are blocked unless the request has
+// remote=allow; with textHTML=1 the plain text body is rendered as HTML too,
+// and with structure=1 the whole MIME tree is included.
 func emailContentHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) {
-	emailId, err := strconv.Atoi(emailIdStr)
+	opts, err := parseDisplayOptions(r)
 	if err != nil {
-		http.Error(w, "Invalid email ID", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// mailboxName coming from API is UTF-8; encode to IMAP-UTF7 to find file on disk
-	encodedMailboxName, err := utf7.Encoding.NewEncoder().String(mailboxName)
-	if err != nil {
-		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
-		return
-	}
-	mboxPath := filepath.Join(basePath, encodedMailboxName)
-	f, err := os.Open(mboxPath)
-	if err != nil {
-		http.NotFound(w, r)
+	root, entry, ok := loadMessageParts(w, r, mailboxName, emailIdStr)
+	if !ok {
 		return
 	}
-	defer f.Close()
-
-	reader := mbox.NewReader(f)
-	i := 0
-	var selectedMsg *mail.Message
-	for {
-		mrReader, err := reader.NextMessage()
-		if err == io.EOF {
-			http.NotFound(w, r)
-			return
-		}
-		if err != nil {
-			log.Printf("Error reading message in %s: %v", mailboxName, err)
-			http.Error(w, "Error reading mbox", http.StatusInternalServerError)
-			return
-		}
-
-		mr, err := mail.ReadMessage(mrReader)
-		if err != nil {
-			log.Printf("Failed to parse message in %s: %v", mailboxName, err)
-			// skip this message but continue
-			i++
-			continue
-		}
-
-		if i == emailId {
-			selectedMsg = mr
-			break
-		}
-		i++
-	}
-
-	if selectedMsg == nil {
-		http.NotFound(w, r)
-		return
+	content := partsContent(root)
+	opts.baseURL = attachmentURL(mailboxName, entry.UID)
+	prepareContent(&content, root, opts)
+	if r.URL.Query().Get("structure") == "1" {
+		content.Structure = root.structure()
 	}
 
-	content := parseMessageBody(selectedMsg)
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(content)
 }
+
+// parseDisplayOptions reads the remote and textHTML parameters of the
+// content routes.
+func parseDisplayOptions(r *http.Request) (displayOptions, error) {
+	q := r.URL.Query()
+	var opts displayOptions
+	switch q.Get("remote") {
+	case "", "block":
+	case "allow":
+		opts.allowRemote = true
+	default:
+		return opts, errors.New("Invalid remote")
+	}
+	opts.textHTML = q.Get("textHTML") == "1"
+	return opts, nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/emurenMRz/mboxview/internal/search"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// searchHandler serves GET /api/search?q=... across all mailboxes.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mailboxes, err := listMailboxNames()
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+	runSearch(w, r, mailboxes)
+}
+
+// mailboxSearchHandler serves GET /api/mailboxes/{name}/search?q=...
+func mailboxSearchHandler(w http.ResponseWriter, r *http.Request, mailboxName string) {
+	if _, err := mailboxPath(mailboxName); err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	runSearch(w, r, []string{mailboxName})
+}
+
+func runSearch(w http.ResponseWriter, r *http.Request, mailboxes []string) {
+	queryStr := r.URL.Query().Get("q")
+	query, err := search.ParseQuery(queryStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.IsEmpty() {
+		http.Error(w, "Empty query", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	// Scores of separate indexes compare only when computed from the same
+	// statistics, so those of every mailbox are gathered first
+	var stats search.Stats
+	err = eachSearchIndex(mailboxes, func(mailboxName, mboxPath string, ix *search.Index, idx *mailboxIndex) {
+		stats.Merge(ix.Stats(query))
+	})
+	if err != nil {
+		log.Printf("Error searching %v", err)
+		http.Error(w, "Error searching mailbox", http.StatusInternalServerError)
+		return
+	}
+
+	type located struct {
+		hit   SearchHit
+		path  string
+		entry indexEntry
+	}
+	var found []located
+	err = eachSearchIndex(mailboxes, func(mailboxName, mboxPath string, ix *search.Index, idx *mailboxIndex) {
+		for _, hit := range ix.SearchWith(query, stats) {
+			i, ok := idx.resolve(hit.UID)
+			if !ok || !idx.Entries[i].listed() {
+				continue
+			}
+			found = append(found, located{
+				hit:   SearchHit{Mailbox: mailboxName, Email: idx.Entries[i].email(i), Score: hit.Score},
+				path:  mboxPath,
+				entry: idx.Entries[i],
+			})
+		}
+	})
+	if err != nil {
+		log.Printf("Error searching %v", err)
+		http.Error(w, "Error searching mailbox", http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(found, func(a, b int) bool {
+		ha, hb := found[a].hit, found[b].hit
+		if ha.Score != hb.Score {
+			return ha.Score > hb.Score
+		}
+		return ha.Timestamp.After(hb.Timestamp)
+	})
+
+	response := SearchResponse{
+		Query: queryStr,
+		Total: len(found),
+		Hits:  []SearchHit{},
+	}
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	words := query.Words()
+	for _, l := range found {
+		hit := l.hit
+		if msg, closer, err := openMessage(l.path, l.entry); err == nil {
+			hit.Snippet = makeSnippet(contentText(parseMessageBody(msg)), words)
+			closer.Close()
+		}
+		response.Hits = append(response.Hits, hit)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// eachSearchIndex calls fn with the up to date search index of each mailbox,
+// skipping those that do not exist. An error names the mailbox it came from.
+func eachSearchIndex(mailboxes []string, fn func(mailboxName, mboxPath string, ix *search.Index, idx *mailboxIndex)) error {
+	for _, mailboxName := range mailboxes {
+		mboxPath, err := mailboxPath(mailboxName)
+		if err != nil {
+			continue
+		}
+		err = withSearchIndex(mboxPath, func(ix *search.Index, idx *mailboxIndex) {
+			fn(mailboxName, mboxPath, ix, idx)
+		})
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", mailboxName, err)
+		}
+	}
+	return nil
+}
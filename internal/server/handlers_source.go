@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
+)
+
+// rawEmailHandler serves GET /api/mailboxes/{name}/emails/{id}/raw, the
+// message as it was delivered: the stored bytes, line endings included,
+// without the mbox envelope line and separator and with ">From " quoting
+// undone.
+func rawEmailHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mboxPath, entry, ok := resolveEmail(w, r, mailboxName, emailIdStr)
+	if !ok {
+		return
+	}
+	m, closer, err := openRawMessage(mboxPath, entry)
+	if err != nil {
+		log.Printf("Error reading message %s in %s: %v", emailIdStr, mailboxName, err)
+		http.Error(w, "Error reading mbox", http.StatusInternalServerError)
+		return
+	}
+	defer closer.Close()
+
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": entry.UID + ".eml"}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, m.Content()); err != nil {
+		log.Printf("Error sending message %s in %s: %v", emailIdStr, mailboxName, err)
+	}
+}
+
+// headersHandler serves GET /api/mailboxes/{name}/emails/{id}/headers, every
+// header field in order, for debugging delivery problems.
+func headersHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mboxPath, entry, ok := resolveEmail(w, r, mailboxName, emailIdStr)
+	if !ok {
+		return
+	}
+	m, closer, err := openRawMessage(mboxPath, entry)
+	if err != nil {
+		log.Printf("Error reading message %s in %s: %v", emailIdStr, mailboxName, err)
+		http.Error(w, "Error reading mbox", http.StatusInternalServerError)
+		return
+	}
+	defer closer.Close()
+
+	response := MessageHeaders{
+		Envelope: m.Envelope,
+		Headers:  []HeaderField{},
+	}
+	for _, field := range mboxheader.NewParsedMailHeaders(string(m.Header)).Fields() {
+		value := field.Value()
+		response.Headers = append(response.Headers, HeaderField{
+			Name:    field.Name(),
+			Value:   value,
+			Decoded: decodeHeader(value),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// traceHandler serves GET /api/mailboxes/{name}/emails/{id}/trace, the
+// delivery path read from the Received fields.
+func traceHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mboxPath, entry, ok := resolveEmail(w, r, mailboxName, emailIdStr)
+	if !ok {
+		return
+	}
+	m, closer, err := openRawMessage(mboxPath, entry)
+	if err != nil {
+		log.Printf("Error reading message %s in %s: %v", emailIdStr, mailboxName, err)
+		http.Error(w, "Error reading mbox", http.StatusInternalServerError)
+		return
+	}
+	defer closer.Close()
+
+	trace := buildTrace(mboxheader.NewParsedMailHeaders(string(m.Header)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trace)
+}
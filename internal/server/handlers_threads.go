@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/emurenMRz/mboxview/internal/threading"
+)
+
+// threadsHandler serves GET /api/mailboxes/{name}/threads.
+func threadsHandler(w http.ResponseWriter, r *http.Request, mailboxName string) {
+	mboxPath, err := mailboxPath(mailboxName)
+	if err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	idx, ok := loadIndexOrError(w, r, mboxPath)
+	if !ok {
+		return
+	}
+
+	var msgs []threading.Message
+	for i, entry := range idx.Entries {
+		if !entry.listed() {
+			continue
+		}
+		var messageID string
+		if ids := parseMessageIDs(entry.MessageID); len(ids) > 0 {
+			messageID = ids[0]
+		}
+		msgs = append(msgs, threading.Message{
+			Key:        i,
+			MessageID:  messageID,
+			References: entry.References,
+			Subject:    entry.Subject,
+			Date:       entry.Timestamp,
+		})
+	}
+
+	threads := []Thread{}
+	for _, node := range threading.Thread(msgs) {
+		root := threadNode(node, idx)
+		thread := Thread{
+			Subject:  threadSubject(root),
+			Messages: node.Count(),
+			Root:     root,
+		}
+		if latest := node.Latest(); !latest.IsZero() {
+			thread.Latest = latest.Format(time.RFC3339)
+		}
+		threads = append(threads, thread)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(threads)
+}
+
+func threadNode(node *threading.Node, idx *mailboxIndex) *ThreadNode {
+	n := &ThreadNode{}
+	if node.Message != nil {
+		email := idx.Entries[node.Message.Key].email(node.Message.Key)
+		n.Email = &email
+	}
+	for _, child := range node.Children {
+		n.Children = append(n.Children, threadNode(child, idx))
+	}
+	return n
+}
+
+// threadSubject returns the subject of the first message in the thread.
+func threadSubject(n *ThreadNode) string {
+	if n.Email != nil {
+		return n.Email.Subject
+	}
+	for _, child := range n.Children {
+		if s := threadSubject(child); s != "" {
+			return s
+		}
+	}
+	return ""
+}
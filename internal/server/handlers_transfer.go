@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+)
+
+type TransferRequest struct {
+	Target  string `json:"target"`  // mailbox name
+	Expunge bool   `json:"expunge"` // move only: remove from the source instead of flagging it deleted
+}
+
+type BatchTransferRequest struct {
+	IDs     []EmailRef `json:"ids"`
+	Target  string     `json:"target"`
+	Expunge bool       `json:"expunge"`
+}
+
+type BatchMoveResponse struct {
+	Moved  int `json:"moved"`
+	Failed int `json:"failed"`
+}
+
+type BatchCopyResponse struct {
+	Copied int `json:"copied"`
+	Failed int `json:"failed"`
+}
+
+// transferEmailHandler serves POST /api/mailboxes/{name}/emails/{id}/move and
+// .../copy.
+func transferEmailHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string, move bool) {
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mboxPath, entry, ok := resolveEmail(w, r, mailboxName, emailIdStr)
+	if !ok {
+		return
+	}
+	targetPath, ok := transferTarget(w, req.Target)
+	if !ok {
+		return
+	}
+
+	n, err := transferMessages(mboxPath, targetPath, []indexEntry{entry}, move, req.Expunge)
+	if !updateError(w, err) {
+		return
+	}
+	if n == 0 {
+		http.Error(w, "Mailbox changed; reload and try again", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// transferBatchHandler serves POST /api/mailboxes/{name}/emails/move-batch and
+// .../copy-batch.
+func transferBatchHandler(w http.ResponseWriter, r *http.Request, mailboxName string, move bool) {
+	var req BatchTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.IDs) == 0 {
+		http.Error(w, "No IDs provided", http.StatusBadRequest)
+		return
+	}
+
+	mboxPath, err := mailboxPath(mailboxName)
+	if err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	idx, ok := loadIndexOrError(w, r, mboxPath)
+	if !ok {
+		return
+	}
+	targetPath, ok := transferTarget(w, req.Target)
+	if !ok {
+		return
+	}
+
+	entries, invalid := resolveEntries(idx, req.IDs)
+	n := 0
+	if len(entries) > 0 {
+		n, err = transferMessages(mboxPath, targetPath, entries, move, req.Expunge)
+		if !updateError(w, err) {
+			return
+		}
+	}
+	failed := invalid + len(entries) - n
+
+	w.Header().Set("Content-Type", "application/json")
+	if move {
+		json.NewEncoder(w).Encode(BatchMoveResponse{Moved: n, Failed: failed})
+	} else {
+		json.NewEncoder(w).Encode(BatchCopyResponse{Copied: n, Failed: failed})
+	}
+}
+
+// transferTarget maps the target mailbox of a request to its file, which
+// must already exist.
+func transferTarget(w http.ResponseWriter, target string) (string, bool) {
+	targetPath, err := mailboxPath(target)
+	if err != nil {
+		http.Error(w, "Invalid target mailbox", http.StatusBadRequest)
+		return "", false
+	}
+	if fi, err := os.Stat(targetPath); err != nil || !fi.Mode().IsRegular() {
+		http.Error(w, "Target mailbox not found", http.StatusNotFound)
+		return "", false
+	}
+	return targetPath, true
+}
+
+// updateError answers the request for a failed transfer or flag update and
+// reports whether err was nil.
+func updateError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errSameMailbox):
+		http.Error(w, "Source and target are the same mailbox", http.StatusBadRequest)
+	case errors.Is(err, errMailboxChanged):
+		http.Error(w, "Mailbox changed; reload and try again", http.StatusConflict)
+	default:
+		log.Printf("%v", err)
+		http.Error(w, "Error updating mbox", http.StatusInternalServerError)
+	}
+	return false
+}
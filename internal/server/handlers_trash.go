@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
+)
+
+type BatchUndeleteResponse struct {
+	Undeleted int `json:"undeleted"`
+	Failed    int `json:"failed"`
+}
+
+type ExpungeResponse struct {
+	Expunged int `json:"expunged"`
+}
+
+// undeleteEmailHandler serves POST /api/mailboxes/{name}/emails/{id}/undelete.
+func undeleteEmailHandler(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) {
+	if _, ok := setEmailFlags(w, r, mailboxName, emailIdStr, nil, []string{mboxheader.FlagDeleted}); ok {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// undeleteBatchEmailsHandler serves POST
+// /api/mailboxes/{name}/emails/undelete-batch.
+func undeleteBatchEmailsHandler(w http.ResponseWriter, r *http.Request, mailboxName string) {
+	var req BatchDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.IDs) == 0 {
+		http.Error(w, "No IDs provided", http.StatusBadRequest)
+		return
+	}
+
+	mboxPath, err := mailboxPath(mailboxName)
+	if err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	idx, ok := loadIndexOrError(w, r, mboxPath)
+	if !ok {
+		return
+	}
+
+	updated, failed, err := updateFlagsBatch(mboxPath, idx, req.IDs, nil, []string{mboxheader.FlagDeleted})
+	if !updateError(w, err) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchUndeleteResponse{Undeleted: updated, Failed: failed})
+}
+
+// expungeHandler serves POST /api/mailboxes/{name}/expunge, removing the
+// messages flagged deleted from the mailbox for good.
+func expungeHandler(w http.ResponseWriter, r *http.Request, mailboxName string) {
+	mboxPath, err := mailboxPath(mailboxName)
+	if err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return
+	}
+	if fi, err := os.Stat(mboxPath); err != nil || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	n, err := expungeMailbox(mboxPath)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error updating mbox", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ExpungeResponse{Expunged: n})
+}
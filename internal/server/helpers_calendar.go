@@ -0,0 +1,62 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/emurenMRz/mboxview/internal/ical"
+)
+
+// calendarInfo reads the events of an iCalendar part.
+func calendarInfo(p *mimePart) (CalendarInfo, bool) {
+	cal, err := ical.Parse([]byte(p.text()))
+	if err != nil {
+		log.Printf("Error parsing calendar part %s: %v", p.ID, err)
+		return CalendarInfo{}, false
+	}
+	info := CalendarInfo{PartID: p.ID, Method: cal.Method, Events: []CalendarEvent{}}
+	for _, e := range cal.Events {
+		event := CalendarEvent{
+			UID:          e.UID,
+			Summary:      e.Summary,
+			Description:  e.Description,
+			Location:     e.Location,
+			Status:       e.Status,
+			Sequence:     e.Sequence,
+			Start:        calendarTime(e.Start),
+			End:          calendarTime(e.End),
+			AllDay:       e.Start != nil && e.Start.AllDay,
+			RecurrenceID: calendarTime(e.RecurrenceID),
+			Recurrence:   e.Recurrence,
+		}
+		if e.Organizer != nil {
+			organizer := calendarPerson(*e.Organizer)
+			event.Organizer = &organizer
+		}
+		for _, a := range e.Attendees {
+			event.Attendees = append(event.Attendees, calendarPerson(a))
+		}
+		info.Events = append(info.Events, event)
+	}
+	return info, true
+}
+
+func calendarPerson(p ical.Person) CalendarPerson {
+	return CalendarPerson{Name: p.Name, Email: p.Email, Role: p.Role, Status: p.Status, RSVP: p.RSVP}
+}
+
+func calendarTime(t *ical.Time) *CalendarTime {
+	if t == nil {
+		return nil
+	}
+	ct := &CalendarTime{Value: t.Value, TZID: t.TZID, Floating: t.Floating}
+	switch {
+	case t.AllDay:
+		ct.Time = t.Time.Format(time.DateOnly)
+	case t.Floating:
+		ct.Time = t.Time.Format("2006-01-02T15:04:05")
+	default:
+		ct.Time = t.Time.Format(time.RFC3339)
+	}
+	return ct
+}
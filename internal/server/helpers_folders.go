@@ -0,0 +1,162 @@
+package server
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/emersion/go-imap/utf7"
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
+)
+
+// stateKinds are the directories under indexDirName holding per-mailbox state.
+var stateKinds = []string{"index", "search"}
+
+// mailboxTree lists the mailboxes and folders in dir. prefix is the
+// hierarchical name of dir including the trailing delimiter. With counts set,
+// mailboxes are indexed to fill in their message counts.
+func mailboxTree(dir, prefix string, counts bool) ([]*MailboxNode, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []*MailboxNode{}
+	for _, file := range files {
+		if skipMailboxFile(dir, file.Name()) {
+			continue
+		}
+		// Files on disk are IMAP-UTF7 encoded; decode to UTF-8 for API response
+		decodedName, err := utf7.Encoding.NewDecoder().String(file.Name())
+		if err != nil {
+			log.Printf("Failed to decode mailbox filename %s: %v", file.Name(), err)
+			continue
+		}
+
+		node := &MailboxNode{Name: decodedName, Path: prefix + decodedName}
+		path := filepath.Join(dir, file.Name())
+		if file.IsDir() {
+			children, err := mailboxTree(path, node.Path+"/", counts)
+			if err != nil {
+				log.Printf("Failed to read folder %s: %v", path, err)
+				continue
+			}
+			node.Children = children
+		} else {
+			node.Selectable = true
+			if counts {
+				fillCounts(node, path)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// skipMailboxFile reports whether a directory entry is not a mailbox: hidden
+// files such as the state directory and the temp files mailboxes are
+// rewritten through, and the dotlock files of mailboxes.
+func skipMailboxFile(dir, name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	if base, ok := strings.CutSuffix(name, ".lock"); ok {
+		if _, err := os.Stat(filepath.Join(dir, base)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// fillCounts sets the message and unread counts of a mailbox node.
+func fillCounts(node *MailboxNode, mboxPath string) {
+	idx, err := loadIndex(mboxPath)
+	if err != nil {
+		log.Printf("Error indexing %s: %v", mboxPath, err)
+		return
+	}
+	for _, entry := range idx.Entries {
+		if !entry.listed() {
+			continue
+		}
+		node.Total++
+		if !entry.hasFlag(mboxheader.FlagSeen) {
+			node.Unread++
+		}
+	}
+}
+
+// statePath returns the file or, for a folder, the directory under
+// indexDirName/kind holding the state derived from path.
+func statePath(path string, kind string, isDir bool) (string, error) {
+	if isDir {
+		rel, err := filepath.Rel(basePath, path)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(basePath, indexDirName, kind, rel), nil
+	}
+	return stateFilePath(path, kind)
+}
+
+// moveState moves the persisted state of a renamed mailbox or folder along
+// with it. State that cannot be moved is removed and rebuilt on demand.
+func moveState(oldPath, newPath string, isDir bool) {
+	forgetState(oldPath)
+	for _, kind := range stateKinds {
+		from, err := statePath(oldPath, kind, isDir)
+		if err != nil {
+			continue
+		}
+		to, err := statePath(newPath, kind, isDir)
+		if err != nil {
+			os.RemoveAll(from)
+			continue
+		}
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		os.RemoveAll(to)
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err == nil {
+			err = os.Rename(from, to)
+		}
+		if err != nil {
+			log.Printf("Failed to move state %s: %v", from, err)
+			os.RemoveAll(from)
+		}
+	}
+}
+
+// removeState deletes the persisted state of a deleted mailbox or folder.
+func removeState(path string, isDir bool) {
+	forgetState(path)
+	for _, kind := range stateKinds {
+		if p, err := statePath(path, kind, isDir); err == nil {
+			os.RemoveAll(p)
+		}
+	}
+}
+
+// forgetState drops the cached indexes of path and anything below it.
+func forgetState(path string) {
+	under := func(p string) bool {
+		return p == path || strings.HasPrefix(p, path+string(filepath.Separator))
+	}
+
+	indexMu.Lock()
+	for p := range indexCache {
+		if under(p) {
+			delete(indexCache, p)
+		}
+	}
+	indexMu.Unlock()
+
+	searchMu.Lock()
+	for p := range searchCache {
+		if under(p) {
+			delete(searchCache, p)
+		}
+	}
+	searchMu.Unlock()
+}
@@ -1,76 +1,249 @@
 package server
 
 import (
-	"bufio"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/mail"
 	"os"
+	"path/filepath"
 	"strings"
+
+	"github.com/emersion/go-imap/utf7"
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
 )
 
-// ReadMessages reads all messages from an mbox file and returns them as a slice of strings.
-// It opens the file in read-only mode.
-func ReadMessages(mboxPath string, w http.ResponseWriter, r *http.Request) ([]string, bool) {
+// messageEdit is called for every message while a mailbox is rewritten.
+// It receives the message position and raw header block and returns the new
+// header block and whether it differs from the original.
+type messageEdit func(i int, headers string) (string, bool)
+
+// rewriteMailbox streams the mbox file through edit into a temp file and
+// replaces the original with it. It returns the number of messages edit
+// changed; when nothing changed the original file is left untouched.
+// The mailbox stays locked until the new file is in place.
+//
+// expect holds the index entries of the positions edit acts on. The index
+// was read before the lock was taken, so if another process changed the
+// mailbox in between and a position no longer holds the same message,
+// nothing is written and errMailboxChanged is returned.
+func rewriteMailbox(mboxPath string, expect map[int]indexEntry, edit messageEdit) (int, error) {
+	lock, err := mboxfile.LockFile(mboxPath, lockOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+
+	return rewriteLocked(mboxPath, expect, edit, nil)
+}
+
+// rewriteLocked is rewriteMailbox for a caller already holding the mailbox
+// lock. drop, when not nil, selects messages to leave out of the new file;
+// they count as changed. expect may be nil when the positions were found
+// under the same lock.
+func rewriteLocked(mboxPath string, expect map[int]indexEntry, edit messageEdit, drop func(i int, headers string) bool) (int, error) {
 	f, err := os.Open(mboxPath)
 	if err != nil {
-		http.NotFound(w, r)
-		return nil, false
+		return 0, err
 	}
 	defer f.Close()
 
-	var messages []string
-	scanner := bufio.NewScanner(f)
-	var currentMessage strings.Builder
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "From ") && currentMessage.Len() > 0 {
-			messages = append(messages, currentMessage.String())
-			currentMessage.Reset()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// Hidden, so that it is not listed as a mailbox while it exists
+	tempFile, err := os.CreateTemp(filepath.Dir(mboxPath), ".mboxview-update-*.mbox")
+	if err != nil {
+		return 0, fmt.Errorf("Error creating temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	changed := 0
+	found := 0
+	i := 0
+	for msg, err := range mboxfile.NewReader(f).All() {
+		if err == mboxfile.ErrInvalidFormat {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Error reading mbox: %v", err)
+		}
+		entry, checked := expect[i]
+		if checked && msg.Start != entry.Start {
+			return 0, errMailboxChanged
 		}
-		currentMessage.WriteString(line)
-		currentMessage.WriteString("\n")
+		if drop != nil && drop(i, string(msg.Header)) {
+			changed++
+		} else {
+			headers, updated := edit(i, string(msg.Header))
+			if updated {
+				changed++
+			}
+			if err := mboxfile.WriteMessage(tempFile, msg, []byte(headers)); err != nil {
+				return 0, fmt.Errorf("Error writing message to temp file: %v", err)
+			}
+		}
+		if checked {
+			// End is known once the body has been read
+			if _, err := io.Copy(io.Discard, msg.RawBody()); err != nil {
+				return 0, fmt.Errorf("Error reading mbox: %v", err)
+			}
+			if msg.End != entry.End {
+				return 0, errMailboxChanged
+			}
+			found++
+		}
+		i++
 	}
-	if currentMessage.Len() > 0 {
-		messages = append(messages, currentMessage.String())
+	if found != len(expect) {
+		return 0, errMailboxChanged
 	}
-	return messages, true
-}
 
-func SplitAtFirstNewline(s string) (string, string) {
-	if i := strings.Index(s, "\n"); i != -1 {
-		return s[:i], s[i+1:]
+	if changed == 0 {
+		return 0, nil
 	}
-	return s, ""
-}
 
-func SplitHeadersFromBody(s string) (string, string) {
-	if i := strings.Index(s, "\n\n"); i != -1 {
-		return s[:i+1], s[i+2:]
+	if err := mboxfile.CopyMode(tempFile, fi); err != nil {
+		return 0, fmt.Errorf("Error setting temp file mode: %v", err)
 	}
-	return s, ""
+	if err := tempFile.Close(); err != nil {
+		return 0, fmt.Errorf("Error closing temp file: %v", err)
+	}
+
+	if err := os.Rename(filepath.Clean(tempFile.Name()), mboxPath); err != nil {
+		return 0, fmt.Errorf("Error replacing original file: %v", err)
+	}
+
+	return changed, nil
 }
 
-func updateStatusHeader(headers string, newStatus string) (string, bool) {
-	statusStart := strings.Index(headers, "Status: ")
-	if statusStart != -1 {
-		statusEnd := strings.Index(headers[statusStart:], "\n")
-		if statusEnd != -1 {
-			statusEnd += statusStart
-		} else {
-			statusEnd = len(headers)
+// mailboxPath maps a UTF-8 mailbox name from the API to its file on disk.
+// Hierarchical names use "/" as the delimiter and map to directories; every
+// level is IMAP-UTF7 encoded on disk.
+func mailboxPath(mailboxName string) (string, error) {
+	segments := strings.Split(mailboxName, "/")
+	encoded := make([]string, len(segments))
+	for i, segment := range segments {
+		encodedSegment, err := utf7.Encoding.NewEncoder().String(segment)
+		if err != nil {
+			return "", err
 		}
-		nowStatus := strings.TrimSpace(headers[statusStart+len("Status: ") : statusEnd])
-		if newStatus == nowStatus {
-			return headers, false
+		if encodedSegment == "" || strings.HasPrefix(encodedSegment, ".") || strings.Contains(encodedSegment, `\`) {
+			return "", fmt.Errorf("invalid mailbox name: %q", mailboxName)
 		}
-		if headers[statusEnd] == '\n' {
-			statusEnd += 1
+		encoded[i] = encodedSegment
+	}
+	return filepath.Join(basePath, filepath.Join(encoded...)), nil
+}
+
+// listMailboxNames returns the UTF-8 names of the mbox files under basePath,
+// including those in subdirectories.
+func listMailboxNames() ([]string, error) {
+	nodes, err := mailboxTree(basePath, "", false)
+	if err != nil {
+		return nil, err
+	}
+
+	var mailboxes []string
+	var collect func(nodes []*MailboxNode)
+	collect = func(nodes []*MailboxNode) {
+		for _, node := range nodes {
+			if node.Selectable {
+				mailboxes = append(mailboxes, node.Path)
+			}
+			collect(node.Children)
 		}
-		return headers[:statusStart] + "Status: " + newStatus + "\n" + headers[statusEnd:], true
 	}
+	collect(nodes)
+	return mailboxes, nil
+}
 
-	if headers[len(headers)-1] != '\n' {
-		headers += "\n"
+// loadIndexOrError loads the index of mboxPath, answering the request with
+// an error when that fails.
+func loadIndexOrError(w http.ResponseWriter, r *http.Request, mboxPath string) (*mailboxIndex, bool) {
+	idx, err := loadIndex(mboxPath)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error indexing %s: %v", mboxPath, err)
+		http.Error(w, "Error reading mbox", http.StatusInternalServerError)
+		return nil, false
 	}
+	return idx, true
+}
 
-	return headers + "Status: " + newStatus + "\n", true
+// resolveEmail finds the index entry of an email by UID or position,
+// answering the request with an error when there is none.
+func resolveEmail(w http.ResponseWriter, r *http.Request, mailboxName string, emailIdStr string) (string, indexEntry, bool) {
+	mboxPath, err := mailboxPath(mailboxName)
+	if err != nil {
+		http.Error(w, "Invalid mailbox name", http.StatusBadRequest)
+		return "", indexEntry{}, false
+	}
+	idx, ok := loadIndexOrError(w, r, mboxPath)
+	if !ok {
+		return "", indexEntry{}, false
+	}
+	emailId, ok := idx.resolve(emailIdStr)
+	if !ok || idx.Entries[emailId].Malformed {
+		http.NotFound(w, r)
+		return "", indexEntry{}, false
+	}
+	return mboxPath, idx.Entries[emailId], true
+}
+
+// openMessage opens the message described by entry with a single seek.
+// The returned closer releases the underlying file.
+func openMessage(mboxPath string, entry indexEntry) (*mail.Message, io.Closer, error) {
+	f, err := os.Open(mboxPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := readMessageAt(f, entry)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return msg, f, nil
+}
+
+// openRawMessage opens the message described by entry without parsing it.
+// The returned closer releases the underlying file.
+func openRawMessage(mboxPath string, entry indexEntry) (*mboxfile.Message, io.Closer, error) {
+	f, err := os.Open(mboxPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := readRawMessageAt(f, entry)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return m, f, nil
+}
+
+// readMessageAt parses the message described by entry from an open mbox file.
+func readMessageAt(r io.ReaderAt, entry indexEntry) (*mail.Message, error) {
+	m, err := readRawMessageAt(r, entry)
+	if err != nil {
+		return nil, err
+	}
+	return mail.ReadMessage(m.Content())
+}
+
+// readRawMessageAt returns the message described by entry from an open mbox file.
+func readRawMessageAt(r io.ReaderAt, entry indexEntry) (*mboxfile.Message, error) {
+	for m, err := range mboxfile.NewSectionReader(r, entry.Start, entry.End).All() {
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return nil, io.ErrUnexpectedEOF
 }
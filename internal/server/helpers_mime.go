@@ -0,0 +1,472 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/emurenMRz/mboxview/internal/charset"
+)
+
+// mimePart is a node of a message's MIME structure.
+//
+// Parts keep their raw bytes so they can be served, measured or verified
+// exactly as sent. IDs follow IMAP section numbering: the children of a
+// multipart are "1", "2", ... below their parent's ID, and a single-part
+// message has its body as part "1". The entity signed by an opaque S/MIME
+// part is parsed as that part's child "1", the content of a TNEF
+// (winmail.dat) part as its children, and so are a plain text body and the
+// uuencoded files in it, though IMAP sees leaves there.
+type mimePart struct {
+	ID        string
+	Header    textproto.MIMEHeader
+	MediaType string            // lowercased; text/plain when missing or invalid
+	Params    map[string]string // Content-Type parameters
+	Raw       []byte            // the whole entity as found, header included
+	Body      []byte            // the body, still transfer-encoded
+	Children  []*mimePart
+	Message   *mimePart // of a message/rfc822 part: the enclosed message, with the same ID
+
+	derived bool   // made from content decoded out of another part
+	body    []byte // the decoded body, once decoded has been called
+}
+
+// parseMIMEMessage reads the whole message body and builds its part tree.
+// The root has an empty ID and the message header.
+func parseMIMEMessage(msg *mail.Message) (*mimePart, error) {
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	return newMessagePart("", textproto.MIMEHeader(msg.Header), nil, body), nil
+}
+
+// newMessagePart builds the part tree of a message whose body parts are
+// numbered below id.
+func newMessagePart(id string, header textproto.MIMEHeader, raw, body []byte) *mimePart {
+	root := newMIMEPart(id, header, raw, body, "text/plain")
+	if len(root.Children) == 0 {
+		// A single-part message: expose the body as part 1 as well
+		root.Children = []*mimePart{newMIMEPart(childID(id, 1), root.Header, root.Raw, root.Body, "text/plain")}
+	}
+	return root
+}
+
+// newMIMEPart creates the part for an entity and, for multiparts, its
+// children. defaultType applies when Content-Type is missing.
+func newMIMEPart(id string, header textproto.MIMEHeader, raw, body []byte, defaultType string) *mimePart {
+	return expandMIMEPart(&mimePart{ID: id, Header: header, Raw: raw, Body: body}, defaultType)
+}
+
+// expandMIMEPart reads the Content-Type of p and builds the parts below it.
+func expandMIMEPart(p *mimePart, defaultType string) *mimePart {
+	id, body := p.ID, p.Body
+	p.MediaType, p.Params = defaultType, map[string]string{}
+	if ct := p.Header.Get("Content-Type"); ct != "" {
+		if mediaType, params := parseHeaderParams(ct); mediaType != "" {
+			p.MediaType = mediaType
+			p.Params = params
+		} else {
+			p.MediaType = "text/plain"
+		}
+	}
+
+	if strings.HasPrefix(p.MediaType, "multipart/") && p.Params["boundary"] != "" {
+		childDefault := "text/plain"
+		if p.MediaType == "multipart/digest" {
+			childDefault = "message/rfc822"
+		}
+		for i, raw := range splitMultipart(body, p.Params["boundary"]) {
+			childHeader, childBody := splitEntity(raw)
+			p.Children = append(p.Children, newMIMEPart(childID(id, i+1), childHeader, raw, childBody, childDefault))
+		}
+	}
+
+	if content := smimeSignedContent(p); content != nil {
+		childHeader, childBody := splitEntity(content)
+		p.Children = []*mimePart{newMIMEPart(childID(id, 1), childHeader, content, childBody, "text/plain")}
+	}
+
+	if isTNEF(p) {
+		p.Children = tnefParts(p)
+	}
+
+	if p.MediaType == "text/plain" && id != "" && !p.derived {
+		// The root of a single-part message is seen again as its part 1,
+		// and text that came out of a part was searched with it
+		p.Children = encodedFileParts(p)
+	}
+
+	if (p.MediaType == "message/rfc822" || p.MediaType == "message/global") && id != "" {
+		// The enclosed message is itself transfer-encoded only rarely, but
+		// some mailers do base64 it
+		enclosed := p.decoded()
+		msgHeader, msgBody := splitEntity(enclosed)
+		p.Message = newMessagePart(id, msgHeader, enclosed, msgBody)
+	}
+	return p
+}
+
+// newDecodedPart creates a part for content found inside another part, such
+// as a file in winmail.dat, from its decoded body. A file name is given in
+// the Content-Disposition.
+func newDecodedPart(id, contentType, disposition, filename, contentID string, body []byte) *mimePart {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	if filename != "" {
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": filename})
+	}
+	if disposition != "" {
+		header.Set("Content-Disposition", disposition)
+	}
+	if contentID != "" {
+		header.Set("Content-ID", "<"+contentID+">")
+	}
+	// Without a Content-Transfer-Encoding the body is its decoded form
+	return expandMIMEPart(&mimePart{ID: id, Header: header, Raw: body, Body: body, derived: true, body: body}, "text/plain")
+}
+
+// fileContentType returns declared if it is a valid Content-Type, and
+// otherwise the type of a file by its name.
+func fileContentType(name, declared string) string {
+	if _, _, err := mime.ParseMediaType(declared); err == nil {
+		return declared
+	}
+	// The charset the extension table claims says nothing of the data
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name))); err == nil {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+func childID(parent string, n int) string {
+	if parent == "" {
+		return strconv.Itoa(n)
+	}
+	return parent + "." + strconv.Itoa(n)
+}
+
+// splitMultipart returns the raw body parts between the boundary delimiters.
+// The line break before each delimiter belongs to the delimiter.
+func splitMultipart(body []byte, boundary string) [][]byte {
+	delim := []byte("--" + boundary)
+	var parts [][]byte
+	start := -1
+	pos := 0
+	for pos <= len(body) {
+		lineEnd := bytes.IndexByte(body[pos:], '\n')
+		next := len(body) + 1
+		if lineEnd != -1 {
+			next = pos + lineEnd + 1
+		}
+		line := body[pos:min(next, len(body))]
+		if bytes.HasPrefix(line, delim) {
+			rest := bytes.TrimRight(line[len(delim):], " \t\r\n")
+			if len(rest) == 0 || bytes.Equal(rest, []byte("--")) {
+				if start >= 0 {
+					parts = append(parts, trimTrailingNewline(body[start:pos]))
+				}
+				if len(rest) > 0 {
+					return parts
+				}
+				start = min(next, len(body))
+			}
+		}
+		pos = next
+	}
+	// Missing final delimiter: keep what we have
+	if start >= 0 && start < len(body) {
+		parts = append(parts, body[start:])
+	}
+	return parts
+}
+
+func trimTrailingNewline(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	return bytes.TrimSuffix(b, []byte("\r"))
+}
+
+// splitEntity separates the header of a body part from its body.
+func splitEntity(raw []byte) (textproto.MIMEHeader, []byte) {
+	headerEnd, bodyStart := -1, len(raw)
+	if len(raw) > 0 && raw[0] == '\n' {
+		headerEnd, bodyStart = 0, 1
+	} else if bytes.HasPrefix(raw, []byte("\r\n")) {
+		headerEnd, bodyStart = 0, 2
+	} else if i := bytes.Index(raw, []byte("\n\n")); i != -1 {
+		headerEnd, bodyStart = i+1, i+2
+		if j := bytes.Index(raw, []byte("\r\n\r\n")); j != -1 && j < i {
+			headerEnd, bodyStart = j+2, j+4
+		}
+	} else if j := bytes.Index(raw, []byte("\r\n\r\n")); j != -1 {
+		headerEnd, bodyStart = j+2, j+4
+	}
+	if headerEnd < 0 {
+		// No blank line: the part is all header
+		headerEnd = len(raw)
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(raw[:headerEnd]), strings.NewReader("\r\n"))))
+	header, err := tp.ReadMIMEHeader()
+	if err != nil && header == nil {
+		header = textproto.MIMEHeader{}
+	}
+	return header, raw[bodyStart:]
+}
+
+// decoded returns the body with its Content-Transfer-Encoding removed.
+// Corrupt encodings yield whatever could be decoded. The result is kept, so
+// callers must not modify it.
+func (p *mimePart) decoded() []byte {
+	if p.body != nil {
+		return p.body
+	}
+	var reader io.Reader = bytes.NewReader(p.Body)
+	switch strings.ToLower(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		reader = base64.NewDecoder(base64.StdEncoding, &base64Filter{r: reader})
+	case "quoted-printable":
+		reader = quotedprintable.NewReader(reader)
+	default:
+		// 7bit, 8bit, binary -> no wrapper
+	}
+	data, _ := io.ReadAll(reader)
+	p.body = data
+	return data
+}
+
+// base64Filter drops bytes outside the base64 alphabet, such as line breaks
+// and stray whitespace.
+type base64Filter struct {
+	r io.Reader
+}
+
+func (f *base64Filter) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	j := 0
+	for i := 0; i < n; i++ {
+		c := p[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/' || c == '=' {
+			p[j] = c
+			j++
+		}
+	}
+	return j, err
+}
+
+// find returns the part with the given ID.
+func (p *mimePart) find(id string) *mimePart {
+	if p.ID == id {
+		return p
+	}
+	for _, c := range p.Children {
+		if c.ID == id || strings.HasPrefix(id, c.ID+".") {
+			if found := c.find(id); found != nil {
+				return found
+			}
+		}
+	}
+	if p.Message != nil {
+		// The enclosed message has the same ID, so only its parts are found
+		return p.Message.find(id)
+	}
+	return nil
+}
+
+// structure describes the part and everything below it.
+func (p *mimePart) structure() *MIMEStructure {
+	disp, dispParams := p.disposition()
+	s := &MIMEStructure{
+		PartID:      p.ID,
+		ContentType: p.MediaType,
+		Encoding:    strings.ToLower(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding"))),
+		Disposition: disp,
+		Filename:    p.filename(),
+		ContentID:   p.contentID(),
+		Description: decodeHeader(p.Header.Get("Content-Description")),
+		Size:        len(p.Body),
+	}
+	if len(p.Params) > 0 {
+		s.Params = p.Params
+	}
+	if len(dispParams) > 0 {
+		s.DispositionParams = dispParams
+	}
+	if len(p.Children) == 0 {
+		s.DecodedSize = len(p.decoded())
+	}
+	for _, c := range p.Children {
+		s.Children = append(s.Children, c.structure())
+	}
+	if p.Message != nil {
+		s.Message = p.Message.structure()
+	}
+	return s
+}
+
+// disposition returns the lowercased Content-Disposition type and parameters.
+func (p *mimePart) disposition() (string, map[string]string) {
+	return parseHeaderParams(p.Header.Get("Content-Disposition"))
+}
+
+// filename returns the decoded file name from Content-Disposition or, as a
+// fallback, the Content-Type name parameter.
+func (p *mimePart) filename() string {
+	_, params := p.disposition()
+	name := params["filename"]
+	if name == "" {
+		name = p.Params["name"]
+	}
+	if name == "" {
+		return ""
+	}
+	// Many mailers put RFC 2047 encoded-words or raw 8-bit text inside
+	// quoted parameters
+	return decodeHeader(name)
+}
+
+// contentID returns the Content-ID without angle brackets.
+func (p *mimePart) contentID() string {
+	return strings.Trim(strings.TrimSpace(p.Header.Get("Content-ID")), "<>")
+}
+
+// parseHeaderParams parses a header value of the form "type; a=b; c=d",
+// returning the lowercased type and parameters. It accepts what
+// mime.ParseMediaType does and in addition RFC 2231 values in any charset
+// and unquoted 8-bit values sent by sloppy mailers.
+func parseHeaderParams(value string) (string, map[string]string) {
+	goType, goParams, err := mime.ParseMediaType(value)
+	if err == nil && !strings.Contains(value, "*=") {
+		return goType, goParams
+	}
+
+	// mime.ParseMediaType silently drops RFC 2231 values in charsets other
+	// than UTF-8 and US-ASCII, so extended parameters are always parsed here
+	segments := splitParams(value)
+	if len(segments) == 0 {
+		return "", map[string]string{}
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(segments[0]))
+
+	plain := map[string]string{}
+	extended := map[string]map[int]string{} // name -> section -> value
+	charsets := map[string]string{}
+	for _, seg := range segments[1:] {
+		key, val, ok := strings.Cut(seg, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+		if strings.HasPrefix(val, `"`) {
+			val = strings.ReplaceAll(strings.Trim(val, `"`), `\"`, `"`)
+		}
+
+		name, section, encoded := key, 0, false
+		if strings.HasSuffix(name, "*") {
+			name, encoded = strings.TrimSuffix(name, "*"), true
+		}
+		if i := strings.Index(name, "*"); i != -1 {
+			n, err := strconv.Atoi(name[i+1:])
+			if err != nil {
+				continue
+			}
+			name, section = name[:i], n
+		}
+		if !encoded && section == 0 && !strings.Contains(key, "*") {
+			plain[name] = val
+			continue
+		}
+		if encoded {
+			if section == 0 {
+				if parts := strings.SplitN(val, "'", 3); len(parts) == 3 {
+					charsets[name] = parts[0]
+					val = parts[2]
+				}
+			}
+			if unescaped, err := percentDecode(val); err == nil {
+				val = unescaped
+			}
+		}
+		if extended[name] == nil {
+			extended[name] = map[int]string{}
+		}
+		extended[name][section] = val
+	}
+
+	params := plain
+	for name, v := range goParams {
+		params[name] = v
+	}
+	for name, sections := range extended {
+		var b strings.Builder
+		for i := 0; ; i++ {
+			s, ok := sections[i]
+			if !ok {
+				break
+			}
+			b.WriteString(s)
+		}
+		params[name] = decodeCharset(charsets[name], []byte(b.String()))
+	}
+	return mediaType, params
+}
+
+// splitParams splits on semicolons outside quoted strings.
+func splitParams(value string) []string {
+	var segments []string
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && inQuote && i+1 < len(value):
+			cur.WriteByte(c)
+			i++
+			cur.WriteByte(value[i])
+			continue
+		case c == '"':
+			inQuote = !inQuote
+		case c == ';' && !inQuote:
+			segments = append(segments, cur.String())
+			cur.Reset()
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	if strings.TrimSpace(cur.String()) != "" || len(segments) == 0 {
+		segments = append(segments, cur.String())
+	}
+	return segments
+}
+
+func percentDecode(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", err
+			}
+			b.WriteByte(byte(n))
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), nil
+}
+
+// decodeCharset converts text in the named charset to UTF-8, detecting the
+// charset when the name is missing or wrong.
+func decodeCharset(label string, data []byte) string {
+	return charset.Decode(data, label)
+}
@@ -1,115 +1,278 @@
 package server
 
 import (
-	"encoding/base64"
 	"io"
 	"log"
 	"mime"
-	"mime/multipart"
-	"mime/quotedprintable"
 	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
-	"golang.org/x/text/encoding/ianaindex"
-	"golang.org/x/text/transform"
+	"github.com/emurenMRz/mboxview/internal/charset"
 )
 
+// parseMessageBody returns the bodies and attachments of a message. The
+// HTML body is sanitized with remote content blocked.
 func parseMessageBody(msg *mail.Message) EmailContent {
+	root, err := parseMIMEMessage(msg)
+	if err != nil {
+		log.Printf("Error reading message body: %v", err)
+		return EmailContent{Attachments: []Attachment{}}
+	}
+	content := partsContent(root)
+	prepareContent(&content, root, displayOptions{})
+	return content
+}
+
+// displayOptions select how prepareContent renders message content.
+type displayOptions struct {
+	baseURL     string // where cid: references point to; empty leaves them alone
+	allowRemote bool   // keep remote images and CSS in HTML bodies
+	textHTML    bool   // render plain text bodies as HTML too
+}
+
+// prepareContent makes the bodies of content and of its enclosed messages
+// fit for display: cid: references are rewritten, HTML is sanitized, and
+// plain text is rendered as HTML if requested. root is the message content
+// was taken from.
+func prepareContent(content *EmailContent, root *mimePart, opts displayOptions) {
+	if opts.baseURL != "" {
+		content.BodyHTML = rewriteContentIDs(content.BodyHTML, root, opts.baseURL)
+	}
+	content.BodyHTML, content.RemoteBlocked = sanitizeHTML(content.BodyHTML, opts.allowRemote)
+	if opts.textHTML && content.BodyText != "" {
+		content.BodyTextHTML = textToHTML(content.BodyText)
+	}
+	for i := range content.Messages {
+		m := &content.Messages[i]
+		prepareContent(&m.EmailContent, m.root, opts)
+		content.RemoteBlocked = content.RemoteBlocked || m.RemoteBlocked
+	}
+}
+
+// partsContent picks the displayable bodies out of a part tree. The first
+// text/plain and text/html parts not marked as attachments are the bodies;
+// every other leaf part is listed as an attachment. Enclosed messages are
+// listed as attachments and, parsed the same way, in Messages. Signatures
+// are verified and reported instead of listed, and so are the encrypted
+// parts, whose data is still listed. A TNEF part is replaced by its
+// content, its body used only where the message has none of that type.
+func partsContent(root *mimePart) EmailContent {
 	var content EmailContent
-	content.Attachments = []string{}
-
-	// recursive entity processor
-	var processEntity func(header interface{ Get(string) string }, body io.Reader)
-	processEntity = func(header interface{ Get(string) string }, body io.Reader) {
-		ctype, params, err := mime.ParseMediaType(header.Get("Content-Type"))
-		if err != nil {
-			ctype = "text/plain"
-		}
+	content.Attachments = []Attachment{}
+	from := root.Header.Get("From")
 
-		// handle multipart recursively
-		if strings.HasPrefix(ctype, "multipart/") {
-			mr := multipart.NewReader(body, params["boundary"])
-			for {
-				p, err := mr.NextPart()
-				if err == io.EOF {
-					break
+	var walk func(p *mimePart)
+	walk = func(p *mimePart) {
+		if len(p.Children) > 0 {
+			children := relatedOrder(p)
+			switch protocol := signedProtocol(p); {
+			case p.MediaType == "multipart/signed" && protocol != "" && len(p.Children) == 2:
+				content.Signatures = append(content.Signatures, verifySigned(p, protocol, from))
+				children = p.Children[:1]
+			case p.MediaType == "multipart/encrypted" && protocol != "":
+				content.Encrypted = append(content.Encrypted, EncryptedPart{PartID: p.ID, Protocol: protocol})
+				// The first part only names the version of the protocol
+				children = p.Children[1:]
+			case p.MediaType == "multipart/report":
+				if report, ok := deliveryReport(p); ok {
+					content.Reports = append(content.Reports, report)
 				}
-				if err != nil {
-					log.Printf("Error reading multipart body: %v", err)
-					break
+			case isSMIMEObject(p) && !isSMIMEObject(p.Children[0]):
+				// Signed data, parsed into its content; a single-part
+				// message has the object itself as its child instead
+				r := verifier.VerifySMIME(nil, p.decoded())
+				content.Signatures = append(content.Signatures, signatureInfo(p.ID, "smime", r, from))
+			case isTNEF(p):
+				// Outlook usually sends the plain text body as MIME too
+				children = nil
+				for _, c := range p.Children {
+					if isTNEFBody(c) && (c.MediaType == "text/plain" && content.BodyText != "" || c.MediaType == "text/html" && content.BodyHTML != "") {
+						continue
+					}
+					children = append(children, c)
 				}
-				processEntity(p.Header, p)
+			}
+			for _, c := range children {
+				walk(c)
 			}
 			return
 		}
 
-		// handle attachments
-		if disp, dispParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && disp == "attachment" {
-			content.Attachments = append(content.Attachments, dispParams["filename"])
+		disp, _ := p.disposition()
+		switch {
+		case disp != "attachment" && p.MediaType == "text/html" && content.BodyHTML == "":
+			content.BodyHTML = p.text()
+			content.BodyType = "text/html"
+		case disp != "attachment" && p.MediaType == "text/plain" && content.BodyText == "":
+			data := p.decoded()
+			if signed, r, ok := verifier.VerifyClearsigned(data); ok {
+				content.Signatures = append(content.Signatures, signatureInfo(p.ID, "pgp", r, from))
+				data = signed
+			} else if isInlinePGPEncrypted(data) {
+				content.Encrypted = append(content.Encrypted, EncryptedPart{PartID: p.ID, Protocol: "pgp"})
+			}
+			content.BodyText = p.textOf(data)
+			content.BodyType = "text/plain"
+		default:
+			if isSMIMEObject(p) {
+				// Readable signed data has its content as a child
+				switch strings.ToLower(p.Params["smime-type"]) {
+				case "signed-data":
+					r := verifier.VerifySMIME(nil, p.decoded())
+					content.Signatures = append(content.Signatures, signatureInfo(p.ID, "smime", r, from))
+				case "certs-only":
+				default:
+					content.Encrypted = append(content.Encrypted, EncryptedPart{PartID: p.ID, Protocol: "smime"})
+				}
+			}
+			content.Attachments = append(content.Attachments, p.attachment())
+			if p.MediaType == "text/calendar" || p.MediaType == "application/ics" {
+				if info, ok := calendarInfo(p); ok {
+					content.Calendars = append(content.Calendars, info)
+				}
+			}
+			if p.Message != nil {
+				content.Messages = append(content.Messages, embeddedMessage(p))
+			}
 			return
 		}
 
-		// handle content-transfer-encoding
-		cte := strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding")))
-		reader := body
-		switch cte {
-		case "base64":
-			reader = base64.NewDecoder(base64.StdEncoding, body)
-		case "quoted-printable":
-			reader = quotedprintable.NewReader(body)
-		default:
-			// 7bit, 8bit, binary -> no wrapper
+		// Track if both text and HTML are available
+		if content.BodyText != "" && content.BodyHTML != "" {
+			content.HasAlternate = true
+			// Prefer PlainText for primary display
+			content.BodyType = "text/plain"
 		}
+	}
 
-		if ctype == "text/plain" || ctype == "text/html" {
-			bodyBytes, err := io.ReadAll(reader)
-			if err != nil {
-				return
-			}
+	walk(root)
+	return content
+}
 
-			charset := params["charset"]
-			if charset == "" {
-				charset = "utf-8"
-			}
+// embeddedMessage describes the message enclosed in a message/rfc822 part.
+func embeddedMessage(p *mimePart) EmbeddedMessage {
+	h := p.Message.Header
+	return EmbeddedMessage{
+		PartID:       p.ID,
+		From:         decodeAddressList(h.Get("From")),
+		To:           decodeAddressList(h.Get("To")),
+		Cc:           decodeAddressList(h.Get("Cc")),
+		Subject:      decodeHeader(h.Get("Subject")),
+		Date:         h.Get("Date"),
+		MessageID:    h.Get("Message-ID"),
+		EmailContent: partsContent(p.Message),
+		root:         p.Message,
+	}
+}
 
-			encoding, err := ianaindex.IANA.Encoding(charset)
-			if err != nil || encoding == nil {
-				encoding, _ = ianaindex.IANA.Encoding("utf-8")
-			}
+// relatedOrder returns the children of p, moving the root of a
+// multipart/related (named by its start parameter) to the front so that it
+// becomes the body and the other parts its resources.
+func relatedOrder(p *mimePart) []*mimePart {
+	start := strings.Trim(p.Params["start"], "<>")
+	if p.MediaType != "multipart/related" || start == "" {
+		return p.Children
+	}
+	for i, c := range p.Children {
+		if c.contentID() == start {
+			ordered := append([]*mimePart{c}, p.Children[:i]...)
+			return append(ordered, p.Children[i+1:]...)
+		}
+	}
+	return p.Children
+}
 
-			var decodedBody []byte
-			if err != nil {
-				decodedBody = bodyBytes
-			} else {
-				decodedBody, _ = encoding.NewDecoder().Bytes(bodyBytes)
-			}
+var cidURLRegex = regexp.MustCompile(`(?i)\bcid:([^"'\s<>)]+)`)
 
-			// Store in appropriate field
-			switch ctype {
-			case "text/html":
-				if content.BodyHTML == "" {
-					content.BodyHTML = string(decodedBody)
-				}
-				content.BodyType = "text/html"
-			case "text/plain":
-				if content.BodyText == "" {
-					content.BodyText = string(decodedBody)
-				}
-				content.BodyType = "text/plain"
+// rewriteContentIDs replaces cid: URLs (RFC 2392) in an HTML body with links
+// to the referenced parts, so inline images load from the attachments route.
+// References to unknown Content-IDs are left alone.
+func rewriteContentIDs(html string, root *mimePart, baseURL string) string {
+	if html == "" {
+		return html
+	}
+	byID := map[string]*mimePart{}
+	byFoldedID := map[string]*mimePart{}
+	var collect func(p *mimePart)
+	collect = func(p *mimePart) {
+		if id := p.contentID(); id != "" && len(p.Children) == 0 {
+			if _, ok := byID[id]; !ok {
+				byID[id] = p
+			}
+			if _, ok := byFoldedID[strings.ToLower(id)]; !ok {
+				byFoldedID[strings.ToLower(id)] = p
 			}
+		}
+		for _, c := range p.Children {
+			collect(c)
+		}
+	}
+	collect(root)
+	if len(byID) == 0 {
+		return html
+	}
 
-			// Track if both text and HTML are available
-			if content.BodyText != "" && content.BodyHTML != "" {
-				content.HasAlternate = true
-				// Prefer PlainText for primary display
-				content.BodyType = "text/plain"
+	return cidURLRegex.ReplaceAllStringFunc(html, func(m string) string {
+		id := m[len("cid:"):]
+		if unescaped, err := url.PathUnescape(id); err == nil {
+			id = unescaped
+		}
+		part, ok := byID[id]
+		if !ok {
+			// Content-IDs are case-sensitive, but some senders disagree with themselves
+			if part, ok = byFoldedID[strings.ToLower(id)]; !ok {
+				return m
 			}
 		}
+		return baseURL + part.ID
+	})
+}
+
+// attachmentURL returns the URL prefix of the parts of an email; the part ID
+// is appended to it.
+func attachmentURL(mailboxName, uid string) string {
+	return "/api/mailboxes/" + url.PathEscape(mailboxName) + "/emails/" + url.PathEscape(uid) + "/attachments/"
+}
+
+// text returns the decoded body of a text part converted to UTF-8. The
+// charset is detected when the part declares none or a wrong one, and
+// format=flowed plain text is reflowed.
+func (p *mimePart) text() string {
+	return p.textOf(p.decoded())
+}
+
+// textOf converts data, the decoded body of p or text derived from it, like
+// text does.
+func (p *mimePart) textOf(data []byte) string {
+	text := charset.Decode(data, p.Params["charset"])
+	if p.MediaType == "text/plain" && strings.EqualFold(p.Params["format"], "flowed") {
+		text = unflow(text, strings.EqualFold(p.Params["delsp"], "yes"))
 	}
+	return text
+}
 
-	processEntity(msg.Header, msg.Body)
-	return content
+// attachment describes a leaf part for the attachment list.
+func (p *mimePart) attachment() Attachment {
+	disp, _ := p.disposition()
+	if disp == "" {
+		disp = "attachment"
+		if p.contentID() != "" {
+			// Resources of multipart/related rarely say so
+			disp = "inline"
+		}
+	}
+	return Attachment{
+		PartID:      p.ID,
+		Filename:    p.filename(),
+		ContentType: p.MediaType,
+		Size:        len(p.decoded()),
+		ContentID:   p.contentID(),
+		Disposition: disp,
+	}
 }
 
 // parseDate tries to parse common email Date header formats and returns a time.Time.
@@ -138,21 +301,34 @@ func parseDate(dateStr string) time.Time {
 	return time.Time{}
 }
 
-func charsetReader(charset string, input io.Reader) (io.Reader, error) {
-	if charset == "" {
-		return input, nil
+// charsetReader converts text in the named charset to UTF-8, detecting the
+// charset when the name is unknown or wrong.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
 	}
-	enc, err := ianaindex.IANA.Encoding(strings.ToLower(charset))
-	if err != nil || enc == nil {
-		return input, nil
+	return strings.NewReader(charset.Decode(data, label)), nil
+}
+
+// decodeHeader returns a header value in UTF-8: raw 8-bit or ISO-2022-JP
+// text, which old mailers wrote without encoded-words, is converted first,
+// and then the RFC 2047 encoded-words are decoded.
+func decodeHeader(value string) string {
+	value = charset.DecodeHeader(value)
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
+	if dec, err := decoder.DecodeHeader(value); err == nil {
+		return dec
 	}
-	return transform.NewReader(input, enc.NewDecoder()), nil
+	return value
 }
 
-func decodeAddressList(header string, decoder *mime.WordDecoder) string {
+func decodeAddressList(header string) string {
 	if header == "" {
 		return ""
 	}
+	header = charset.DecodeHeader(header)
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
 	addrs, err := mail.ParseAddressList(header)
 	if err != nil {
 		// Fallback: try to decode the whole header as an encoded-word
@@ -175,3 +351,30 @@ func decodeAddressList(header string, decoder *mime.WordDecoder) string {
 	}
 	return strings.Join(parts, ", ")
 }
+
+var messageIDRegex = regexp.MustCompile(`<([^<>\s]+)>`)
+
+// parseMessageIDs returns the msg-ids found in a Message-ID, In-Reply-To or
+// References header, without angle brackets.
+func parseMessageIDs(header string) []string {
+	var ids []string
+	for _, m := range messageIDRegex.FindAllStringSubmatch(header, -1) {
+		ids = append(ids, m[1])
+	}
+	return ids
+}
+
+// mergeReferences appends the In-Reply-To ids missing from References. When
+// References is absent only the first In-Reply-To id is used, since further
+// ids there are often unrelated addresses.
+func mergeReferences(references, inReplyTo []string) []string {
+	if len(references) == 0 && len(inReplyTo) > 0 {
+		return inReplyTo[:1]
+	}
+	for _, id := range inReplyTo {
+		if !slices.Contains(references, id) {
+			references = append(references, id)
+		}
+	}
+	return references
+}
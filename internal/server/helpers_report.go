@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"strings"
+)
+
+// deliveryReport reads a multipart/report (RFC 6522): the machine-readable
+// part of a delivery status notification (RFC 3464) or of a message
+// disposition notification (RFC 8098), and the Message-ID of the message it
+// reports on, taken from the returned message or headers if the report
+// does not name it.
+func deliveryReport(p *mimePart) (DeliveryReport, bool) {
+	var status, original *mimePart
+	for _, c := range p.Children {
+		switch c.MediaType {
+		case "message/delivery-status", "message/global-delivery-status",
+			"message/disposition-notification", "message/global-disposition-notification":
+			if status == nil {
+				status = c
+			}
+		case "message/rfc822", "message/global", "text/rfc822-headers", "message/global-headers":
+			if original == nil {
+				original = c
+			}
+		}
+	}
+	if status == nil {
+		return DeliveryReport{}, false
+	}
+	blocks := parseFieldBlocks(status.decoded())
+	if len(blocks) == 0 {
+		return DeliveryReport{}, false
+	}
+
+	report := DeliveryReport{PartID: status.ID}
+	fields := blocks[0]
+	if strings.HasSuffix(status.MediaType, "delivery-status") {
+		report.Type = "delivery-status"
+		report.Reporter = stripTypePrefix(fields.Get("Reporting-MTA"))
+		report.ArrivalDate = fields.Get("Arrival-Date")
+		report.OriginalEnvelopeID = fields.Get("Original-Envelope-Id")
+		for _, b := range blocks[1:] {
+			rcpt := DeliveryRecipient{
+				FinalRecipient:    stripTypePrefix(b.Get("Final-Recipient")),
+				OriginalRecipient: stripTypePrefix(b.Get("Original-Recipient")),
+				Action:            strings.ToLower(strings.TrimSpace(b.Get("Action"))),
+				Status:            firstField(b.Get("Status")),
+				RemoteMTA:         stripTypePrefix(b.Get("Remote-MTA")),
+				DiagnosticCode:    stripTypePrefix(b.Get("Diagnostic-Code")),
+				LastAttemptDate:   b.Get("Last-Attempt-Date"),
+				WillRetryUntil:    b.Get("Will-Retry-Until"),
+			}
+			if rcpt.FinalRecipient == "" && rcpt.OriginalRecipient == "" {
+				continue
+			}
+			report.Recipients = append(report.Recipients, rcpt)
+		}
+	} else {
+		// An MDN is a single block; some agents split it anyway
+		for _, b := range blocks[1:] {
+			for k, v := range b {
+				fields[k] = append(fields[k], v...)
+			}
+		}
+		report.Type = "disposition-notification"
+		// "ua-name; ua-product", not a typed value
+		report.Reporter = strings.TrimSpace(fields.Get("Reporting-UA"))
+		report.OriginalMessageID = strings.TrimSpace(fields.Get("Original-Message-ID"))
+		mode, disposition, _ := strings.Cut(fields.Get("Disposition"), ";")
+		report.DispositionMode = strings.TrimSpace(mode)
+		report.Disposition = strings.ToLower(strings.TrimSpace(disposition))
+		rcpt := DeliveryRecipient{
+			FinalRecipient:    stripTypePrefix(fields.Get("Final-Recipient")),
+			OriginalRecipient: stripTypePrefix(fields.Get("Original-Recipient")),
+		}
+		if rcpt.FinalRecipient != "" || rcpt.OriginalRecipient != "" {
+			report.Recipients = append(report.Recipients, rcpt)
+		}
+	}
+
+	if report.OriginalMessageID == "" && original != nil {
+		header := textproto.MIMEHeader{}
+		if original.Message != nil {
+			header = original.Message.Header
+		} else {
+			// text/rfc822-headers is a header block with no body
+			header, _ = splitEntity(original.decoded())
+		}
+		report.OriginalMessageID = strings.TrimSpace(header.Get("Message-ID"))
+		report.OriginalSubject = decodeHeader(header.Get("Subject"))
+	}
+	return report, true
+}
+
+// parseFieldBlocks reads the header-like blocks of a status part, which are
+// separated by blank lines.
+func parseFieldBlocks(data []byte) []textproto.MIMEHeader {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	var blocks []textproto.MIMEHeader
+	for _, raw := range bytes.Split(data, []byte("\n\n")) {
+		raw = bytes.Trim(raw, "\n")
+		if len(raw) == 0 {
+			continue
+		}
+		tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(raw, "\n\n"...))))
+		header, err := tp.ReadMIMEHeader()
+		if err != nil && len(header) == 0 {
+			continue
+		}
+		blocks = append(blocks, header)
+	}
+	return blocks
+}
+
+// stripTypePrefix removes the type of a typed field value, such as "rfc822;"
+// of an address, "dns;" of a host or "smtp;" of a diagnostic.
+func stripTypePrefix(value string) string {
+	value = strings.TrimSpace(value)
+	if kind, rest, ok := strings.Cut(value, ";"); ok && !strings.ContainsAny(kind, " <@") {
+		return strings.TrimSpace(rest)
+	}
+	return value
+}
+
+func firstField(value string) string {
+	if f := strings.Fields(value); len(f) > 0 {
+		return f[0]
+	}
+	return ""
+}
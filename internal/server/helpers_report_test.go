@@ -0,0 +1,209 @@
+package server
+
+import (
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseTestMessage builds the part tree of a raw message written with LF
+// line endings.
+func parseTestMessage(t *testing.T, raw string) *mimePart {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(strings.ReplaceAll(raw, "\n", "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseMIMEMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestDeliveryReportDSN(t *testing.T) {
+	root := parseTestMessage(t, `From: MAILER-DAEMON@mx.example.com
+Subject: Undelivered Mail Returned to Sender
+Content-Type: multipart/report; report-type=delivery-status; boundary="b"
+
+--b
+Content-Type: text/plain
+
+Your message could not be delivered.
+
+--b
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+Original-Envelope-Id: 0123ABC
+Arrival-Date: Mon, 1 Apr 2024 10:00:00 +0000
+
+Final-Recipient: rfc822; nobody@example.org
+Original-Recipient: rfc822;Nobody@Example.org
+Action: Failed
+Status: 5.1.1 (bad destination mailbox address)
+Remote-MTA: dns; mail.example.org
+Diagnostic-Code: smtp; 550 5.1.1 <nobody@example.org>: Recipient address
+ rejected: User unknown
+Last-Attempt-Date: Mon, 1 Apr 2024 10:00:05 +0000
+
+Final-Recipient: rfc822; later@example.org
+Action: delayed
+Status: 4.4.1
+Will-Retry-Until: Fri, 5 Apr 2024 10:00:00 +0000
+
+Action: failed
+Status: 5.0.0
+
+--b
+Content-Type: message/rfc822
+
+Message-ID: <original@example.com>
+Subject: =?UTF-8?B?5pel5pys?=
+
+Hello
+--b--
+`)
+
+	report, ok := deliveryReport(root)
+	if !ok {
+		t.Fatal("no report found")
+	}
+	want := DeliveryReport{
+		PartID:             "2",
+		Type:               "delivery-status",
+		Reporter:           "mx.example.com",
+		ArrivalDate:        "Mon, 1 Apr 2024 10:00:00 +0000",
+		OriginalEnvelopeID: "0123ABC",
+		OriginalMessageID:  "<original@example.com>",
+		OriginalSubject:    "日本",
+		Recipients: []DeliveryRecipient{
+			{
+				FinalRecipient:    "nobody@example.org",
+				OriginalRecipient: "Nobody@Example.org",
+				Action:            "failed",
+				Status:            "5.1.1",
+				RemoteMTA:         "mail.example.org",
+				DiagnosticCode:    "550 5.1.1 <nobody@example.org>: Recipient address rejected: User unknown",
+				LastAttemptDate:   "Mon, 1 Apr 2024 10:00:05 +0000",
+			},
+			{
+				FinalRecipient: "later@example.org",
+				Action:         "delayed",
+				Status:         "4.4.1",
+				WillRetryUntil: "Fri, 5 Apr 2024 10:00:00 +0000",
+			},
+		},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v\nwant %+v", report, want)
+	}
+}
+
+func TestDeliveryReportMDN(t *testing.T) {
+	root := parseTestMessage(t, `From: bob@example.org
+Subject: Read: hello
+Content-Type: multipart/report; report-type=disposition-notification; boundary="b"
+
+--b
+Content-Type: text/plain
+
+The message was displayed.
+
+--b
+Content-Type: message/disposition-notification
+
+Reporting-UA: mail.example.org; ExampleMail 2.0
+Original-Recipient: rfc822;bob@example.org
+Final-Recipient: rfc822; bob@example.org
+
+Original-Message-ID: <original@example.com>
+Disposition: manual-action/MDN-sent-manually; Displayed
+
+--b
+Content-Type: text/rfc822-headers
+
+Message-ID: <ignored@example.com>
+Subject: hello
+
+--b--
+`)
+
+	report, ok := deliveryReport(root)
+	if !ok {
+		t.Fatal("no report found")
+	}
+	want := DeliveryReport{
+		PartID:            "2",
+		Type:              "disposition-notification",
+		Reporter:          "mail.example.org; ExampleMail 2.0",
+		OriginalMessageID: "<original@example.com>",
+		Disposition:       "displayed",
+		DispositionMode:   "manual-action/MDN-sent-manually",
+		Recipients: []DeliveryRecipient{
+			{FinalRecipient: "bob@example.org", OriginalRecipient: "bob@example.org"},
+		},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v\nwant %+v", report, want)
+	}
+}
+
+func TestDeliveryReportOriginalHeaders(t *testing.T) {
+	// Without Original-Message-ID the returned headers name the message
+	root := parseTestMessage(t, `Content-Type: multipart/report; report-type=disposition-notification; boundary="b"
+
+--b
+Content-Type: message/disposition-notification
+
+Final-Recipient: rfc822; bob@example.org
+Disposition: automatic-action/MDN-sent-automatically; deleted
+
+--b
+Content-Type: text/rfc822-headers
+
+Message-ID: <original@example.com>
+Subject: hello
+
+--b--
+`)
+	report, ok := deliveryReport(root)
+	if !ok {
+		t.Fatal("no report found")
+	}
+	if report.OriginalMessageID != "<original@example.com>" || report.OriginalSubject != "hello" || report.Disposition != "deleted" {
+		t.Errorf("report = %+v", report)
+	}
+
+	// A multipart/mixed without a status part is not a report
+	if _, ok := deliveryReport(parseTestMessage(t, `Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+hi
+--b--
+`)); ok {
+		t.Error("deliveryReport found a report in a plain message")
+	}
+}
+
+func TestStripTypePrefix(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"rfc822; a@example.com", "a@example.com"},
+		{"dns;mx.example.com", "mx.example.com"},
+		{"smtp; 550 5.1.1 unknown; try later", "550 5.1.1 unknown; try later"},
+		{"a@example.com", "a@example.com"},
+		{"Bob <b@example.com>; x", "Bob <b@example.com>; x"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := stripTypePrefix(tt.value); got != tt.want {
+			t.Errorf("stripTypePrefix(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
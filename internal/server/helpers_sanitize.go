@@ -0,0 +1,436 @@
+package server
+
+import (
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// blockedImage replaces remote images: a transparent 1x1 GIF, so that the
+// layout of the message is kept.
+const blockedImage = "data:image/gif;base64,R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7"
+
+// blockedURL replaces remote and unsafe URLs in CSS.
+const blockedURL = "about:invalid#blocked"
+
+// droppedElements are removed together with their content.
+var droppedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Noscript: true, atom.Template: true,
+	atom.Iframe: true, atom.Frame: true, atom.Frameset: true,
+	atom.Object: true, atom.Embed: true, atom.Applet: true, atom.Param: true,
+	atom.Base: true, atom.Meta: true,
+	atom.Svg: true, atom.Math: true,
+	atom.Input: true, atom.Button: true, atom.Select: true, atom.Textarea: true,
+	atom.Option: true, atom.Optgroup: true, atom.Datalist: true, atom.Keygen: true,
+	atom.Audio: true, atom.Video: true, atom.Source: true, atom.Track: true,
+}
+
+// unwrappedElements are removed but their content is kept.
+var unwrappedElements = map[atom.Atom]bool{
+	atom.Form: true,
+}
+
+// droppedAttributes are removed wherever they appear. Event handlers
+// (on...) are removed as well.
+var droppedAttributes = map[string]bool{
+	"action": true, "formaction": true, "srcdoc": true, "ping": true,
+	"http-equiv": true, "autofocus": true,
+}
+
+// linkAttributes hold URLs that are followed only when clicked.
+var linkAttributes = map[string]bool{
+	"href": true, "cite": true, "longdesc": true,
+}
+
+// resourceAttributes hold URLs that are loaded with the message.
+var resourceAttributes = map[string]bool{
+	"src": true, "background": true, "poster": true, "lowsrc": true, "dynsrc": true,
+}
+
+// sanitizeHTML removes scripts, event handlers, forms, embedded objects and
+// dangerous CSS from an HTML body. Unless allowRemote is set, images and CSS
+// loaded from other hosts are replaced by placeholders so that opening a
+// message does not notify its sender; it reports whether any were.
+func sanitizeHTML(body string, allowRemote bool) (string, bool) {
+	if body == "" {
+		return body, false
+	}
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		log.Printf("Error parsing HTML body: %v", err)
+		return "", false
+	}
+
+	s := &sanitizer{allowRemote: allowRemote}
+	s.walk(doc)
+	addContentPolicy(doc, allowRemote)
+
+	var b strings.Builder
+	if err := html.Render(&b, doc); err != nil {
+		log.Printf("Error rendering HTML body: %v", err)
+		return "", false
+	}
+	return b.String(), s.blocked
+}
+
+type sanitizer struct {
+	allowRemote bool
+	blocked     bool // a remote resource was replaced
+}
+
+func (s *sanitizer) walk(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.CommentNode:
+			n.RemoveChild(c)
+		case c.Type != html.ElementNode:
+		case c.DataAtom == atom.Link && c.Namespace == "":
+			if s.keepStylesheet(c) {
+				s.element(c)
+			} else {
+				n.RemoveChild(c)
+			}
+		case c.Namespace != "" || droppedElements[c.DataAtom] || c.DataAtom == 0 && isDangerousName(c.Data):
+			n.RemoveChild(c)
+		case unwrappedElements[c.DataAtom]:
+			s.walk(c)
+			for gc := c.FirstChild; gc != nil; gc = c.FirstChild {
+				c.RemoveChild(gc)
+				n.InsertBefore(gc, c)
+			}
+			n.RemoveChild(c)
+		default:
+			s.element(c)
+			s.walk(c)
+		}
+		c = next
+	}
+}
+
+// element sanitizes the attributes of an element and the content of a style
+// element.
+func (s *sanitizer) element(n *html.Node) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		switch {
+		case a.Namespace != "", droppedAttributes[key], strings.HasPrefix(key, "on"):
+			continue
+		case linkAttributes[key]:
+			if !isSafeLink(a.Val) {
+				continue
+			}
+		case resourceAttributes[key]:
+			switch classifyResource(a.Val) {
+			case resourceUnsafe:
+				continue
+			case resourceRemote:
+				if !s.allowRemote {
+					s.blocked = true
+					if n.DataAtom != atom.Img || key != "src" {
+						continue
+					}
+					a.Val = blockedImage
+				}
+			}
+		case key == "srcset":
+			kind := resourceLocal
+			for _, candidate := range strings.Split(a.Val, ",") {
+				fields := strings.Fields(candidate)
+				if len(fields) > 0 {
+					kind = max(kind, classifyResource(fields[0]))
+				}
+			}
+			if kind == resourceUnsafe {
+				continue
+			}
+			if kind == resourceRemote && !s.allowRemote {
+				s.blocked = true
+				continue
+			}
+		case key == "style":
+			css, blocked := sanitizeCSS(a.Val, s.allowRemote)
+			s.blocked = s.blocked || blocked
+			a.Val = css
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+
+	if n.DataAtom == atom.Style {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				css, blocked := sanitizeCSS(c.Data, s.allowRemote)
+				s.blocked = s.blocked || blocked
+				// The sheet must not be able to close its element
+				c.Data = strings.ReplaceAll(css, "</", `<\/`)
+			}
+		}
+	}
+}
+
+// isDangerousName catches elements the parser does not know, such as
+// vendor-prefixed or misspelled variants of dropped ones.
+func isDangerousName(name string) bool {
+	name = strings.ToLower(name)
+	return strings.Contains(name, "script") || strings.Contains(name, "frame") || strings.Contains(name, "object")
+}
+
+// keepStylesheet reports whether a link element is a style sheet that may be
+// loaded. Other links, such as prefetches, are never kept.
+func (s *sanitizer) keepStylesheet(n *html.Node) bool {
+	stylesheet, href := false, ""
+	for _, a := range n.Attr {
+		switch strings.ToLower(a.Key) {
+		case "rel":
+			stylesheet = slices.Contains(strings.Fields(strings.ToLower(a.Val)), "stylesheet")
+		case "href":
+			href = a.Val
+		}
+	}
+	if !stylesheet {
+		return false
+	}
+	switch classifyResource(href) {
+	case resourceLocal:
+		return true
+	case resourceRemote:
+		if s.allowRemote {
+			return true
+		}
+		s.blocked = true
+	}
+	return false
+}
+
+// addContentPolicy puts a Content-Security-Policy into the head of doc as a
+// second line of defence: no scripts, and no remote loads unless allowed.
+func addContentPolicy(doc *html.Node, allowRemote bool) {
+	head := findElement(doc, atom.Head)
+	if head == nil {
+		return
+	}
+	sources := "'self' data:"
+	if allowRemote {
+		sources += " http: https:"
+	}
+	policy := "default-src 'none'; style-src 'unsafe-inline' " + sources + "; img-src " + sources + "; font-src " + sources
+	meta := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Meta,
+		Data:     "meta",
+		Attr: []html.Attribute{
+			{Key: "http-equiv", Val: "Content-Security-Policy"},
+			{Key: "content", Val: policy},
+		},
+	}
+	head.InsertBefore(meta, head.FirstChild)
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Kinds of resource URL, from harmless to unacceptable.
+const (
+	resourceLocal  = iota // served by us, relative, a cid: reference or inline image data
+	resourceRemote        // loaded from another host
+	resourceUnsafe        // script or anything else not to be loaded
+)
+
+// urlScheme returns the lower-case scheme of a URL, ignoring the whitespace
+// and control characters browsers skip, or "" for a relative URL. A URL
+// starting with "//" has the scheme "//".
+func urlScheme(raw string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+	if strings.HasPrefix(cleaned, "//") || strings.HasPrefix(cleaned, `\\`) || strings.HasPrefix(cleaned, `/\`) {
+		return "//"
+	}
+	i := strings.IndexAny(cleaned, ":/?#")
+	if i <= 0 || cleaned[i] != ':' {
+		return ""
+	}
+	return strings.ToLower(cleaned[:i])
+}
+
+func classifyResource(raw string) int {
+	switch urlScheme(raw) {
+	case "", "cid":
+		return resourceLocal
+	case "http", "https", "//":
+		return resourceRemote
+	case "data":
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(raw)), "data:image/") {
+			return resourceLocal
+		}
+	}
+	return resourceUnsafe
+}
+
+func isSafeLink(raw string) bool {
+	switch urlScheme(raw) {
+	case "", "//", "http", "https", "mailto", "tel", "ftp", "news":
+		return true
+	}
+	return false
+}
+
+var (
+	cssCommentRegex   = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssEscapeRegex    = regexp.MustCompile(`\\([0-9a-fA-F]{1,6})[ \t\r\n\f]?|\\([^0-9a-fA-F\r\n\f])`)
+	cssURLRegex       = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"']*?))\s*\)`)
+	cssImportRegex    = regexp.MustCompile(`(?i)@import\s+(?:url\(\s*)?(?:"([^"]*)"|'([^']*)'|([^\s;)"']+))[^;]*;?`)
+	cssDangerousRegex = regexp.MustCompile(`(?i)expression\s*\(|(?:java|vb)script\s*:|behaviou?r\s*:|-moz-binding|-o-link`)
+	cssImageSetRegex  = regexp.MustCompile(`(?i)(?:-webkit-)?image-set\(`)
+)
+
+// sanitizeCSS neutralises script in a style sheet or style attribute and,
+// unless allowRemote is set, replaces url(), image-set() and @import
+// references to other hosts. It reports whether any were replaced.
+func sanitizeCSS(css string, allowRemote bool) (string, bool) {
+	css = cssCommentRegex.ReplaceAllString(css, " ")
+	css = unescapeCSS(css)
+	css = cssDangerousRegex.ReplaceAllString(css, "blocked:")
+
+	blocked := false
+	css = cssImportRegex.ReplaceAllStringFunc(css, func(m string) string {
+		sub := cssImportRegex.FindStringSubmatch(m)
+		kind := classifyResource(sub[1] + sub[2] + sub[3])
+		if kind == resourceRemote && allowRemote {
+			return m
+		}
+		if kind == resourceRemote {
+			blocked = true
+		}
+		return ""
+	})
+	css = cssURLRegex.ReplaceAllStringFunc(css, func(m string) string {
+		sub := cssURLRegex.FindStringSubmatch(m)
+		keep, remote := cssResource(sub[1]+sub[2]+sub[3], allowRemote)
+		if keep {
+			return m
+		}
+		blocked = blocked || remote
+		return `url("` + blockedURL + `")`
+	})
+	css, imageSetBlocked := sanitizeImageSets(css, allowRemote)
+	return css, blocked || imageSetBlocked
+}
+
+// cssResource decides on a URL loaded by CSS: whether to keep it, and
+// whether it is a remote one that is blocked.
+func cssResource(raw string, allowRemote bool) (keep, blockedRemote bool) {
+	switch classifyResource(raw) {
+	case resourceLocal:
+		return true, false
+	case resourceRemote:
+		return allowRemote, !allowRemote
+	}
+	return false, false
+}
+
+// sanitizeImageSets treats the plain strings among the arguments of
+// image-set() and -webkit-image-set() as the URLs they stand for, and
+// replaces them as url() values are. Strings nested in other functions,
+// such as type("image/avif"), are left alone; url() was handled before.
+func sanitizeImageSets(css string, allowRemote bool) (string, bool) {
+	if !cssImageSetRegex.MatchString(css) {
+		return css, false
+	}
+	var b strings.Builder
+	blocked := false
+	for {
+		loc := cssImageSetRegex.FindStringIndex(css)
+		if loc == nil {
+			break
+		}
+		b.WriteString(css[:loc[1]])
+		css = css[loc[1]:]
+
+		depth := 0
+		i := 0
+	args:
+		for i < len(css) {
+			switch c := css[i]; c {
+			case '(':
+				depth++
+			case ')':
+				if depth == 0 {
+					break args
+				}
+				depth--
+			case '"', '\'':
+				// An unterminated string runs to the end
+				end := len(css)
+				if j := strings.IndexByte(css[i+1:], c); j >= 0 {
+					end = i + 1 + j
+				}
+				value := css[i+1 : end]
+				if depth == 0 {
+					keep, remote := cssResource(value, allowRemote)
+					if !keep {
+						value = blockedURL
+						blocked = blocked || remote
+					}
+				}
+				b.WriteByte(c)
+				b.WriteString(value)
+				if end < len(css) {
+					b.WriteByte(c)
+				}
+				i = end + 1
+				continue
+			}
+			b.WriteByte(css[i])
+			i++
+		}
+		css = css[min(i, len(css)):]
+	}
+	b.WriteString(css)
+	return b.String(), blocked
+}
+
+// unescapeCSS resolves escapes of the characters that make up CSS keywords
+// and URLs, so that "exp\72ession(" or "u\rl(" cannot hide from the checks.
+// Other escapes, such as quotes in strings, are kept.
+func unescapeCSS(css string) string {
+	if !strings.Contains(css, `\`) {
+		return css
+	}
+	return cssEscapeRegex.ReplaceAllStringFunc(css, func(m string) string {
+		sub := cssEscapeRegex.FindStringSubmatch(m)
+		var r rune
+		if sub[1] != "" {
+			n, err := strconv.ParseUint(sub[1], 16, 32)
+			if err != nil {
+				return m
+			}
+			r = rune(n)
+		} else {
+			r = []rune(sub[2])[0]
+		}
+		if r < 0x80 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-:/().@", r)) {
+			return string(r)
+		}
+		return m
+	})
+}
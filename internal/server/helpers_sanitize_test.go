@@ -0,0 +1,265 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		allowRemote bool
+		want        []string // substrings of the result
+		not         []string // substrings that must be gone, compared case-insensitively
+		blocked     bool
+	}{
+		{
+			name: "script",
+			body: `<p>hi<script>alert(1)</script><SCRIPT src="x.js"></SCRIPT></p>`,
+			want: []string{"<p>hi</p>"},
+			not:  []string{"<script", "alert", "x.js"},
+		},
+		{
+			name: "event handlers",
+			body: `<img src="a.png" onerror="alert(1)" ONCLICK="alert(2)"><body onload=alert(3)>`,
+			want: []string{`<img src="a.png"/>`},
+			not:  []string{"onerror", "onclick", "onload", "alert"},
+		},
+		{
+			name: "javascript link",
+			body: `<a href="javascript:alert(1)">x</a>`,
+			want: []string{"<a>x</a>"},
+			not:  []string{"javascript", "alert"},
+		},
+		{
+			name: "scheme hidden by entities and whitespace",
+			body: `<a href="jav&#x09;ascript:alert(1)">a</a><a href=" &#106;avascript:alert(2)">b</a>` +
+				`<a href="JaVaScRiPt&colon;alert(3)">c</a><a href="java&#10;script:alert(4)">d</a>`,
+			not: []string{"script:", "alert"},
+		},
+		{
+			name: "vbscript and data links",
+			body: `<a href="vbscript:msgbox(1)">a</a><a href="data:text/html,<script>alert(1)</script>">b</a>`,
+			not:  []string{"vbscript", "data:text", "alert"},
+		},
+		{
+			name: "safe links",
+			body: `<a href="https://example.com/">a</a><a href="mailto:a@example.com">b</a><a href="#top">c</a>`,
+			want: []string{`href="https://example.com/"`, `href="mailto:a@example.com"`, `href="#top"`},
+		},
+		{
+			name:    "remote image",
+			body:    `<img src="https://tracker.example/p.gif" width="1">`,
+			want:    []string{`src="` + blockedImage + `"`, `width="1"`},
+			not:     []string{"tracker.example"},
+			blocked: true,
+		},
+		{
+			name:    "protocol-relative image",
+			body:    `<img src="//tracker.example/p.gif">`,
+			not:     []string{"tracker.example"},
+			blocked: true,
+		},
+		{
+			name:        "remote image allowed",
+			body:        `<img src="https://tracker.example/p.gif">`,
+			allowRemote: true,
+			want:        []string{`src="https://tracker.example/p.gif"`},
+		},
+		{
+			name:    "remote srcset",
+			body:    `<img src="a.png" srcset="b.png 1x, https://tracker.example/c.png 2x">`,
+			want:    []string{`src="a.png"`},
+			not:     []string{"srcset", "tracker.example"},
+			blocked: true,
+		},
+		{
+			name:    "remote background attribute",
+			body:    `<table background="https://tracker.example/bg.png"><tr><td>x</td></tr></table>`,
+			not:     []string{"tracker.example"},
+			blocked: true,
+		},
+		{
+			name:    "CSS url()",
+			body:    `<div style="background: url( 'https://tracker.example/bg.png' )">x</div>`,
+			want:    []string{`url(&#34;` + blockedURL + `&#34;)`},
+			not:     []string{"tracker.example"},
+			blocked: true,
+		},
+		{
+			name:    "@import",
+			body:    `<style>@import url("https://tracker.example/a.css"); @import 'https://tracker.example/b.css'; p{color:red}</style>`,
+			want:    []string{"p{color:red}"},
+			not:     []string{"tracker.example", "@import"},
+			blocked: true,
+		},
+		{
+			name:    "image-set() with strings",
+			body:    `<div style="background-image: image-set(&quot;https://tracker.example/a.png&quot; 1x, 'b.png' 2x)">x</div>`,
+			want:    []string{"&#39;b.png&#39; 2x"},
+			not:     []string{"tracker.example"},
+			blocked: true,
+		},
+		{
+			name:    "-webkit-image-set() in a style sheet",
+			body:    `<style>p{background:-webkit-image-set('https://tracker.example/a.png' 1x, "javascript:x" 2x)}</style>`,
+			not:     []string{"tracker.example", "javascript"},
+			blocked: true,
+		},
+		{
+			name:    "CSS escapes",
+			body:    `<div style="background:\75 rl(https://tracker.example/a.png); width:e\78pression(alert(1))">x</div>`,
+			want:    []string{"width:blocked:"},
+			not:     []string{"tracker.example", "expression"},
+			blocked: true,
+		},
+		{
+			name:    "escaped scheme in CSS",
+			body:    `<style>p{background:url(\68ttps://tracker.example/a.png)}</style>`,
+			not:     []string{"tracker.example"},
+			blocked: true,
+		},
+		{
+			name: "style sheet cannot close its element",
+			body: `<style>p{content:"</p><img src=x onerror=alert(1)>"}</style>`,
+			want: []string{`<\/p>`},
+			not:  []string{"</p>"},
+		},
+		{
+			name: "markup that changes meaning when reparsed",
+			body: `<noscript><p title="</noscript><img src=x onerror=alert(1)>"></noscript>` +
+				`<svg><style><img src=x onerror=alert(2)></style></svg>`,
+			not: []string{"onerror", "alert", "<svg"},
+		},
+		{
+			name:    "remote style sheet",
+			body:    `<link rel="stylesheet" href="https://tracker.example/a.css"><link rel="prefetch" href="b.css">`,
+			not:     []string{"<link"},
+			blocked: true,
+		},
+		{
+			name: "cid: and data: images",
+			body: `<img src="cid:part1@example.com"><img src="data:image/png;base64,iVBORw0KGgo="><div style="background:url(cid:bg@example.com)">x</div>`,
+			want: []string{`src="cid:part1@example.com"`, `src="data:image/png;base64,iVBORw0KGgo="`, "url(cid:bg@example.com)"},
+		},
+		{
+			name: "data: other than images",
+			body: `<img src="data:text/html;base64,PHNjcmlwdD4="><div style="background:url(data:text/html,x)">x</div>`,
+			not:  []string{"data:text"},
+		},
+		{
+			name: "forms and objects",
+			body: `<form action="https://example.com/"><input name="q"><button>Go</button>text</form><iframe src="a.html"></iframe><object data="a.swf"></object>`,
+			want: []string{"text"},
+			not:  []string{"<form", "action", "<input", "<button", "<iframe", "<object"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, blocked := sanitizeHTML(tt.body, tt.allowRemote)
+			for _, s := range tt.want {
+				if !strings.Contains(got, s) {
+					t.Errorf("%q not in %s", s, got)
+				}
+			}
+			for _, s := range tt.not {
+				if strings.Contains(strings.ToLower(got), strings.ToLower(s)) {
+					t.Errorf("%q left in %s", s, got)
+				}
+			}
+			if blocked != tt.blocked {
+				t.Errorf("RemoteBlocked = %v, want %v", blocked, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestSanitizeHTMLContentPolicy(t *testing.T) {
+	got, _ := sanitizeHTML("<p>x</p>", false)
+	if !strings.Contains(got, `<meta http-equiv="Content-Security-Policy" content="default-src &#39;none&#39;;`) || strings.Contains(got, "https:") {
+		t.Errorf("no strict policy in %s", got)
+	}
+	got, _ = sanitizeHTML("<p>x</p>", true)
+	if !strings.Contains(got, "img-src &#39;self&#39; data: http: https:") {
+		t.Errorf("remote images not allowed by the policy in %s", got)
+	}
+}
+
+func TestSanitizeCSS(t *testing.T) {
+	tests := []struct {
+		css         string
+		allowRemote bool
+		want        string
+		blocked     bool
+	}{
+		{"color: red", false, "color: red", false},
+		{"background: url(a.png)", false, "background: url(a.png)", false},
+		{"background: url(https://t.example/a.png)", false, `background: url("about:invalid#blocked")`, true},
+		{"background: url(https://t.example/a.png)", true, "background: url(https://t.example/a.png)", false},
+		{"background: url(javascript:alert(1))", true, `background: url("about:invalid#blocked"))`, false},
+		{"background: URL( //t.example/a.png )", false, `background: url("about:invalid#blocked")`, true},
+		{`@import "https://t.example/a.css"; p{}`, false, " p{}", true},
+		{`@import "https://t.example/a.css"; p{}`, true, `@import "https://t.example/a.css"; p{}`, false},
+		{`@import "local.css"; p{}`, false, " p{}", false},
+		{`image-set("https://t.example/a.png" 1x, "b.png" type("image/png") 2x)`, false, `image-set("about:invalid#blocked" 1x, "b.png" type("image/png") 2x)`, true},
+		{`-WEBKIT-IMAGE-SET('https://t.example/a.png' 1x)`, true, `-WEBKIT-IMAGE-SET('https://t.example/a.png' 1x)`, false},
+		{`image-set(url(https://t.example/a.png) 1x)`, false, `image-set(url("about:invalid#blocked") 1x)`, true},
+		{`image-set("https://t.example/a.png`, false, `image-set("about:invalid#blocked`, true},
+		{`\75\72\6c(https://t.example/a.png)`, false, `url("about:invalid#blocked")`, true},
+		{"width: expression(alert(1))", false, "width: blocked:alert(1))", false},
+		{"color: red /* url(https://t.example/a.png) */", false, "color: red  ", false},
+		{"-moz-binding: url(a.xml#x)", false, "blocked:: url(a.xml#x)", false},
+	}
+	for _, tt := range tests {
+		got, blocked := sanitizeCSS(tt.css, tt.allowRemote)
+		if got != tt.want || blocked != tt.blocked {
+			t.Errorf("sanitizeCSS(%q, %v) = %q, %v, want %q, %v", tt.css, tt.allowRemote, got, blocked, tt.want, tt.blocked)
+		}
+	}
+}
+
+func TestURLScheme(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"https://example.com/", "https"},
+		{"HTTP://example.com/", "http"},
+		{"//example.com/a.png", "//"},
+		{`\\example.com\a.png`, "//"},
+		{"/\\example.com/a.png", "//"},
+		{" java\tscript:alert(1)", "javascript"},
+		{"java\x00script:alert(1)", "javascript"},
+		{"cid:part1@example.com", "cid"},
+		{"a.png", ""},
+		{"/api/a.png?x=y:z", ""},
+		{"#top", ""},
+		{"dir/a:b", ""},
+		{":x", ""},
+	}
+	for _, tt := range tests {
+		if got := urlScheme(tt.url); got != tt.want {
+			t.Errorf("urlScheme(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestUnescapeCSS(t *testing.T) {
+	tests := []struct {
+		css, want string
+	}{
+		{`color: red`, `color: red`},
+		{`\75 rl(`, `url(`},
+		{`\000075rl(`, `url(`},
+		{`e\78pression`, `expression`},
+		{`\u\r\l\(`, `url(`},
+		{`\3c /style\3e`, `\3c /style\3e`},
+		{`\"`, `\"`},
+		{`\110000`, `\110000`},
+	}
+	for _, tt := range tests {
+		if got := unescapeCSS(tt.css); got != tt.want {
+			t.Errorf("unescapeCSS(%q) = %q, want %q", tt.css, got, tt.want)
+		}
+	}
+}
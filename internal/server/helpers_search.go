@@ -0,0 +1,202 @@
+package server
+
+import (
+	"html"
+	"log"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/emurenMRz/mboxview/internal/search"
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
+)
+
+var (
+	searchMu    sync.Mutex
+	searchCache = map[string]*search.Index{}
+	searchLocks = map[string]*sync.Mutex{}
+)
+
+const snippetLength = 160 // runes
+
+// withSearchIndex brings the search index of mboxPath up to date and calls fn
+// with it and the message index it reflects. Messages that appeared since the
+// last call are parsed and added; messages that disappeared are removed.
+func withSearchIndex(mboxPath string, fn func(ix *search.Index, idx *mailboxIndex)) error {
+	searchMu.Lock()
+	mu, ok := searchLocks[mboxPath]
+	if !ok {
+		mu = &sync.Mutex{}
+		searchLocks[mboxPath] = mu
+	}
+	ix := searchCache[mboxPath]
+	searchMu.Unlock()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Hold a shared lock so the file cannot be rewritten under the offsets
+	// of the message index while new messages are read
+	opts := lockOptions
+	opts.Shared = true
+	lock, err := mboxfile.LockFile(mboxPath, opts)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	idx, err := loadIndex(mboxPath)
+	if err != nil {
+		return err
+	}
+
+	path, err := stateFilePath(mboxPath, "search")
+	if err != nil {
+		return err
+	}
+	if ix == nil {
+		ix = search.Load(path)
+	}
+
+	if ix.Size != idx.Size || ix.ModTime != idx.ModTime {
+		if err := syncSearchIndex(mboxPath, ix, idx); err != nil {
+			return err
+		}
+		if err := ix.Save(path); err != nil {
+			log.Printf("Failed to save search index for %s: %v", mboxPath, err)
+		}
+	}
+
+	searchMu.Lock()
+	searchCache[mboxPath] = ix
+	searchMu.Unlock()
+
+	fn(ix, idx)
+	return nil
+}
+
+// syncSearchIndex makes ix contain exactly the messages of idx.
+func syncSearchIndex(mboxPath string, ix *search.Index, idx *mailboxIndex) error {
+	f, err := os.Open(mboxPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	current := make(map[string]bool, len(idx.Entries))
+	for _, entry := range idx.Entries {
+		if entry.Malformed {
+			continue
+		}
+		current[entry.UID] = true
+		if ix.Has(entry.UID) {
+			continue
+		}
+		msg, err := readMessageAt(f, entry)
+		if err != nil {
+			log.Printf("Failed to index message %s in %s: %v", entry.UID, mboxPath, err)
+			continue
+		}
+		ix.Add(searchDocument(msg, entry))
+	}
+
+	for _, uid := range ix.UIDs() {
+		if !current[uid] {
+			ix.Remove(uid)
+		}
+	}
+
+	ix.Size = idx.Size
+	ix.ModTime = idx.ModTime
+	return nil
+}
+
+// searchDocument extracts the searchable text of a message.
+func searchDocument(msg *mail.Message, entry indexEntry) search.Document {
+	to := decodeAddressList(msg.Header.Get("To"))
+	if cc := decodeAddressList(msg.Header.Get("Cc")); cc != "" {
+		to += ", " + cc
+	}
+
+	content := parseMessageBody(msg)
+	return search.Document{
+		UID:           entry.UID,
+		Subject:       entry.Subject,
+		From:          entry.From,
+		To:            to,
+		Body:          contentText(content),
+		Date:          entry.Timestamp,
+		HasAttachment: len(content.Attachments) > 0,
+	}
+}
+
+// contentText returns the readable text of a message body, preferring the
+// plain text part.
+func contentText(content EmailContent) string {
+	text := content.BodyText
+	if text == "" {
+		text = htmlToText(content.BodyHTML)
+	}
+	for _, c := range content.Calendars {
+		for _, e := range c.Events {
+			text += "\n" + e.Summary + "\n" + e.Location + "\n" + e.Description
+		}
+	}
+	// Forwarded messages are part of what the sender wrote
+	for _, m := range content.Messages {
+		text += "\n" + m.Subject + "\n" + contentText(m.EmailContent)
+	}
+	return text
+}
+
+var (
+	invisibleElementRegex = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>`)
+	tagRegex              = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaceRegex            = regexp.MustCompile(`\s+`)
+)
+
+// htmlToText strips markup from an HTML body, keeping only visible text.
+func htmlToText(s string) string {
+	s = invisibleElementRegex.ReplaceAllString(s, " ")
+	s = tagRegex.ReplaceAllString(s, " ")
+	return strings.TrimSpace(spaceRegex.ReplaceAllString(html.UnescapeString(s), " "))
+}
+
+// makeSnippet returns an excerpt of text around the first match of words.
+func makeSnippet(text string, words []string) string {
+	text = strings.TrimSpace(spaceRegex.ReplaceAllString(text, " "))
+
+	pos := 0
+	if len(words) > 0 {
+		quoted := make([]string, len(words))
+		for i, w := range words {
+			quoted[i] = regexp.QuoteMeta(w)
+		}
+		re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+		if loc := re.FindStringIndex(text); loc != nil {
+			pos = utf8.RuneCountInString(text[:loc[0]])
+		}
+	}
+
+	runes := []rune(text)
+	start := pos - snippetLength/3
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetLength
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	snippet := string(runes[start:end])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emurenMRz/mboxview/internal/signature"
+)
+
+// signedProtocol returns the protocol of a multipart/signed or
+// multipart/encrypted part, smime or pgp, or "" if it is neither. Some
+// mailers leave out the protocol parameter, so the second part is asked too.
+func signedProtocol(p *mimePart) string {
+	protocol := strings.ToLower(p.Params["protocol"])
+	if protocol == "" && len(p.Children) > 1 {
+		protocol = p.Children[1].MediaType
+	}
+	switch protocol {
+	case "application/pkcs7-signature", "application/x-pkcs7-signature":
+		return "smime"
+	case "application/pgp-signature", "application/pgp-encrypted":
+		return "pgp"
+	}
+	if protocol == "" && p.MediaType == "multipart/encrypted" && len(p.Children) > 0 {
+		if p.Children[0].MediaType == "application/pgp-encrypted" {
+			return "pgp"
+		}
+	}
+	return ""
+}
+
+// isSMIMEObject reports whether a part is an S/MIME object
+// (application/pkcs7-mime): signed data or an encrypted envelope.
+func isSMIMEObject(p *mimePart) bool {
+	return p.MediaType == "application/pkcs7-mime" || p.MediaType == "application/x-pkcs7-mime"
+}
+
+// smimeSignedContent returns the entity signed by an S/MIME signed-data
+// part, which carries it inside the signature, or nil for anything else.
+func smimeSignedContent(p *mimePart) []byte {
+	if !isSMIMEObject(p) {
+		return nil
+	}
+	switch strings.ToLower(p.Params["smime-type"]) {
+	case "", "signed-data":
+	default:
+		return nil
+	}
+	content, ok := signature.SMIMEContent(p.decoded())
+	if !ok {
+		return nil
+	}
+	// The content was signed in canonical form; store it as mail is stored
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+}
+
+// verifySigned checks the signature of a multipart/signed part. The signed
+// entity is the first child exactly as sent; the signature is the second.
+func verifySigned(p *mimePart, protocol string, from string) SignatureInfo {
+	content := signature.Canonicalize(p.Children[0].Raw)
+	sig := p.Children[1].decoded()
+	var r signature.Result
+	if protocol == "smime" {
+		r = verifier.VerifySMIME(content, sig)
+	} else {
+		r = verifier.VerifyPGP(content, sig)
+	}
+	return signatureInfo(p.ID, protocol, r, from)
+}
+
+func signatureInfo(partID, protocol string, r signature.Result, from string) SignatureInfo {
+	info := SignatureInfo{
+		PartID:      partID,
+		Protocol:    protocol,
+		Status:      string(r.Status),
+		Signer:      r.Signer,
+		Emails:      r.Emails,
+		Fingerprint: r.Fingerprint,
+		Issuer:      r.Issuer,
+		Detail:      r.Detail,
+	}
+	if !r.SignedAt.IsZero() {
+		info.SignedAt = r.SignedAt.UTC().Format(time.RFC3339)
+	}
+	if len(r.Emails) > 0 {
+		if addr, err := mail.ParseAddress(decodeHeader(from)); err == nil {
+			info.FromMismatch = !slices.ContainsFunc(r.Emails, func(e string) bool {
+				return strings.EqualFold(e, addr.Address)
+			})
+		}
+	}
+	return info
+}
+
+var pgpMessageMarker = []byte("-----BEGIN PGP MESSAGE-----")
+
+// isInlinePGPEncrypted reports whether a text part holds an armored OpenPGP
+// message, as mailers without PGP/MIME send encrypted mail.
+func isInlinePGPEncrypted(data []byte) bool {
+	i := bytes.Index(data, pgpMessageMarker)
+	return i == 0 || i > 0 && data[i-1] == '\n'
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// unflow joins the soft line breaks of RFC 3676 format=flowed text. A line
+// ending in a space continues on the next line of the same quote depth;
+// with delsp=yes that space was added by the sender and is removed as well.
+// Quoted lines are written back with one ">" per level and a space.
+func unflow(text string, delSp bool) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	trailingNewline := strings.HasSuffix(text, "\n")
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+
+	var b strings.Builder
+	var para strings.Builder
+	paraDepth := -1 // quote depth of the open paragraph; -1 when none is open
+	flush := func() {
+		if paraDepth < 0 {
+			return
+		}
+		if paraDepth > 0 {
+			b.WriteString(strings.Repeat(">", paraDepth))
+			if para.Len() > 0 {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(para.String())
+		b.WriteByte('\n')
+		para.Reset()
+		paraDepth = -1
+	}
+
+	for _, line := range lines {
+		depth := 0
+		for depth < len(line) && line[depth] == '>' {
+			depth++
+		}
+		content := strings.TrimPrefix(line[depth:], " ") // undo space-stuffing
+
+		// A change of quote depth ends the paragraph even after a soft break
+		if paraDepth >= 0 && depth != paraDepth {
+			flush()
+		}
+		flowed := strings.HasSuffix(content, " ") && content != "-- "
+		if flowed && delSp {
+			content = content[:len(content)-1]
+		}
+		para.WriteString(content)
+		paraDepth = depth
+		if !flowed {
+			flush()
+		}
+	}
+	flush()
+
+	out := b.String()
+	if !trailingNewline {
+		out = strings.TrimSuffix(out, "\n")
+	}
+	return out
+}
+
+// textURLRegex finds the URLs to link in plain text.
+var textURLRegex = regexp.MustCompile(`(?i)\b(?:https?://|mailto:)[^\s<>"'` + "`" + `]+`)
+
+// textToHTML renders plain text as an HTML fragment: special characters are
+// escaped, URLs become links, and quoted lines (">" prefixes) are nested in
+// blockquote elements, one per level. Line breaks and spacing are kept by
+// the white-space style of the container.
+func textToHTML(text string) string {
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var b strings.Builder
+	b.WriteString(`<div class="plain-text" style="white-space: pre-wrap; overflow-wrap: anywhere">`)
+	depth := 0
+	atLineStart := true // nothing written yet at the current depth
+	for _, line := range strings.Split(text, "\n") {
+		lineDepth, content := quoteDepth(line)
+		for depth < lineDepth {
+			b.WriteString(`<blockquote type="cite">`)
+			depth++
+			atLineStart = true
+		}
+		for depth > lineDepth {
+			b.WriteString(`</blockquote>`)
+			depth--
+			atLineStart = true
+		}
+		if !atLineStart {
+			b.WriteByte('\n')
+		}
+		b.WriteString(linkify(content))
+		atLineStart = false
+	}
+	for ; depth > 0; depth-- {
+		b.WriteString(`</blockquote>`)
+	}
+	b.WriteString(`</div>`)
+	return b.String()
+}
+
+// quoteDepth counts the ">" quote markers at the start of a line, allowing a
+// space between them as many mailers write, and returns the rest of the line.
+func quoteDepth(line string) (int, string) {
+	depth := 0
+	rest := line
+	for strings.HasPrefix(rest, ">") {
+		depth++
+		rest = rest[1:]
+		// "> > text" nests like ">> text"
+		if strings.HasPrefix(rest, " >") {
+			rest = rest[1:]
+		}
+	}
+	if depth == 0 {
+		// An indented line keeps its indentation
+		return 0, line
+	}
+	return depth, strings.TrimPrefix(rest, " ")
+}
+
+// linkify escapes a line of text and turns the URLs in it into links.
+// Punctuation ending a sentence is left out of a URL, and so is a closing
+// parenthesis the URL did not open.
+func linkify(line string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range textURLRegex.FindAllStringIndex(line, -1) {
+		start, end := loc[0], loc[1]
+		for end > start {
+			c := line[end-1]
+			if strings.IndexByte(".,;:!?", c) != -1 ||
+				c == ')' && strings.Count(line[start:end], "(") < strings.Count(line[start:end], ")") {
+				end--
+				continue
+			}
+			break
+		}
+		url := line[start:end]
+		if strings.HasSuffix(strings.ToLower(url), "://") || strings.EqualFold(url, "mailto:") {
+			continue
+		}
+		b.WriteString(html.EscapeString(line[last:start]))
+		b.WriteString(`<a href="` + html.EscapeString(url) + `" target="_blank" rel="noopener noreferrer">`)
+		b.WriteString(html.EscapeString(url))
+		b.WriteString(`</a>`)
+		last = end
+	}
+	b.WriteString(html.EscapeString(line[last:]))
+	return b.String()
+}
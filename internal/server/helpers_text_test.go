@@ -0,0 +1,121 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnflow(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		delSp bool
+		want  string
+	}{
+		{"soft break", "Hello \nworld\n", false, "Hello world\n"},
+		{"hard break", "Hello\nworld\n", false, "Hello\nworld\n"},
+		{"paragraph", "one \ntwo \nthree\n\nfour\n", false, "one two three\n\nfour\n"},
+		{"crlf", "Hello \r\nworld\r\n", false, "Hello world\n"},
+		{"no trailing newline", "Hello \nworld", false, "Hello world"},
+		{"delsp", "Hel \nlo wor \nld\n", true, "Hello world\n"},
+		{"delsp off keeps the space", "Hel \nlo\n", false, "Hel lo\n"},
+		{"space-stuffed", " From here\n >not a quote\n", false, "From here\n>not a quote\n"},
+		{"signature separator", "Bye\n-- \nsig\n", false, "Bye\n-- \nsig\n"},
+
+		{"quoted", "> quoted \n> text\nreply\n", false, "> quoted text\nreply\n"},
+		{"quoted delsp", "> quo \n> ted\n", true, "> quoted\n"},
+		{"nested", ">> deep \n>> er\n> shallow\n", false, ">> deep er\n> shallow\n"},
+		{"unstuffed quote", ">quoted \n>text\n", false, "> quoted text\n"},
+		{"depth change ends the paragraph", "> a \n>> b\n", false, "> a \n>> b\n"},
+		{"quoted blank line", "> a\n>\n> b\n", false, "> a\n>\n> b\n"},
+		{"soft break before unquoted", "> a \nb\n", false, "> a \nb\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unflow(tt.text, tt.delSp); got != tt.want {
+				t.Errorf("unflow(%q, %v) = %q, want %q", tt.text, tt.delSp, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextToHTML(t *testing.T) {
+	const (
+		open  = `<div class="plain-text" style="white-space: pre-wrap; overflow-wrap: anywhere">`
+		close = `</div>`
+		quote = `<blockquote type="cite">`
+	)
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "Hello\nworld\n", "Hello\nworld"},
+		{"indented", "code:\n  x := 1\n", "code:\n  x := 1"},
+		{"escaped", `<b>"bold" & 'more'</b>`, `&lt;b&gt;&#34;bold&#34; &amp; &#39;more&#39;&lt;/b&gt;`},
+		{"quoted", "hi\n> quoted\n> text\nreply", "hi" + quote + "quoted\ntext</blockquote>reply"},
+		{"nested", "> a\n>> b\n> > c\n> d", quote + "a" + quote + "b\nc</blockquote>d</blockquote>"},
+		{"quote at the end", "> a\n>> b", quote + "a" + quote + "b</blockquote></blockquote>"},
+		{"escaped quote", "> <script>", quote + "&lt;script&gt;</blockquote>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := textToHTML(tt.text); got != open+tt.want+close {
+				t.Errorf("textToHTML(%q) = %q, want %q", tt.text, got, open+tt.want+close)
+			}
+		})
+	}
+}
+
+func TestLinkify(t *testing.T) {
+	link := func(url string) string {
+		return `<a href="` + url + `" target="_blank" rel="noopener noreferrer">` + url + `</a>`
+	}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"see https://example.com/a?b=1&c=2 now", "see " + link("https://example.com/a?b=1&amp;c=2") + " now"},
+		{"at https://example.com.", "at " + link("https://example.com") + "."},
+		{"(https://example.com/x)", "(" + link("https://example.com/x") + ")"},
+		{"https://en.wikipedia.org/wiki/Foo_(bar)", link("https://en.wikipedia.org/wiki/Foo_(bar)")},
+		{"write to mailto:a@example.com!", "write to " + link("mailto:a@example.com") + "!"},
+		{"<https://example.com/>", "&lt;" + link("https://example.com/") + "&gt;"},
+		{`"https://example.com/"onmouseover="x"`, "&#34;" + link("https://example.com/") + `&#34;onmouseover=&#34;x&#34;`},
+		{"HTTP://EXAMPLE.COM", link("HTTP://EXAMPLE.COM")},
+		{"just http:// and mailto:", "just http:// and mailto:"},
+		{"javascript:alert(1)", "javascript:alert(1)"},
+		{"a < b", "a &lt; b"},
+	}
+	for _, tt := range tests {
+		if got := linkify(tt.line); got != tt.want {
+			t.Errorf("linkify(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteDepth(t *testing.T) {
+	tests := []struct {
+		line    string
+		depth   int
+		content string
+	}{
+		{"text", 0, "text"},
+		{"> text", 1, "text"},
+		{">text", 1, "text"},
+		{">> text", 2, "text"},
+		{"> > text", 2, "text"},
+		{">  text", 1, " text"},
+		{" > text", 0, " > text"},
+		{"  code", 0, "  code"},
+	}
+	for _, tt := range tests {
+		depth, content := quoteDepth(tt.line)
+		if depth != tt.depth || content != tt.content {
+			t.Errorf("quoteDepth(%q) = %d, %q, want %d, %q", tt.line, depth, content, tt.depth, tt.content)
+		}
+	}
+	if strings.Contains(textToHTML(" > text"), "blockquote") {
+		t.Error("an indented > is not a quote")
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/emurenMRz/mboxview/internal/tnef"
+)
+
+// isTNEF reports whether p is a winmail.dat attachment, which Outlook sends
+// in place of MIME bodies and attachments.
+func isTNEF(p *mimePart) bool {
+	switch p.MediaType {
+	case "application/ms-tnef", "application/vnd.ms-tnef":
+		return true
+	case "application/octet-stream":
+		return strings.EqualFold(p.filename(), "winmail.dat")
+	}
+	return false
+}
+
+// tnefParts decodes a TNEF part into parts of its own: the message body as
+// plain text and HTML, which have no file name, then the attached files.
+// Rich text that is not merely wrapped HTML or text is offered as body.rtf.
+func tnefParts(p *mimePart) []*mimePart {
+	data := p.decoded()
+	if !tnef.IsTNEF(data) {
+		return nil
+	}
+	msg, err := tnef.Decode(data)
+	if err != nil {
+		return nil
+	}
+
+	text, html := msg.Body, msg.HTML
+	var rtf []byte
+	if msg.RTF != nil {
+		if h, ok := tnef.RTFToHTML(msg.RTF); ok {
+			if html == "" {
+				html = h
+			}
+		} else {
+			if text == "" && html == "" {
+				text = tnef.RTFToText(msg.RTF)
+			}
+			if !tnef.IsEncapsulated(msg.RTF) {
+				rtf = msg.RTF
+			}
+		}
+	}
+
+	var children []*mimePart
+	add := func(contentType, disposition, filename, contentID string, body []byte) {
+		children = append(children, newDecodedPart(childID(p.ID, len(children)+1), contentType, disposition, filename, contentID, body))
+	}
+	if strings.TrimSpace(text) != "" {
+		add("text/plain; charset=utf-8", "inline", "", "", []byte(text))
+	}
+	if strings.TrimSpace(html) != "" {
+		add("text/html; charset=utf-8", "inline", "", "", []byte(html))
+	}
+	if rtf != nil {
+		add("application/rtf", "attachment", "body.rtf", "", rtf)
+	}
+	for i, a := range msg.Attachments {
+		name := a.Name
+		if name == "" {
+			name = "attachment-" + strconv.Itoa(i+1)
+		}
+		disposition := "attachment"
+		if a.Hidden && a.ContentID != "" {
+			disposition = "inline"
+		}
+		add(fileContentType(name, a.MIMEType), disposition, name, a.ContentID, a.Data)
+	}
+	return children
+}
+
+// isTNEFBody reports whether c is a body decoded from a TNEF part.
+func isTNEFBody(c *mimePart) bool {
+	return (c.MediaType == "text/plain" || c.MediaType == "text/html") && c.filename() == ""
+}
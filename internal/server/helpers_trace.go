@@ -0,0 +1,64 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
+)
+
+// buildTrace reads the delivery path from the Received fields of a header.
+// Each relay adds its field on top, so the hops are listed in the reverse
+// of header order.
+func buildTrace(headers mboxheader.ParsedMailHeaders) MessageTrace {
+	var received []string
+	for _, field := range headers.Fields() {
+		if strings.EqualFold(field.Name(), "Received") {
+			received = append(received, field.Value())
+		}
+	}
+	slices.Reverse(received)
+
+	trace := MessageTrace{Hops: []TraceHop{}}
+	var sent, arrived time.Time
+	if value, ok := headers.GetFieldValue("date"); ok {
+		if sent = parseDate(value); !sent.IsZero() {
+			trace.Date = sent.Format(time.RFC3339)
+		}
+	}
+	// Delays are measured from the last time known, starting with Date
+	last := sent
+	for _, value := range received {
+		rcv := mboxheader.ParseReceived(value)
+		hop := TraceHop{
+			From:       rcv.From,
+			FromRDNS:   rcv.FromRDNS,
+			FromIP:     rcv.FromIP,
+			By:         rcv.By,
+			ByIP:       rcv.ByIP,
+			Protocol:   rcv.With,
+			TLS:        rcv.TLS,
+			TLSVersion: rcv.TLSVersion,
+			Cipher:     rcv.Cipher,
+			ID:         rcv.ID,
+			For:        rcv.For,
+			Raw:        value,
+		}
+		if !rcv.Time.IsZero() {
+			hop.Timestamp = rcv.Time.Format(time.RFC3339)
+			if !last.IsZero() {
+				delay := rcv.Time.Sub(last).Seconds()
+				hop.Delay = &delay
+				hop.OutOfOrder = delay < 0
+			}
+			last, arrived = rcv.Time, rcv.Time
+		}
+		trace.Hops = append(trace.Hops, hop)
+	}
+	if !sent.IsZero() && !arrived.IsZero() {
+		total := arrived.Sub(sent).Seconds()
+		trace.TotalDelay = &total
+	}
+	return trace
+}
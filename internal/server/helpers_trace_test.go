@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
+)
+
+func TestBuildTrace(t *testing.T) {
+	headers := "Received: by mx.example.com; Mon, 1 Apr 2024 10:00:12 +0000\n" +
+		"Received: by relay2.example.net id 42\n" +
+		"Received: from relay1.example.net by relay2.example.net;\n" +
+		"\tMon, 1 Apr 2024 18:59:58 +0900\n" +
+		"Received: from client by relay1.example.net; Mon, 1 Apr 2024 10:00:05 +0000\n" +
+		"Date: Mon, 1 Apr 2024 10:00:00 +0000\n" +
+		"Subject: hi\n"
+	trace := buildTrace(mboxheader.NewParsedMailHeaders(headers))
+
+	if trace.Date != "2024-04-01T10:00:00Z" {
+		t.Errorf("Date = %q", trace.Date)
+	}
+	// Delays run from the hop before with a date; a clock behind shows as
+	// a negative delay
+	want := []struct {
+		by         string
+		delay      float64
+		hasDelay   bool
+		outOfOrder bool
+	}{
+		{"relay1.example.net", 5, true, false},
+		{"relay2.example.net", -7, true, true},
+		{"relay2.example.net", 0, false, false},
+		{"mx.example.com", 14, true, false},
+	}
+	if len(trace.Hops) != len(want) {
+		t.Fatalf("got %d hops, want %d", len(trace.Hops), len(want))
+	}
+	for i, hop := range trace.Hops {
+		w := want[i]
+		if hop.By != w.by || (hop.Delay != nil) != w.hasDelay || hop.Delay != nil && *hop.Delay != w.delay || hop.OutOfOrder != w.outOfOrder {
+			t.Errorf("hop %d = %+v (delay %v), want %+v", i, hop, hop.Delay, w)
+		}
+	}
+	if trace.TotalDelay == nil || *trace.TotalDelay != 12 {
+		t.Errorf("TotalDelay = %v, want 12", trace.TotalDelay)
+	}
+}
+
+func TestBuildTraceWithoutDate(t *testing.T) {
+	headers := "Received: by b; Mon, 1 Apr 2024 10:00:09 +0000\n" +
+		"Received: by a; Mon, 1 Apr 2024 10:00:05 +0000\n"
+	trace := buildTrace(mboxheader.NewParsedMailHeaders(headers))
+	if len(trace.Hops) != 2 || trace.Hops[0].Delay != nil || trace.Hops[1].Delay == nil || *trace.Hops[1].Delay != 4 {
+		t.Fatalf("hops = %+v", trace.Hops)
+	}
+	if trace.Date != "" || trace.TotalDelay != nil {
+		t.Errorf("Date %q, TotalDelay %v, want none", trace.Date, trace.TotalDelay)
+	}
+}
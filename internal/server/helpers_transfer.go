@@ -0,0 +1,182 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
+)
+
+var (
+	errSameMailbox    = errors.New("source and target are the same mailbox")
+	errMailboxChanged = errors.New("mailbox changed since it was indexed")
+)
+
+// transferMessages appends the messages described by entries from srcPath to
+// dstPath. When move is set they are then flagged deleted in the source, or
+// removed from it when expunge is set as well.
+//
+// Both mailboxes stay locked throughout and the target is truncated back to
+// its old size if anything fails, so a message is never lost or left
+// half-moved. Entries no longer starting a message at their indexed offset
+// are skipped, and errMailboxChanged is returned if one now has a different
+// length. It returns the number of messages transferred.
+func transferMessages(srcPath, dstPath string, entries []indexEntry, move, expunge bool) (int, error) {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		return 0, err
+	}
+	if os.SameFile(srcInfo, dstInfo) {
+		return 0, errSameMailbox
+	}
+
+	// Lock in a fixed order so that transfers in opposite directions cannot
+	// deadlock
+	first, second := srcPath, dstPath
+	if second < first {
+		first, second = second, first
+	}
+	firstLock, err := mboxfile.LockFile(first, lockOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer firstLock.Unlock()
+	secondLock, err := mboxfile.LockFile(second, lockOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer secondLock.Unlock()
+
+	dst, err := os.OpenFile(dstPath, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	fi, err := dst.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := fi.Size()
+
+	positions, err := appendMessages(srcPath, dst, size, entries)
+	if err == nil && len(positions) == 0 {
+		// Only padding was written
+		return 0, dst.Truncate(size)
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if err != nil {
+		dst.Truncate(size)
+		return 0, err
+	}
+
+	if move && len(positions) > 0 {
+		edit := func(i int, headers string) (string, bool) {
+			if !positions[i] {
+				return headers, false
+			}
+			return mboxheader.UpdateFlags(headers, []string{mboxheader.FlagDeleted}, nil)
+		}
+		var drop func(i int, headers string) bool
+		if expunge {
+			drop = func(i int, headers string) bool {
+				return positions[i]
+			}
+		}
+		if _, err := rewriteLocked(srcPath, nil, edit, drop); err != nil {
+			dst.Truncate(size)
+			return 0, err
+		}
+	}
+	return len(positions), nil
+}
+
+// appendMessages copies the messages described by entries from srcPath to
+// the end of dst, which is size bytes long, and returns their positions in
+// the source in the numbering used by rewriteLocked.
+func appendMessages(srcPath string, dst *os.File, size int64, entries []indexEntry) (map[int]bool, error) {
+	wanted := make(map[int64]int64, len(entries)) // start -> end
+	for _, entry := range entries {
+		wanted[entry.Start] = entry.End
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	// The first new envelope line must follow a blank line
+	tail := make([]byte, min(size, 2))
+	if _, err := dst.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return nil, fmt.Errorf("Error reading target mailbox: %v", err)
+	}
+	w := &tailWriter{w: dst, tail: tail}
+	if _, err := io.WriteString(w, blankLinePadding(w.tail)); err != nil {
+		return nil, err
+	}
+
+	positions := map[int]bool{}
+	i := 0
+	for msg, err := range mboxfile.NewReader(src).All() {
+		if err == mboxfile.ErrInvalidFormat {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading mbox: %v", err)
+		}
+		if end, ok := wanted[msg.Start]; ok {
+			if err := mboxfile.WriteMessage(w, msg, msg.Header); err != nil {
+				return nil, fmt.Errorf("Error writing message: %v", err)
+			}
+			// End is known once the body has been read
+			if msg.End != end {
+				return nil, errMailboxChanged
+			}
+			// The last message of a file may lack its trailing blank line
+			if _, err := io.WriteString(w, blankLinePadding(w.tail)); err != nil {
+				return nil, err
+			}
+			positions[i] = true
+		}
+		i++
+	}
+	return positions, nil
+}
+
+// blankLinePadding returns what has to be written after data ending in tail
+// so that it ends with a blank line. Empty data needs nothing.
+func blankLinePadding(tail []byte) string {
+	switch {
+	case len(tail) == 0:
+		return ""
+	case tail[len(tail)-1] != '\n':
+		return "\n\n"
+	case len(tail) < 2 || tail[len(tail)-2] != '\n':
+		return "\n"
+	}
+	return ""
+}
+
+// tailWriter remembers the last two bytes written through it.
+type tailWriter struct {
+	w    io.Writer
+	tail []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.tail = append(t.tail, p[:n]...)
+	if len(t.tail) > 2 {
+		t.tail = append(t.tail[:0], t.tail[len(t.tail)-2:]...)
+	}
+	return n, err
+}
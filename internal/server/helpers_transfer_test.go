@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTransferMessages(t *testing.T) {
+	a := testMessage("<a@example.com>", "first", "one")
+	b := testMessage("<b@example.com>", "second", "two")
+	c := testMessage("<c@example.com>", "third", "three")
+	// The target lacks its trailing blank line
+	existing := strings.TrimSuffix(testMessage("<x@example.com>", "kept", "kept"), "\n")
+
+	tests := []struct {
+		name          string
+		move, expunge bool
+		wantSource    func(t *testing.T, idx *mailboxIndex)
+	}{
+		{"copy", false, false, func(t *testing.T, idx *mailboxIndex) {
+			for _, entry := range idx.Entries {
+				if entry.hasFlag(mboxheader.FlagDeleted) {
+					t.Errorf("%s flagged deleted by a copy", entry.Subject)
+				}
+			}
+		}},
+		{"move", true, false, func(t *testing.T, idx *mailboxIndex) {
+			for _, entry := range idx.Entries {
+				if deleted := entry.hasFlag(mboxheader.FlagDeleted); deleted != (entry.Subject != "second") {
+					t.Errorf("%s: deleted = %v", entry.Subject, deleted)
+				}
+			}
+		}},
+		{"move and expunge", true, true, func(t *testing.T, idx *mailboxIndex) {
+			if got := strings.Join(subjects(idx), " "); got != "second" {
+				t.Errorf("source subjects = %s, want second", got)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupMailboxes(t, map[string]string{"INBOX": a + b + c, "Archive": existing})
+			src, dst := filepath.Join(dir, "INBOX"), filepath.Join(dir, "Archive")
+			idx, err := loadIndex(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := transferMessages(src, dst, []indexEntry{idx.Entries[2], idx.Entries[0]}, tt.move, tt.expunge)
+			if n != 2 || err != nil {
+				t.Fatalf("transferMessages = %d, %v, want 2", n, err)
+			}
+			// Messages keep their order in the source
+			if got, want := readFile(t, dst), existing+"\n"+a+c; got != want {
+				t.Errorf("target = %q, want %q", got, want)
+			}
+			idx, err = loadIndex(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.wantSource(t, idx)
+		})
+	}
+}
+
+func TestTransferMessagesErrors(t *testing.T) {
+	a := testMessage("<a@example.com>", "first", "one")
+	b := testMessage("<b@example.com>", "second", "two")
+	existing := testMessage("<x@example.com>", "kept", "kept")
+	dir := setupMailboxes(t, map[string]string{"INBOX": a + b, "Archive": existing})
+	src, dst := filepath.Join(dir, "INBOX"), filepath.Join(dir, "Archive")
+	idx, err := loadIndex(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := transferMessages(src, src, idx.Entries, true, false); !errors.Is(err, errSameMailbox) {
+		t.Errorf("transfer to the same mailbox: err = %v, want %v", err, errSameMailbox)
+	}
+	if _, err := transferMessages(src, filepath.Join(dir, "missing"), idx.Entries, true, false); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("transfer to a missing mailbox: err = %v, want %v", err, os.ErrNotExist)
+	}
+
+	// The first message grew since it was indexed: what was already
+	// appended is rolled back and the source is left alone
+	grown := testMessage("<a@example.com>", "first", "one, and more")
+	os.WriteFile(src, []byte(grown+b), 0600)
+	n, err := transferMessages(src, dst, idx.Entries[:1], true, false)
+	if n != 0 || !errors.Is(err, errMailboxChanged) {
+		t.Errorf("transferMessages = %d, %v, want %v", n, err, errMailboxChanged)
+	}
+	if got := readFile(t, dst); got != existing {
+		t.Errorf("target = %q, want it unchanged", got)
+	}
+	if got := readFile(t, src); got != grown+b {
+		t.Errorf("source = %q, want it unchanged", got)
+	}
+
+	// An entry that no longer starts a message is skipped
+	n, err = transferMessages(src, dst, []indexEntry{{Start: 3, End: 10}}, true, false)
+	if n != 0 || err != nil {
+		t.Errorf("transferMessages = %d, %v, want 0", n, err)
+	}
+	if got := readFile(t, dst); got != existing {
+		t.Errorf("target = %q, want it unchanged", got)
+	}
+}
+
+func TestBlankLinePadding(t *testing.T) {
+	tests := []struct {
+		tail string
+		want string
+	}{
+		{"", ""},
+		{"x", "\n\n"},
+		{"x\n", "\n"},
+		{"\n", "\n"},
+		{"\n\n", ""},
+	}
+	for _, tt := range tests {
+		if got := blankLinePadding([]byte(tt.tail)); got != tt.want {
+			t.Errorf("blankLinePadding(%q) = %q, want %q", tt.tail, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package server
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// limitFileSize makes writes past size bytes fail with EFBIG until the test
+// ends. The Go runtime ignores the SIGXFSZ that comes with it.
+func limitFileSize(t *testing.T, size uint64) {
+	t.Helper()
+	var saved syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &saved); err != nil {
+		t.Skip(err)
+	}
+	limit := saved
+	limit.Cur = size
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { syscall.Setrlimit(syscall.RLIMIT_FSIZE, &saved) })
+}
+
+func TestTransferMessagesRollback(t *testing.T) {
+	// The limit stays well above what the test framework writes meanwhile
+	const limit = 1 << 20
+	small := testMessage("<a@example.com>", "small", "one")
+	large := testMessage("<b@example.com>", "large", strings.Repeat("large body\n", limit/8))
+	existing := testMessage("<x@example.com>", "kept", "kept")
+
+	tests := []struct {
+		name    string
+		source  string
+		entry   int // of the message to transfer
+		move    bool
+		expunge bool
+	}{
+		// Appending the large message to the target fails partway
+		{"target write fails", small + large, 1, false, false},
+		// The target takes the small message, but rewriting the large
+		// source fails
+		{"source rewrite fails", small + large, 0, true, false},
+		{"source expunge fails", small + large, 0, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupMailboxes(t, map[string]string{"INBOX": tt.source, "Archive": existing})
+			src, dst := filepath.Join(dir, "INBOX"), filepath.Join(dir, "Archive")
+			idx, err := loadIndex(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			limitFileSize(t, limit)
+			n, err := transferMessages(src, dst, idx.Entries[tt.entry:tt.entry+1], tt.move, tt.expunge)
+			if n != 0 || err == nil {
+				t.Fatalf("transferMessages = %d, %v, want an error", n, err)
+			}
+			if got := readFile(t, dst); got != existing {
+				t.Errorf("target = %q, want it rolled back", got)
+			}
+			if got := readFile(t, src); got != tt.source {
+				t.Errorf("source changed")
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
+)
+
+// trashPath returns the file deleted messages of mboxPath are moved to, or
+// "" when they are only flagged: without a trash mailbox, and for the trash
+// mailbox itself. The trash mailbox is created when missing.
+func trashPath(mboxPath string) (string, error) {
+	if trashMailbox == "" {
+		return "", nil
+	}
+	path, err := mailboxPath(trashMailbox)
+	if err != nil {
+		return "", err
+	}
+	if path == mboxPath {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// expungeMailbox removes the messages flagged deleted from mboxPath, as
+// mboxfix -remove-deleted does, and returns how many were removed.
+func expungeMailbox(mboxPath string) (int, error) {
+	lock, err := mboxfile.LockFile(mboxPath, lockOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+
+	keep := func(i int, headers string) (string, bool) {
+		return headers, false
+	}
+	deleted := func(i int, headers string) bool {
+		return mboxheader.HeaderFlags(headers).Has(mboxheader.FlagDeleted)
+	}
+	return rewriteLocked(mboxPath, nil, keep, deleted)
+}
+
+// resolveEntries maps the IDs of a batch request to index entries, dropping
+// duplicates. It returns the number of IDs that name no message.
+func resolveEntries(idx *mailboxIndex, refs []EmailRef) ([]indexEntry, int) {
+	seen := make(map[int]bool)
+	var entries []indexEntry
+	invalid := 0
+	for _, ref := range refs {
+		id, ok := idx.resolve(string(ref))
+		if !ok || idx.Entries[id].Malformed {
+			invalid++
+			continue
+		}
+		if !seen[id] {
+			seen[id] = true
+			entries = append(entries, idx.Entries[id])
+		}
+	}
+	return entries, invalid
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
+)
+
+// setTrashMailbox configures the trash mailbox until the test ends.
+func setTrashMailbox(t *testing.T, name string) {
+	saved := trashMailbox
+	trashMailbox = name
+	t.Cleanup(func() { trashMailbox = saved })
+}
+
+func TestTrashPath(t *testing.T) {
+	dir := setupMailboxes(t, map[string]string{"INBOX": ""})
+	inbox := filepath.Join(dir, "INBOX")
+
+	setTrashMailbox(t, "")
+	if path, err := trashPath(inbox); path != "" || err != nil {
+		t.Errorf("without a trash mailbox: trashPath = %q, %v, want none", path, err)
+	}
+
+	setTrashMailbox(t, "Old/Trash")
+	path, err := trashPath(inbox)
+	if want := filepath.Join(dir, "Old", "Trash"); path != want || err != nil {
+		t.Fatalf("trashPath = %q, %v, want %q", path, err, want)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 0 {
+		t.Errorf("trash mailbox not created empty: %v", err)
+	}
+	if path, err := trashPath(path); path != "" || err != nil {
+		t.Errorf("for the trash itself: trashPath = %q, %v, want none", path, err)
+	}
+
+	setTrashMailbox(t, "../Trash")
+	if _, err := trashPath(inbox); err == nil {
+		t.Error("trashPath accepted an invalid mailbox name")
+	}
+}
+
+func TestDeleteToTrash(t *testing.T) {
+	a := testMessage("<a@example.com>", "first", "one")
+	b := testMessage("<b@example.com>", "second", "two")
+	dir := setupMailboxes(t, map[string]string{"INBOX": a + b})
+	setTrashMailbox(t, "Trash")
+	inbox := filepath.Join(dir, "INBOX")
+	idx, err := loadIndex(inbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := idx.Entries[0].UID
+
+	w := httptest.NewRecorder()
+	deleteEmailHandler(w, httptest.NewRequest(http.MethodDelete, "/", nil), "INBOX", uid)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if got := readFile(t, inbox); got != b {
+		t.Errorf("INBOX = %q, want %q", got, b)
+	}
+	trash, err := loadIndex(filepath.Join(dir, "Trash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash.Entries) != 1 || trash.Entries[0].UID != uid || trash.Entries[0].hasFlag(mboxheader.FlagDeleted) {
+		t.Errorf("trash = %+v, want the message unflagged under its UID", trash.Entries)
+	}
+
+	// Deleting in the trash flags the message
+	w = httptest.NewRecorder()
+	deleteEmailHandler(w, httptest.NewRequest(http.MethodDelete, "/", nil), "Trash", uid)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	trash, _ = loadIndex(filepath.Join(dir, "Trash"))
+	if len(trash.Entries) != 1 || !trash.Entries[0].hasFlag(mboxheader.FlagDeleted) {
+		t.Errorf("trash = %+v, want the message flagged deleted", trash.Entries)
+	}
+
+	// and undeleting clears the flag again
+	w = httptest.NewRecorder()
+	undeleteEmailHandler(w, httptest.NewRequest(http.MethodPost, "/", nil), "Trash", uid)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	trash, _ = loadIndex(filepath.Join(dir, "Trash"))
+	if len(trash.Entries) != 1 || trash.Entries[0].hasFlag(mboxheader.FlagDeleted) {
+		t.Errorf("trash = %+v, want the message undeleted", trash.Entries)
+	}
+}
+
+func TestExpungeMailbox(t *testing.T) {
+	a := testMessage("<a@example.com>", "first", "one")
+	b := testMessage("<b@example.com>", "second", "two")
+	c := testMessage("<c@example.com>", "third", "three")
+	deleted := func(s string) string {
+		return strings.Replace(s, "\n\n", "\nStatus: RO\nX-Status: D\n\n", 1)
+	}
+	dir := setupMailboxes(t, map[string]string{"INBOX": deleted(a) + b + deleted(c)})
+	inbox := filepath.Join(dir, "INBOX")
+
+	n, err := expungeMailbox(inbox)
+	if n != 2 || err != nil {
+		t.Fatalf("expungeMailbox = %d, %v, want 2", n, err)
+	}
+	if got := readFile(t, inbox); got != b {
+		t.Errorf("INBOX = %q, want %q", got, b)
+	}
+
+	n, err = expungeMailbox(inbox)
+	if n != 0 || err != nil {
+		t.Errorf("expungeMailbox again = %d, %v, want 0", n, err)
+	}
+	if got := readFile(t, inbox); got != b {
+		t.Errorf("INBOX = %q, want %q", got, b)
+	}
+}
+
+func TestResolveEntries(t *testing.T) {
+	idx := &mailboxIndex{Entries: []indexEntry{
+		{UID: "a", Start: 0},
+		{UID: "b", Start: 10, Malformed: true},
+		{UID: "c", Start: 20},
+	}}
+	entries, invalid := resolveEntries(idx, []EmailRef{"c", "a", "2", "b", "x", "9"})
+	if invalid != 3 {
+		t.Errorf("invalid = %d, want 3", invalid)
+	}
+	if len(entries) != 2 || entries[0].UID != "c" || entries[1].UID != "a" {
+		t.Errorf("entries = %+v, want c and a once each", entries)
+	}
+}
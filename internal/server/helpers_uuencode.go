@@ -0,0 +1,35 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/emurenMRz/mboxview/internal/uuencode"
+)
+
+// encodedFileParts splits a plain text body with uuencoded or yEnc files in
+// it into parts of its own: the text without the files, then the files.
+// Attached text files are left whole, and so is text under an OpenPGP
+// armor, whose signature covers the files.
+func encodedFileParts(p *mimePart) []*mimePart {
+	if disp, _ := p.disposition(); disp == "attachment" {
+		return nil
+	}
+	data := p.decoded()
+	if bytes.Contains(data, []byte("-----BEGIN PGP ")) {
+		return nil
+	}
+	text, files := uuencode.Extract(data)
+	if len(files) == 0 {
+		return nil
+	}
+
+	contentType := p.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	children := []*mimePart{newDecodedPart(childID(p.ID, 1), contentType, p.Header.Get("Content-Disposition"), "", "", text)}
+	for i, f := range files {
+		children = append(children, newDecodedPart(childID(p.ID, i+2), fileContentType(f.Name, ""), "attachment", f.Name, "", f.Data))
+	}
+	return children
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/textproto"
+	"testing"
+)
+
+func TestEncodedFileParts(t *testing.T) {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", "text/plain; charset=us-ascii")
+	body := []byte("See the file.\n\nbegin 644 hi.txt\n#:&D*\n`\nend\n")
+	root := newMessagePart("", header, nil, body)
+
+	text := root.find("1")
+	if text == nil || len(text.Children) != 2 {
+		t.Fatalf("part 1 = %+v, want the text and one file", text)
+	}
+	if got := string(text.Children[0].decoded()); got != "See the file.\n\n" {
+		t.Errorf("text = %q", got)
+	}
+	file := text.Children[1]
+	if file.ID != "1.2" || file.filename() != "hi.txt" || string(file.decoded()) != "hi\n" {
+		t.Errorf("file %s %q = %q", file.ID, file.filename(), file.decoded())
+	}
+	// The text left over is not searched again
+	if !text.Children[0].derived || len(text.Children[0].Children) != 0 {
+		t.Errorf("text child = %+v", text.Children[0])
+	}
+}
+
+func TestDecodedIsKept(t *testing.T) {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Transfer-Encoding", "base64")
+	p := newMIMEPart("1", header, nil, []byte("aGVs\nbG8=\n"), "application/octet-stream")
+	first := p.decoded()
+	if string(first) != "hello" {
+		t.Fatalf("decoded = %q", first)
+	}
+	if second := p.decoded(); &second[0] != &first[0] {
+		t.Error("body decoded twice")
+	}
+}
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emurenMRz/mboxview/internal/mboxheader"
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
+)
+
+// indexVersion is bumped whenever the layout of mailboxIndex, or how its
+// fields are decoded, changes.
+// Index files written with another version are discarded and rebuilt.
+const indexVersion = 6
+
+// indexDirName is the directory under basePath that holds server-side state.
+// It starts with a dot so it is never listed as a mailbox.
+const indexDirName = ".mboxview"
+
+// indexEntry describes one message of a mailbox: where it lives in the file
+// and the summary fields shown in the email list.
+type indexEntry struct {
+	Start     int64  // offset of the "From " envelope line
+	End       int64  // offset just past the message (start of the next one)
+	UID       string // stable identifier, see messageUID
+	From      string
+	Date      string
+	Subject   string
+	Status    string
+	Flags     []string // sorted, see mboxheader.Flags
+	MessageID string
+	// References lists the Message-IDs of the ancestors, oldest first,
+	// from References and In-Reply-To
+	References []string
+	Timestamp  time.Time
+	Report     bool // a multipart/report: a bounce or a read receipt
+	Malformed  bool // headers could not be parsed
+}
+
+// mailboxIndex is the persisted message index of a single mbox file.
+// Size and ModTime record the state of the file the index was built from.
+type mailboxIndex struct {
+	Version  int
+	Size     int64
+	ModTime  int64
+	Envelope string // envelope line of the last entry, used to detect appends
+	Entries  []indexEntry
+
+	byUID map[string]int // UID -> position in Entries; built on demand
+}
+
+var (
+	indexMu    sync.Mutex
+	indexCache = map[string]*mailboxIndex{}
+	indexLocks = map[string]*sync.Mutex{}
+)
+
+// loadIndex returns an up-to-date index for the mbox file at mboxPath.
+// The index is taken from memory or disk when the file is unchanged, extended
+// when messages were appended, and rebuilt from scratch otherwise.
+// The returned index must be treated as read-only.
+func loadIndex(mboxPath string) (*mailboxIndex, error) {
+	mu := indexLock(mboxPath)
+	mu.Lock()
+	defer mu.Unlock()
+
+	fi, err := os.Stat(mboxPath)
+	if err != nil {
+		return nil, err
+	}
+
+	indexMu.Lock()
+	idx := indexCache[mboxPath]
+	indexMu.Unlock()
+	if idx == nil {
+		idx = readIndexFile(mboxPath)
+	}
+	if idx != nil && idx.Size == fi.Size() && idx.ModTime == fi.ModTime().UnixNano() {
+		indexMu.Lock()
+		indexCache[mboxPath] = idx
+		indexMu.Unlock()
+		return idx, nil
+	}
+
+	// Scan under a shared lock so a concurrent rewrite or delivery is not
+	// seen half-written
+	opts := lockOptions
+	opts.Shared = true
+	lock, err := mboxfile.LockFile(mboxPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	f, err := os.Open(mboxPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err = f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := updateIndex(f, fi, idx)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeIndexFile(mboxPath, next); err != nil {
+		log.Printf("Failed to save index for %s: %v", mboxPath, err)
+	}
+
+	indexMu.Lock()
+	indexCache[mboxPath] = next
+	indexMu.Unlock()
+	return next, nil
+}
+
+func indexLock(mboxPath string) *sync.Mutex {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	mu, ok := indexLocks[mboxPath]
+	if !ok {
+		mu = &sync.Mutex{}
+		indexLocks[mboxPath] = mu
+	}
+	return mu
+}
+
+// updateIndex brings idx in line with the current contents of f.
+// If the file only grew and the last indexed message is still where it was,
+// scanning resumes from that message; otherwise the whole file is rescanned.
+func updateIndex(f *os.File, fi os.FileInfo, idx *mailboxIndex) (*mailboxIndex, error) {
+	next := &mailboxIndex{
+		Version: indexVersion,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().UnixNano(),
+	}
+
+	var offset int64
+	if idx != nil && fi.Size() >= idx.Size {
+		if n := len(idx.Entries); n == 0 && idx.Size == 0 {
+			offset = 0
+		} else if n > 0 && envelopeAt(f, idx.Entries[n-1].Start) == idx.Envelope {
+			// The last message may have been incomplete; rescan it too.
+			offset = idx.Entries[n-1].Start
+			next.Entries = append([]indexEntry(nil), idx.Entries[:n-1]...)
+		}
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	seen := map[string]int{}
+	for _, entry := range next.Entries {
+		seen[baseUID(entry.UID)]++
+	}
+	err := scanMailbox(io.LimitReader(f, fi.Size()-offset), offset, func(start, end int64, envelope string, header []byte, bodySum []byte) {
+		entry := summarizeMessage(header)
+		entry.Start = start
+		entry.End = end
+		entry.UID = messageUID(entry.MessageID, bodySum, seen)
+		next.Entries = append(next.Entries, entry)
+		next.Envelope = envelope
+	})
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// envelopeAt returns the line starting at offset in f without its line ending.
+func envelopeAt(f *os.File, offset int64) string {
+	br := bufio.NewReader(io.NewSectionReader(f, offset, 1024))
+	line, _ := br.ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+// scanMailbox reads an mbox stream whose first byte sits at offset base in the
+// file and calls fn for every message with its byte range, envelope line and
+// raw header block, along with a SHA-256 of the body as stored.
+func scanMailbox(r io.Reader, base int64, fn func(start, end int64, envelope string, header []byte, bodySum []byte)) error {
+	body := sha256.New()
+	for msg, err := range mboxfile.NewReaderOffset(r, base).All() {
+		if err == mboxfile.ErrInvalidFormat {
+			// Data before the first envelope line is skipped.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		body.Reset()
+		if _, err := io.Copy(body, msg.RawBody()); err != nil {
+			return err
+		}
+		fn(msg.Start, msg.End, msg.Envelope, msg.Header, body.Sum(nil))
+	}
+	return nil
+}
+
+// messageUID derives a stable identifier from the Message-ID and the body
+// digest. Neither changes when flags are rewritten or when other messages are
+// added or removed, so the UID survives appends, deletions and compaction.
+// seen counts earlier occurrences so that exact duplicates get a "-N" suffix.
+// The suffix follows file order, so removing a copy renumbers the copies
+// after it; as they have the same Message-ID and body, a UID then still
+// names the same content, and the highest number is the one that goes away.
+func messageUID(messageID string, bodySum []byte, seen map[string]int) string {
+	h := sha256.New()
+	h.Write([]byte(strings.TrimSpace(messageID)))
+	h.Write([]byte{0})
+	h.Write(bodySum)
+	uid := hex.EncodeToString(h.Sum(nil)[:8])
+
+	seen[uid]++
+	if n := seen[uid]; n > 1 {
+		uid += "-" + strconv.Itoa(n)
+	}
+	return uid
+}
+
+// baseUID strips the duplicate suffix added by messageUID.
+func baseUID(uid string) string {
+	if i := strings.IndexByte(uid, '-'); i != -1 {
+		return uid[:i]
+	}
+	return uid
+}
+
+// resolve finds the entry referenced by an email ID from the API.
+// A stable UID is tried first; the ordinal position in the file is accepted
+// as a fallback.
+func (idx *mailboxIndex) resolve(ref string) (int, bool) {
+	indexMu.Lock()
+	if idx.byUID == nil {
+		idx.byUID = make(map[string]int, len(idx.Entries))
+		for i, entry := range idx.Entries {
+			idx.byUID[entry.UID] = i
+		}
+	}
+	i, ok := idx.byUID[ref]
+	indexMu.Unlock()
+	if ok {
+		return i, true
+	}
+
+	i, err := strconv.Atoi(ref)
+	if err != nil || i < 0 || i >= len(idx.Entries) {
+		return 0, false
+	}
+	return i, true
+}
+
+// summarizeMessage extracts the email list fields from a raw header block.
+func summarizeMessage(header []byte) indexEntry {
+	mr, err := mail.ReadMessage(io.MultiReader(bytes.NewReader(header), strings.NewReader("\n")))
+	if err != nil {
+		return indexEntry{Malformed: true}
+	}
+
+	dateStr := mr.Header.Get("Date")
+	mediaType, _ := parseHeaderParams(mr.Header.Get("Content-Type"))
+	return indexEntry{
+		From:      decodeAddressList(mr.Header.Get("From")),
+		Date:      dateStr,
+		Subject:   decodeHeader(mr.Header.Get("Subject")),
+		Status:    mr.Header.Get("Status"),
+		Flags:     mboxheader.ParseFlags(mr.Header.Get("Status"), mr.Header.Get("X-Status"), mr.Header.Get("X-Keywords")).List(),
+		MessageID: mr.Header.Get("Message-ID"),
+		References: mergeReferences(
+			parseMessageIDs(mr.Header.Get("References")),
+			parseMessageIDs(mr.Header.Get("In-Reply-To")),
+		),
+		Timestamp: parseDate(dateStr),
+		Report:    mediaType == "multipart/report",
+	}
+}
+
+// listed reports whether the message appears in the email list.
+func (entry indexEntry) listed() bool {
+	return !entry.Malformed && !entry.hasFlag(mboxheader.FlagDeleted)
+}
+
+func (entry indexEntry) hasFlag(flag string) bool {
+	return slices.Contains(entry.Flags, flag)
+}
+
+// email converts the entry at position i to its API representation.
+func (entry indexEntry) email(i int) Email {
+	status := entry.Status
+	if status == "" {
+		// ヘッダが無い場合は新着扱い
+		status = "N"
+	}
+	return Email{
+		ID:        i,
+		UID:       entry.UID,
+		From:      entry.From,
+		Date:      entry.Date,
+		Subject:   entry.Subject,
+		Status:    status,
+		Flags:     entry.Flags,
+		Size:      entry.End - entry.Start,
+		Timestamp: entry.Timestamp,
+	}
+}
+
+// indexFilePath returns where the index of mboxPath is persisted.
+func indexFilePath(mboxPath string) (string, error) {
+	return stateFilePath(mboxPath, "index")
+}
+
+// stateFilePath returns the file under indexDirName/kind that holds state
+// derived from mboxPath.
+func stateFilePath(mboxPath string, kind string) (string, error) {
+	rel, err := filepath.Rel(basePath, mboxPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(basePath, indexDirName, kind, rel+".idx"), nil
+}
+
+func readIndexFile(mboxPath string) *mailboxIndex {
+	path, err := indexFilePath(mboxPath)
+	if err != nil {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var idx mailboxIndex
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&idx); err != nil {
+		log.Printf("Discarding unreadable index %s: %v", path, err)
+		return nil
+	}
+	if idx.Version != indexVersion {
+		return nil
+	}
+	return &idx
+}
+
+func writeIndexFile(mboxPath string, idx *mailboxIndex) error {
+	path, err := indexFilePath(mboxPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Error creating index directory: %v", err)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "index-*.tmp")
+	if err != nil {
+		return fmt.Errorf("Error creating temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	bw := bufio.NewWriter(tempFile)
+	if err := gob.NewEncoder(bw).Encode(idx); err != nil {
+		return fmt.Errorf("Error encoding index: %v", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("Error writing index: %v", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("Error closing temp file: %v", err)
+	}
+	return os.Rename(tempFile.Name(), path)
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testMessage returns an mbox message with the given Message-ID, subject
+// and body. The envelope sender is the Message-ID, so that envelope lines
+// of different messages differ.
+func testMessage(messageID, subject, body string) string {
+	return "From " + strings.Trim(messageID, "<>") + " Mon Apr  1 10:00:00 2024\n" +
+		"From: sender@example.com\n" +
+		"Message-ID: " + messageID + "\n" +
+		"Subject: " + subject + "\n" +
+		"Date: Mon, 1 Apr 2024 10:00:00 +0000\n" +
+		"\n" + body + "\n\n"
+}
+
+// setupMailboxes points basePath at a new directory holding the given mbox
+// files and returns the directory.
+func setupMailboxes(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	saved := basePath
+	basePath = dir
+	t.Cleanup(func() { basePath = saved })
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// forgetIndex drops the cached index of mboxPath so that the next load reads
+// it from disk.
+func forgetIndex(mboxPath string) {
+	indexMu.Lock()
+	delete(indexCache, mboxPath)
+	indexMu.Unlock()
+}
+
+func subjects(idx *mailboxIndex) []string {
+	var s []string
+	for _, entry := range idx.Entries {
+		s = append(s, entry.Subject)
+	}
+	return s
+}
+
+func uids(idx *mailboxIndex) []string {
+	var s []string
+	for _, entry := range idx.Entries {
+		s = append(s, entry.UID)
+	}
+	return s
+}
+
+func TestLoadIndex(t *testing.T) {
+	dir := setupMailboxes(t, map[string]string{
+		"INBOX": "junk before the first message\n" +
+			testMessage("<a@example.com>", "first", "one") +
+			testMessage("<b@example.com>", "second", "two"),
+	})
+	mboxPath := filepath.Join(dir, "INBOX")
+
+	idx, err := loadIndex(mboxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(subjects(idx)); got != "[first second]" {
+		t.Fatalf("subjects = %s", got)
+	}
+	data, _ := os.ReadFile(mboxPath)
+	for i, entry := range idx.Entries {
+		if got := string(data[entry.Start : entry.Start+5]); got != "From " {
+			t.Errorf("entry %d starts with %q", i, got)
+		}
+	}
+	if last := idx.Entries[1]; last.End != int64(len(data)) {
+		t.Errorf("last entry ends at %d, want %d", last.End, len(data))
+	}
+
+	// The index is persisted and reused while the file is unchanged
+	forgetIndex(mboxPath)
+	saved := readIndexFile(mboxPath)
+	if saved == nil || fmt.Sprint(uids(saved)) != fmt.Sprint(uids(idx)) {
+		t.Fatalf("saved index = %+v", saved)
+	}
+	saved.Entries[0].Subject = "from disk"
+	forgetIndex(mboxPath)
+	writeIndexFile(mboxPath, saved)
+	again, err := loadIndex(mboxPath)
+	if err != nil || again.Entries[0].Subject != "from disk" {
+		t.Errorf("loadIndex did not reuse the saved index: %v, %v", subjects(again), err)
+	}
+
+	// An index of another version is rebuilt
+	saved.Version = indexVersion - 1
+	forgetIndex(mboxPath)
+	writeIndexFile(mboxPath, saved)
+	if readIndexFile(mboxPath) != nil {
+		t.Error("readIndexFile accepted an index of another version")
+	}
+	rebuilt, err := loadIndex(mboxPath)
+	if err != nil || rebuilt.Entries[0].Subject != "first" {
+		t.Errorf("loadIndex did not rebuild: %v, %v", subjects(rebuilt), err)
+	}
+}
+
+func TestUpdateIndex(t *testing.T) {
+	dir := setupMailboxes(t, map[string]string{
+		"INBOX": testMessage("<a@example.com>", "first", "one") +
+			testMessage("<b@example.com>", "second", "two"),
+	})
+	mboxPath := filepath.Join(dir, "INBOX")
+
+	update := func(idx *mailboxIndex) *mailboxIndex {
+		t.Helper()
+		f, err := os.Open(mboxPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		fi, _ := f.Stat()
+		next, err := updateIndex(f, fi, idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return next
+	}
+	idx := update(nil)
+
+	// Appending resumes from the last message: the entries before it are
+	// kept as they were, so a marker in the first survives
+	idx.Entries[0].Subject = "kept"
+	f, _ := os.OpenFile(mboxPath, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(testMessage("<c@example.com>", "third", "three"))
+	f.Close()
+	appended := update(idx)
+	if got := fmt.Sprint(subjects(appended)); got != "[kept second third]" {
+		t.Errorf("after append: subjects = %s", got)
+	}
+	if appended.Entries[1].UID != idx.Entries[1].UID {
+		t.Errorf("UID changed on append: %s, want %s", appended.Entries[1].UID, idx.Entries[1].UID)
+	}
+
+	// A rewrite that moves the last message rescans the whole file
+	os.WriteFile(mboxPath, []byte(testMessage("<b@example.com>", "second", "two")+
+		testMessage("<x@example.com>", "longer subject", "a longer body")+
+		testMessage("<c@example.com>", "third", "three")), 0600)
+	rewritten := update(appended)
+	if got := fmt.Sprint(subjects(rewritten)); got != "[second longer subject third]" {
+		t.Errorf("after rewrite: subjects = %s", got)
+	}
+
+	// A file that shrank is rescanned too
+	os.WriteFile(mboxPath, []byte(testMessage("<c@example.com>", "third", "three")), 0600)
+	shrunk := update(rewritten)
+	if got := fmt.Sprint(subjects(shrunk)); got != "[third]" {
+		t.Errorf("after shrinking: subjects = %s", got)
+	}
+	if shrunk.Entries[0].UID != appended.Entries[2].UID {
+		t.Errorf("UID of the remaining message changed: %s, want %s", shrunk.Entries[0].UID, appended.Entries[2].UID)
+	}
+}
+
+func TestMessageUID(t *testing.T) {
+	seen := map[string]int{}
+	a := messageUID("<a@example.com>", []byte("one"), seen)
+	b := messageUID("<a@example.com>", []byte("two"), seen)
+	c := messageUID(" <a@example.com> ", []byte("one"), seen)
+	d := messageUID("<a@example.com>", []byte("one"), seen)
+
+	if len(a) != 16 || a == b {
+		t.Errorf("UIDs %q and %q of different bodies", a, b)
+	}
+	if c != a+"-2" || d != a+"-3" {
+		t.Errorf("duplicate UIDs = %q, %q, want %q, %q", c, d, a+"-2", a+"-3")
+	}
+	if baseUID(d) != a || baseUID(a) != a {
+		t.Errorf("baseUID(%q) = %q, want %q", d, baseUID(d), a)
+	}
+	if again := messageUID("<a@example.com>", []byte("one"), map[string]int{}); again != a {
+		t.Errorf("UID is not stable: %q, want %q", again, a)
+	}
+}
+
+func TestUIDStability(t *testing.T) {
+	a := testMessage("<a@example.com>", "first", "one")
+	b := testMessage("<b@example.com>", "second", "two")
+	dup := testMessage("<d@example.com>", "dup", "same")
+	dir := setupMailboxes(t, map[string]string{"INBOX": a + dup + b + dup})
+	mboxPath := filepath.Join(dir, "INBOX")
+
+	idx, err := loadIndex(mboxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := uids(idx)
+	if before[3] != before[1]+"-2" {
+		t.Fatalf("duplicate UIDs = %q, %q", before[1], before[3])
+	}
+
+	// Rewriting flags and removing another message keep the UIDs
+	flagged := "From b@example.com Mon Apr  1 10:00:00 2024\n" +
+		"From: sender@example.com\n" +
+		"Message-ID: <b@example.com>\n" +
+		"Subject: second\n" +
+		"Date: Mon, 1 Apr 2024 10:00:00 +0000\n" +
+		"Status: RO\n" +
+		"X-Status: F\n" +
+		"\ntwo\n\n"
+	os.WriteFile(mboxPath, []byte(dup+flagged+dup), 0600)
+	idx, err = loadIndex(mboxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(uids(idx)), fmt.Sprint(before[1:]); got != want {
+		t.Errorf("UIDs after rewrite = %s, want %s", got, want)
+	}
+
+	// Duplicates are numbered in file order: removing the first copy
+	// hands its UID to the next one, which has the same content
+	os.WriteFile(mboxPath, []byte(flagged+dup), 0600)
+	idx, err = loadIndex(mboxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(uids(idx)), fmt.Sprint([]string{before[2], before[1]}); got != want {
+		t.Errorf("UIDs after removing a duplicate = %s, want %s", got, want)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := setupMailboxes(t, map[string]string{
+		"INBOX": testMessage("<a@example.com>", "first", "one") +
+			testMessage("<b@example.com>", "second", "two"),
+	})
+	idx, err := loadIndex(filepath.Join(dir, "INBOX"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref    string
+		want   int
+		wantOK bool
+	}{
+		{idx.Entries[0].UID, 0, true},
+		{idx.Entries[1].UID, 1, true},
+		{"0", 0, true},
+		{"1", 1, true},
+		{"2", 0, false},
+		{"-1", 0, false},
+		{"0123456789abcdef", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := idx.resolve(tt.ref)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("resolve(%q) = %d, %v, want %d, %v", tt.ref, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	// A UID made of digits only is still found as a UID first
+	idx = &mailboxIndex{Entries: []indexEntry{{UID: "x"}, {UID: strconv.Itoa(0)}}}
+	if got, ok := idx.resolve("0"); got != 1 || !ok {
+		t.Errorf("resolve(%q) = %d, %v, want 1, true", "0", got, ok)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"mime"
 	"net/http"
 	"path/filepath"
+
+	"github.com/emurenMRz/mboxview/internal/signature"
+	"github.com/emurenMRz/mboxview/pkg/mboxfile"
 )
 
 // RegisterHandlers registers HTTP handlers for the server. Call this before ListenAndServe.
@@ -11,6 +14,7 @@ func RegisterHandlers(mboxDir, staticDir string) {
 	basePath = mboxDir
 
 	http.HandleFunc("/api/mailboxes/", handleMailboxRoutes)
+	http.HandleFunc("/api/search", searchHandler)
 
 	// Serve static files under /static/ (API lives under /api/)
 	fs := http.FileServer(http.Dir(staticDir))
@@ -35,3 +39,20 @@ func ListenAndServe(addr string) error {
 func SetEditMode(v bool) {
 	editMode = v
 }
+
+// SetLockOptions sets how mbox files are locked while they are read or rewritten.
+func SetLockOptions(opts mboxfile.LockOptions) {
+	lockOptions = opts
+}
+
+// SetVerifier sets the trust store and keyring that signed messages are
+// verified against.
+func SetVerifier(v *signature.Verifier) {
+	verifier = v
+}
+
+// SetTrashMailbox sets the mailbox deleted messages are moved to. With an
+// empty name they are only flagged deleted.
+func SetTrashMailbox(name string) {
+	trashMailbox = name
+}
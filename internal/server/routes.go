@@ -3,23 +3,68 @@ package server
 import (
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
 func handleMailboxRoutes(w http.ResponseWriter, r *http.Request) {
 	log.Println(r.Method + " " + r.URL.Path)
 
-	// Guard POST methods for edit mode
-	if r.Method == "POST" && !editMode {
+	// Guard POST and DELETE methods for edit mode
+	if (r.Method == "POST" || r.Method == "DELETE") && !editMode {
 		http.NotFound(w, r)
 		return
 	}
 
-	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/mailboxes/"), "/")
+	// Split the escaped path so that hierarchical mailbox names, sent with
+	// the delimiter as %2F, stay in one segment
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/api/mailboxes/"), "/")
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			http.Error(w, "Invalid path", http.StatusBadRequest)
+			return
+		}
+		parts[i] = unescaped
+	}
 	segmentCount := len(parts)
 
 	if segmentCount == 1 {
-		mailboxesHandler(w, r)
+		switch {
+		case parts[0] == "":
+			mailboxesHandler(w, r)
+		case r.Method == "POST":
+			createMailboxHandler(w, r, parts[0])
+		case r.Method == "DELETE":
+			deleteMailboxHandler(w, r, parts[0])
+		default:
+			mailboxInfoHandler(w, r, parts[0])
+		}
+		return
+	}
+
+	if parts[1] == "rename" && segmentCount == 2 && r.Method == "POST" {
+		renameMailboxHandler(w, r, parts[0])
+		return
+	}
+
+	if parts[1] == "expunge" && segmentCount == 2 && r.Method == "POST" {
+		expungeHandler(w, r, parts[0])
+		return
+	}
+
+	if parts[1] == "search" && segmentCount == 2 {
+		mailboxSearchHandler(w, r, parts[0])
+		return
+	}
+
+	if parts[1] == "bounces" && segmentCount == 2 {
+		bouncesHandler(w, r, parts[0])
+		return
+	}
+
+	if parts[1] == "threads" && segmentCount == 2 {
+		threadsHandler(w, r, parts[0])
 		return
 	}
 
@@ -33,12 +78,40 @@ func handleMailboxRoutes(w http.ResponseWriter, r *http.Request) {
 				deleteEmailHandler(w, r, mboxName, parts[2])
 			} else if r.Method == "POST" && parts[2] == "delete-batch" {
 				deleteBatchEmailsHandler(w, r, mboxName)
+			} else if r.Method == "POST" && parts[2] == "undelete-batch" {
+				undeleteBatchEmailsHandler(w, r, mboxName)
+			} else if r.Method == "POST" && (parts[2] == "move-batch" || parts[2] == "copy-batch") {
+				transferBatchHandler(w, r, mboxName, parts[2] == "move-batch")
 			} else {
 				emailContentHandler(w, r, mboxName, parts[2])
 			}
 		case 4:
-			if r.Method == "POST" && parts[3] == "read" {
+			switch {
+			case r.Method == "POST" && parts[3] == "read":
 				markEmailReadHandler(w, r, mboxName, parts[2])
+			case r.Method == "POST" && parts[3] == "undelete":
+				undeleteEmailHandler(w, r, mboxName, parts[2])
+			case r.Method == "POST" && parts[3] == "flags":
+				emailFlagsHandler(w, r, mboxName, parts[2])
+			case r.Method == "POST" && (parts[3] == "move" || parts[3] == "copy"):
+				transferEmailHandler(w, r, mboxName, parts[2], parts[3] == "move")
+			case parts[3] == "raw":
+				rawEmailHandler(w, r, mboxName, parts[2])
+			case parts[3] == "headers":
+				headersHandler(w, r, mboxName, parts[2])
+			case parts[3] == "trace":
+				traceHandler(w, r, mboxName, parts[2])
+			default:
+				http.NotFound(w, r)
+			}
+		case 5:
+			switch parts[3] {
+			case "attachments":
+				attachmentHandler(w, r, mboxName, parts[2], parts[4])
+			case "messages":
+				embeddedMessageHandler(w, r, mboxName, parts[2], parts[4])
+			default:
+				http.NotFound(w, r)
 			}
 		}
 		return
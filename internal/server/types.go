@@ -1,21 +1,306 @@
 package server
 
-import "time"
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
 
 type Email struct {
-	ID      int    `json:"id"`
-	From    string `json:"from"`
-	Date    string `json:"date"`
-	Subject string `json:"subject"`
-	Status  string `json:"status"`
+	ID      int      `json:"id"`  // ordinal position in the mbox file
+	UID     string   `json:"uid"` // stable identifier; preferred over ID
+	From    string   `json:"from"`
+	Date    string   `json:"date"`
+	Subject string   `json:"subject"`
+	Status  string   `json:"status"` // raw Status field; "N" when missing
+	Flags   []string `json:"flags"`  // seen, answered, flagged, draft, deleted, old and keywords
+	Size    int64    `json:"size"`   // bytes stored in the mbox file
 	// Timestamp is parsed Date used for sorting. Not exported to JSON.
 	Timestamp time.Time `json:"-"`
 }
 
+// MailboxNode is a mailbox or folder in the mailbox tree. Folders are
+// directories that only hold other mailboxes and cannot be selected.
+type MailboxNode struct {
+	Name       string         `json:"name"` // last level of the name
+	Path       string         `json:"path"` // full name with "/" delimiters, used in URLs
+	Selectable bool           `json:"selectable"`
+	Total      int            `json:"total"` // messages, excluding deleted ones
+	Unread     int            `json:"unread"`
+	Children   []*MailboxNode `json:"children,omitempty"`
+}
+
+// EmailList is one page of the email list.
+type EmailList struct {
+	Total      int     `json:"total"`                // emails in the mailbox
+	Offset     int     `json:"offset"`               // position of the first email of this page
+	Limit      int     `json:"limit"`                // requested page size; 0 means unlimited
+	NextCursor string  `json:"nextCursor,omitempty"` // pass as cursor to get the next page
+	Emails     []Email `json:"emails"`
+}
+
 type EmailContent struct {
-	BodyText     string   `json:"bodyText"`     // Plain text version
-	BodyHTML     string   `json:"bodyHTML"`     // HTML version
-	BodyType     string   `json:"bodyType"`     // Primary body type (text/plain or text/html)
-	HasAlternate bool     `json:"hasAlternate"` // Whether both text and HTML are available
-	Attachments  []string `json:"attachments"`
+	BodyText      string            `json:"bodyText"`               // Plain text version
+	BodyHTML      string            `json:"bodyHTML"`               // HTML version
+	BodyTextHTML  string            `json:"bodyTextHTML,omitempty"` // BodyText rendered as HTML, on request
+	BodyType      string            `json:"bodyType"`               // Primary body type (text/plain or text/html)
+	HasAlternate  bool              `json:"hasAlternate"`           // Whether both text and HTML are available
+	RemoteBlocked bool              `json:"remoteBlocked"`          // Remote images or CSS in BodyHTML or an enclosed message were replaced
+	Attachments   []Attachment      `json:"attachments"`
+	Messages      []EmbeddedMessage `json:"messages,omitempty"` // enclosed message/rfc822 parts, e.g. forwarded mail
+	Signatures    []SignatureInfo   `json:"signatures,omitempty"`
+	Encrypted     []EncryptedPart   `json:"encrypted,omitempty"` // parts that cannot be shown since they are encrypted
+	Calendars     []CalendarInfo    `json:"calendars,omitempty"` // text/calendar parts, e.g. meeting invitations
+	Reports       []DeliveryReport  `json:"reports,omitempty"`   // bounces and read receipts
+	Structure     *MIMEStructure    `json:"structure,omitempty"` // the whole MIME tree, on request
+}
+
+// MIMEStructure is a node of the MIME tree of a message. The message itself
+// has an empty PartID; a single-part message has its body as part "1" too.
+type MIMEStructure struct {
+	PartID            string            `json:"partId"`
+	ContentType       string            `json:"contentType"`
+	Params            map[string]string `json:"params,omitempty"`            // Content-Type parameters, decoded
+	Encoding          string            `json:"encoding,omitempty"`          // Content-Transfer-Encoding; empty means 7bit
+	Disposition       string            `json:"disposition,omitempty"`       // as sent, not defaulted
+	DispositionParams map[string]string `json:"dispositionParams,omitempty"` // decoded
+	Filename          string            `json:"filename,omitempty"`
+	ContentID         string            `json:"contentId,omitempty"`
+	Description       string            `json:"description,omitempty"`
+	Size              int               `json:"size"`                  // body bytes as sent
+	DecodedSize       int               `json:"decodedSize,omitempty"` // body bytes after transfer decoding; leaves only
+	Children          []*MIMEStructure  `json:"children,omitempty"`
+	Message           *MIMEStructure    `json:"message,omitempty"` // of a message/rfc822 part: the enclosed message
+}
+
+// SignatureInfo describes an S/MIME or OpenPGP signature of a message and
+// how it verified against the configured trust store or keyring.
+type SignatureInfo struct {
+	PartID       string   `json:"partId"`   // the multipart/signed, application/pkcs7-mime or inline signed text part
+	Protocol     string   `json:"protocol"` // smime or pgp
+	Status       string   `json:"status"`   // valid, untrusted, unknownKey, expired, revoked, invalid or malformed
+	Signer       string   `json:"signer,omitempty"`
+	Emails       []string `json:"emails,omitempty"`       // the signer's addresses
+	Fingerprint  string   `json:"fingerprint,omitempty"`  // of the certificate (SHA-256) or OpenPGP key; the key ID when unknown
+	Issuer       string   `json:"issuer,omitempty"`       // of the S/MIME certificate
+	SignedAt     string   `json:"signedAt,omitempty"`     // RFC 3339
+	FromMismatch bool     `json:"fromMismatch,omitempty"` // the signer's addresses do not include the From address
+	Detail       string   `json:"detail,omitempty"`       // why verification failed
+}
+
+// EncryptedPart is an encrypted part of a message. The encrypted data is
+// listed among the attachments.
+type EncryptedPart struct {
+	PartID   string `json:"partId"`
+	Protocol string `json:"protocol"` // smime or pgp
+}
+
+// CalendarInfo describes an iCalendar part. The part itself is listed as
+// an attachment and served as an .ics file.
+type CalendarInfo struct {
+	PartID string          `json:"partId"`
+	Method string          `json:"method,omitempty"` // REQUEST (invitation), CANCEL, REPLY...
+	Events []CalendarEvent `json:"events"`
+}
+
+// CalendarEvent summarizes a VEVENT.
+type CalendarEvent struct {
+	UID          string           `json:"uid,omitempty"`
+	Summary      string           `json:"summary"`
+	Description  string           `json:"description,omitempty"`
+	Location     string           `json:"location,omitempty"`
+	Status       string           `json:"status,omitempty"` // TENTATIVE, CONFIRMED or CANCELLED
+	Sequence     int              `json:"sequence,omitempty"`
+	Organizer    *CalendarPerson  `json:"organizer,omitempty"`
+	Attendees    []CalendarPerson `json:"attendees,omitempty"`
+	Start        *CalendarTime    `json:"start,omitempty"`
+	End          *CalendarTime    `json:"end,omitempty"`
+	AllDay       bool             `json:"allDay,omitempty"`
+	RecurrenceID *CalendarTime    `json:"recurrenceId,omitempty"` // the occurrence this event replaces
+	Recurrence   []string         `json:"recurrence,omitempty"`   // RRULE, RDATE and EXDATE lines as written
+}
+
+// CalendarPerson is the organizer or an attendee of an event.
+type CalendarPerson struct {
+	Name   string `json:"name,omitempty"`
+	Email  string `json:"email"`
+	Role   string `json:"role,omitempty"`   // e.g. REQ-PARTICIPANT
+	Status string `json:"status,omitempty"` // participation status, e.g. ACCEPTED
+	RSVP   bool   `json:"rsvp,omitempty"`
+}
+
+// CalendarTime is the start, end or recurrence ID of an event.
+type CalendarTime struct {
+	Value    string `json:"value,omitempty"` // as written; empty when computed from DURATION
+	TZID     string `json:"tzid,omitempty"`
+	Time     string `json:"time"`               // RFC 3339 with the zone's offset; the date only for all-day events, no offset when floating
+	Floating bool   `json:"floating,omitempty"` // no zone given, or an unknown one
+}
+
+// DeliveryReport is the machine-readable part of a delivery status
+// notification (a bounce) or a message disposition notification (a read
+// receipt).
+type DeliveryReport struct {
+	PartID             string              `json:"partId"`
+	Type               string              `json:"type"`               // delivery-status or disposition-notification
+	Reporter           string              `json:"reporter,omitempty"` // Reporting-MTA, or Reporting-UA of a receipt
+	ArrivalDate        string              `json:"arrivalDate,omitempty"`
+	OriginalEnvelopeID string              `json:"originalEnvelopeId,omitempty"`
+	OriginalMessageID  string              `json:"originalMessageId,omitempty"`
+	OriginalSubject    string              `json:"originalSubject,omitempty"`
+	Disposition        string              `json:"disposition,omitempty"`     // of a receipt: displayed, deleted, dispatched or processed
+	DispositionMode    string              `json:"dispositionMode,omitempty"` // of a receipt, e.g. manual-action/MDN-sent-manually
+	Recipients         []DeliveryRecipient `json:"recipients,omitempty"`
+}
+
+// DeliveryRecipient is the outcome of a delivery to one recipient. Only
+// the addresses are set for a receipt.
+type DeliveryRecipient struct {
+	FinalRecipient    string `json:"finalRecipient"`
+	OriginalRecipient string `json:"originalRecipient,omitempty"`
+	Action            string `json:"action,omitempty"` // failed, delayed, delivered, relayed or expanded
+	Status            string `json:"status,omitempty"` // e.g. 5.1.1
+	RemoteMTA         string `json:"remoteMta,omitempty"`
+	DiagnosticCode    string `json:"diagnosticCode,omitempty"` // e.g. "550 5.1.1 User unknown"
+	LastAttemptDate   string `json:"lastAttemptDate,omitempty"`
+	WillRetryUntil    string `json:"willRetryUntil,omitempty"`
+}
+
+// BouncesResponse is returned by the bounces endpoint.
+type BouncesResponse struct {
+	Reports    []BounceReport    `json:"reports"`    // in mailbox order
+	Recipients []BounceRecipient `json:"recipients"` // most failures first
+}
+
+// BounceReport is a delivery status notification found in a mailbox.
+type BounceReport struct {
+	Email  Email          `json:"email"`
+	Report DeliveryReport `json:"report"`
+}
+
+// BounceRecipient totals the failed and delayed deliveries to one address.
+type BounceRecipient struct {
+	Address        string `json:"address"`
+	Failed         int    `json:"failed"`
+	Delayed        int    `json:"delayed"`
+	LastAction     string `json:"lastAction"`
+	LastStatus     string `json:"lastStatus,omitempty"`
+	LastDiagnostic string `json:"lastDiagnostic,omitempty"`
+	LastDate       string `json:"lastDate,omitempty"` // of the latest report, RFC 3339
+	LastUID        string `json:"lastUid"`            // of the latest report
+}
+
+// EmbeddedMessage is a message enclosed in another as a message/rfc822 part.
+// It is also listed as an attachment; its own parts have IDs below PartID.
+type EmbeddedMessage struct {
+	PartID    string `json:"partId"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Cc        string `json:"cc,omitempty"`
+	Subject   string `json:"subject"`
+	Date      string `json:"date"`
+	MessageID string `json:"messageId,omitempty"`
+	EmailContent
+
+	root *mimePart // the enclosed message, for resolving its cid: references
+}
+
+// Attachment describes a non-body part of a message. The content is served
+// by the attachments route under PartID.
+type Attachment struct {
+	PartID      string `json:"partId"`   // IMAP-style section number, e.g. "2" or "1.3"
+	Filename    string `json:"filename"` // decoded; empty when the sender gave none
+	ContentType string `json:"contentType"`
+	Size        int    `json:"size"` // bytes after transfer decoding
+	ContentID   string `json:"contentId,omitempty"`
+	Disposition string `json:"disposition"` // attachment or inline
+}
+
+// MessageHeaders is the header block of a message as returned by the
+// headers endpoint.
+type MessageHeaders struct {
+	Envelope string        `json:"envelope"` // mbox "From " line
+	Headers  []HeaderField `json:"headers"`  // in message order
+}
+
+// HeaderField is one header field. Value is unfolded but otherwise as sent;
+// Decoded has RFC 2047 encoded-words decoded.
+type HeaderField struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Decoded string `json:"decoded"`
+}
+
+// MessageTrace is the delivery path of a message, read from its Received
+// fields, as returned by the trace endpoint.
+type MessageTrace struct {
+	Date       string     `json:"date,omitempty"`       // the Date header, RFC 3339
+	Hops       []TraceHop `json:"hops"`                 // from the sender's side to the recipient's
+	TotalDelay *float64   `json:"totalDelay,omitempty"` // seconds from Date to the last hop
+}
+
+// TraceHop is one relay on the delivery path, from one Received field.
+type TraceHop struct {
+	From       string   `json:"from,omitempty"`     // the name the sending host gave
+	FromRDNS   string   `json:"fromRdns,omitempty"` // its name by reverse lookup
+	FromIP     string   `json:"fromIp,omitempty"`
+	By         string   `json:"by,omitempty"`
+	ByIP       string   `json:"byIp,omitempty"`
+	Protocol   string   `json:"protocol,omitempty"` // e.g. ESMTPS
+	TLS        bool     `json:"tls"`
+	TLSVersion string   `json:"tlsVersion,omitempty"`
+	Cipher     string   `json:"cipher,omitempty"`
+	ID         string   `json:"id,omitempty"`
+	For        string   `json:"for,omitempty"`
+	Timestamp  string   `json:"timestamp,omitempty"`  // RFC 3339, in the relay's zone
+	Delay      *float64 `json:"delay,omitempty"`      // seconds since the hop before, or since Date for the first
+	OutOfOrder bool     `json:"outOfOrder,omitempty"` // stamped earlier than the hop before: a clock is off
+	Raw        string   `json:"raw"`
+}
+
+// Thread is a conversation returned by the threads endpoint.
+type Thread struct {
+	Subject  string      `json:"subject"`
+	Messages int         `json:"messages"` // number of messages in the thread
+	Latest   string      `json:"latest"`   // RFC 3339 date of the newest message
+	Root     *ThreadNode `json:"root"`
+}
+
+// ThreadNode is a message in a thread tree. Email is omitted for a message
+// that is referenced by replies but not present in the mailbox.
+type ThreadNode struct {
+	Email    *Email        `json:"email,omitempty"`
+	Children []*ThreadNode `json:"children,omitempty"`
+}
+
+// SearchHit is a message matching a search query.
+type SearchHit struct {
+	Mailbox string `json:"mailbox"`
+	Email
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+type SearchResponse struct {
+	Query string      `json:"query"`
+	Total int         `json:"total"`
+	Hits  []SearchHit `json:"hits"`
+}
+
+// EmailRef is an email ID in a request body. It accepts both the stable UID
+// string and the legacy ordinal number.
+type EmailRef string
+
+func (ref *EmailRef) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*ref = EmailRef(strconv.Itoa(n))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*ref = EmailRef(s)
+	return nil
 }
@@ -0,0 +1,387 @@
+// Package signature verifies S/MIME and OpenPGP signatures of messages.
+//
+// S/MIME signers are trusted when their certificate chains up to the trust
+// store; OpenPGP signers when their key is in the keyring. Certificates and
+// keys are judged at the time the message was signed, not today, since the
+// messages being read are usually old.
+package signature
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/smallstep/pkcs7"
+)
+
+// Status is the outcome of a verification.
+type Status string
+
+const (
+	Valid      Status = "valid"      // intact, and the signer is trusted
+	Untrusted  Status = "untrusted"  // intact, but the certificate does not chain to the trust store
+	UnknownKey Status = "unknownKey" // the signer's key is not in the keyring
+	Expired    Status = "expired"    // the signature or the key had expired when signing
+	Revoked    Status = "revoked"    // the key has been revoked
+	Invalid    Status = "invalid"    // the content does not match the signature
+	Malformed  Status = "malformed"  // the signature could not be read
+)
+
+// Result describes a signature and how it verified.
+type Result struct {
+	Status      Status
+	Signer      string    // the signer's name, if known
+	Emails      []string  // the signer's addresses, if known
+	Fingerprint string    // of the certificate (SHA-256) or key; the key ID for an unknown key
+	Issuer      string    // of an S/MIME certificate
+	SignedAt    time.Time // zero when the signature does not say
+	Detail      string    // why verification failed
+}
+
+// Verifier holds what signers are checked against. A nil Verifier trusts
+// no one but still tells intact signatures from broken ones.
+type Verifier struct {
+	roots   *x509.CertPool
+	keyring openpgp.EntityList
+}
+
+// NewVerifier loads the S/MIME trust store and the OpenPGP keyring. Each
+// path may name a file or a directory of files. Without a trust store the
+// system roots are used; without a keyring no OpenPGP signer is known.
+func NewVerifier(trustStore, keyring string) (*Verifier, error) {
+	v := &Verifier{}
+	if trustStore == "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		v.roots = roots
+	} else {
+		roots, err := LoadTrustStore(trustStore)
+		if err != nil {
+			return nil, err
+		}
+		v.roots = roots
+	}
+	if keyring != "" {
+		entities, err := LoadKeyring(keyring)
+		if err != nil {
+			return nil, err
+		}
+		v.keyring = entities
+	}
+	return v, nil
+}
+
+// LoadTrustStore reads CA certificates, PEM or DER encoded.
+func LoadTrustStore(path string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	n := 0
+	err := readFiles(path, func(name string, data []byte) error {
+		if !bytes.Contains(data, []byte("-----BEGIN")) {
+			cert, err := x509.ParseCertificate(data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			pool.AddCert(cert)
+			n++
+			return nil
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				return nil
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			pool.AddCert(cert)
+			n++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return pool, nil
+}
+
+// LoadKeyring reads OpenPGP public keys, armored or binary.
+func LoadKeyring(path string) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	err := readFiles(path, func(name string, data []byte) error {
+		var entities openpgp.EntityList
+		var err error
+		if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+			entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		} else {
+			entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		keyring = append(keyring, entities...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("%s: no keys found", path)
+	}
+	return keyring, nil
+}
+
+// readFiles calls fn with the content of path, or of every regular file in
+// it if it is a directory.
+func readFiles(path string, fn func(name string, data []byte) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	names := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		names = names[:0]
+		for _, e := range entries {
+			if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+				names = append(names, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := fn(name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Canonicalize converts line endings to CRLF, the form MIME content is
+// signed in, as mailboxes usually store it with bare LF.
+func Canonicalize(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+}
+
+// SMIMEContent returns the content of an S/MIME signed-data object (an
+// application/pkcs7-mime part with smime-type=signed-data) without
+// verifying it.
+func SMIMEContent(data []byte) ([]byte, bool) {
+	p7, err := pkcs7.Parse(data)
+	if err != nil || len(p7.Signers) == 0 || len(p7.Content) == 0 {
+		return nil, false
+	}
+	return p7.Content, true
+}
+
+// VerifySMIME checks an S/MIME signature. For a detached signature
+// (multipart/signed) content is the signed entity, canonicalized; for a
+// signed-data object it is nil and the signature carries the content.
+func (v *Verifier) VerifySMIME(content, signature []byte) Result {
+	p7, err := pkcs7.Parse(signature)
+	if err != nil {
+		return Result{Status: Malformed, Detail: err.Error()}
+	}
+	if len(p7.Signers) == 0 {
+		return Result{Status: Malformed, Detail: "no signers"}
+	}
+	if content != nil {
+		p7.Content = content
+	}
+
+	var r Result
+	if cert := p7.GetOnlySigner(); cert != nil {
+		r.Signer = cert.Subject.CommonName
+		r.Emails = certificateEmails(cert)
+		r.Issuer = cert.Issuer.String()
+		sum := sha256.Sum256(cert.Raw)
+		r.Fingerprint = strings.ToUpper(hex.EncodeToString(sum[:]))
+	}
+	var signedAt time.Time
+	if err := p7.UnmarshalSignedAttribute(pkcs7.OIDAttributeSigningTime, &signedAt); err == nil {
+		r.SignedAt = signedAt
+	}
+
+	// Check the signature alone first, so that a broken one is not
+	// reported as untrusted
+	if err := p7.Verify(); err != nil {
+		r.Status, r.Detail = Invalid, err.Error()
+		var timeErr *pkcs7.SigningTimeNotValidError
+		if errors.As(err, &timeErr) {
+			r.Status = Expired
+		}
+		return r
+	}
+	roots := x509.NewCertPool()
+	if v != nil && v.roots != nil {
+		roots = v.roots
+	}
+	if err := p7.VerifyWithChain(roots); err != nil {
+		r.Status, r.Detail = Untrusted, err.Error()
+		return r
+	}
+	r.Status = Valid
+	return r
+}
+
+// certificateEmails returns the addresses a certificate was issued to: the
+// subject alternative names and, as older certificates have it, the
+// emailAddress attribute of the subject.
+func certificateEmails(cert *x509.Certificate) []string {
+	emails := append([]string{}, cert.EmailAddresses...)
+	for _, name := range cert.Subject.Names {
+		if !name.Type.Equal(oidEmailAddress) {
+			continue
+		}
+		if s, ok := name.Value.(string); ok && !containsFold(emails, s) {
+			emails = append(emails, s)
+		}
+	}
+	return emails
+}
+
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPGP checks a PGP/MIME detached signature (RFC 3156). content is
+// the signed entity, canonicalized; signature is armored or binary.
+func (v *Verifier) VerifyPGP(content, signature []byte) Result {
+	if bytes.Contains(signature, []byte("-----BEGIN PGP")) {
+		block, err := armor.Decode(bytes.NewReader(signature))
+		if err != nil {
+			return Result{Status: Malformed, Detail: err.Error()}
+		}
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(block.Body); err != nil {
+			return Result{Status: Malformed, Detail: err.Error()}
+		}
+		signature = buf.Bytes()
+	}
+
+	// Read the signature packet for what it says about itself, which is
+	// all there is to report when the key is unknown
+	p, err := packet.NewReader(bytes.NewReader(signature)).Next()
+	if err != nil {
+		return Result{Status: Malformed, Detail: err.Error()}
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return Result{Status: Malformed, Detail: "not a signature"}
+	}
+	r := Result{SignedAt: sig.CreationTime}
+	switch {
+	case sig.IssuerFingerprint != nil:
+		r.Fingerprint = strings.ToUpper(hex.EncodeToString(sig.IssuerFingerprint))
+	case sig.IssuerKeyId != nil:
+		r.Fingerprint = fmt.Sprintf("%016X", *sig.IssuerKeyId)
+	}
+
+	var keyring openpgp.EntityList
+	if v != nil {
+		keyring = v.keyring
+	}
+	config := &packet.Config{Time: func() time.Time { return sig.CreationTime }}
+	_, signer, err := openpgp.VerifyDetachedSignature(keyring, bytes.NewReader(content), bytes.NewReader(signature), config)
+	if signer != nil {
+		r.Fingerprint = strings.ToUpper(hex.EncodeToString(signer.PrimaryKey.Fingerprint))
+		for _, id := range signer.Identities {
+			if id.UserId == nil {
+				continue
+			}
+			if r.Signer == "" || id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId {
+				r.Signer = id.UserId.Name
+			}
+			if id.UserId.Email != "" && !containsFold(r.Emails, id.UserId.Email) {
+				r.Emails = append(r.Emails, id.UserId.Email)
+			}
+		}
+	}
+	switch {
+	case err == nil:
+		r.Status = Valid
+		return r
+	case errors.Is(err, pgperrors.ErrUnknownIssuer):
+		r.Status = UnknownKey
+	case errors.Is(err, pgperrors.ErrSignatureExpired), errors.Is(err, pgperrors.ErrKeyExpired):
+		r.Status = Expired
+	case errors.Is(err, pgperrors.ErrKeyRevoked):
+		r.Status = Revoked
+	default:
+		var structural pgperrors.StructuralError
+		var unsupported pgperrors.UnsupportedError
+		if errors.As(err, &structural) || errors.As(err, &unsupported) {
+			r.Status = Malformed
+		} else {
+			r.Status = Invalid
+		}
+	}
+	r.Detail = err.Error()
+	return r
+}
+
+var clearsignStart = []byte("-----BEGIN PGP SIGNED MESSAGE-----")
+
+// VerifyClearsigned finds an inline clearsigned block in text and checks its
+// signature. It returns the text with the block replaced by the signed text,
+// and false if there is no block.
+func (v *Verifier) VerifyClearsigned(text []byte) ([]byte, Result, bool) {
+	i := bytes.Index(text, clearsignStart)
+	if i < 0 || i > 0 && text[i-1] != '\n' {
+		return nil, Result{}, false
+	}
+	block, rest := clearsign.Decode(text[i:])
+	if block == nil {
+		return nil, Result{}, false
+	}
+	signature, err := io.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		return nil, Result{}, false
+	}
+	r := v.VerifyPGP(block.Bytes, signature)
+
+	out := append([]byte{}, text[:i]...)
+	out = append(out, block.Plaintext...)
+	if len(rest) > 0 {
+		out = append(out, '\n')
+		out = append(out, rest...)
+	}
+	return out, r, true
+}
@@ -0,0 +1,273 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/smallstep/pkcs7"
+)
+
+// testCert is a certificate with its key, issued by parent or self-signed
+// when parent is nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCert(t *testing.T, parent *testCert, cn, email string, notAfter time.Time) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	if email == "" {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		tmpl.EmailAddresses = []string{email}
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}
+	}
+	issuer, signer := tmpl, crypto.Signer(key)
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func signSMIME(t *testing.T, signer, parent *testCert, content []byte, detached bool) []byte {
+	t.Helper()
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sd.AddSignerChain(signer.cert, signer.key, []*x509.Certificate{parent.cert}, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if detached {
+		sd.Detach()
+	}
+	der, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestVerifySMIME(t *testing.T) {
+	ca := newTestCert(t, nil, "Test CA", "", time.Now().Add(24*time.Hour))
+	otherCA := newTestCert(t, nil, "Other CA", "", time.Now().Add(24*time.Hour))
+	alice := newTestCert(t, ca, "Alice", "alice@example.com", time.Now().Add(24*time.Hour))
+	expired := newTestCert(t, ca, "Bob", "bob@example.com", time.Now().Add(-time.Minute))
+
+	trusting := &Verifier{roots: x509.NewCertPool()}
+	trusting.roots.AddCert(ca.cert)
+	other := &Verifier{roots: x509.NewCertPool()}
+	other.roots.AddCert(otherCA.cert)
+
+	content := Canonicalize([]byte("Content-Type: text/plain\n\nHello\n"))
+	detached := signSMIME(t, alice, ca, content, true)
+	enveloped := signSMIME(t, alice, ca, content, false)
+	tampered := bytes.Replace(content, []byte("Hello"), []byte("Jello"), 1)
+
+	tests := []struct {
+		name      string
+		verifier  *Verifier
+		content   []byte
+		signature []byte
+		want      Status
+	}{
+		{"valid", trusting, content, detached, Valid},
+		{"valid signed-data", trusting, nil, enveloped, Valid},
+		{"untrusted signer", other, content, detached, Untrusted},
+		{"no trust store", nil, content, detached, Untrusted},
+		{"tampered body", trusting, tampered, detached, Invalid},
+		{"tampered body untrusted", other, tampered, detached, Invalid},
+		{"expired certificate", trusting, content, signSMIME(t, expired, ca, content, true), Expired},
+		{"malformed", trusting, content, []byte("not a signature"), Malformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.verifier.VerifySMIME(tt.content, tt.signature)
+			if r.Status != tt.want {
+				t.Fatalf("Status = %q (%s), want %q", r.Status, r.Detail, tt.want)
+			}
+			if tt.want == Malformed {
+				return
+			}
+			if tt.want != Expired && (r.Signer != "Alice" || len(r.Emails) != 1 || r.Emails[0] != "alice@example.com") {
+				t.Errorf("Signer = %q %q, want Alice alice@example.com", r.Signer, r.Emails)
+			}
+			if r.Fingerprint == "" || !strings.Contains(r.Issuer, "Test CA") || r.SignedAt.IsZero() {
+				t.Errorf("Result = %+v, want fingerprint, issuer and signing time", r)
+			}
+		})
+	}
+
+	if got, ok := SMIMEContent(enveloped); !ok || !bytes.Equal(got, content) {
+		t.Errorf("SMIMEContent = %q, %v, want %q", got, ok, content)
+	}
+	if _, ok := SMIMEContent(detached); ok {
+		t.Error("SMIMEContent of a detached signature succeeded")
+	}
+}
+
+func newTestEntity(t *testing.T, name, email string) *openpgp.Entity {
+	t.Helper()
+	e, err := openpgp.NewEntity(name, "", email, &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func signPGP(t *testing.T, signer *openpgp.Entity, content []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, signer, bytes.NewReader(content), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerifyPGP(t *testing.T) {
+	alice := newTestEntity(t, "Alice", "alice@example.com")
+	mallory := newTestEntity(t, "Mallory", "mallory@example.com")
+	v := &Verifier{keyring: openpgp.EntityList{alice}}
+
+	content := Canonicalize([]byte("Content-Type: text/plain\n\nHello\n"))
+	signature := signPGP(t, alice, content)
+	tampered := bytes.Replace(content, []byte("Hello"), []byte("Jello"), 1)
+
+	// The same signature unarmored
+	block, err := armor.Decode(bytes.NewReader(signature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var binary bytes.Buffer
+	binary.ReadFrom(block.Body)
+
+	tests := []struct {
+		name      string
+		verifier  *Verifier
+		content   []byte
+		signature []byte
+		want      Status
+	}{
+		{"valid", v, content, signature, Valid},
+		{"valid binary", v, content, binary.Bytes(), Valid},
+		{"unknown signer", v, content, signPGP(t, mallory, content), UnknownKey},
+		{"no keyring", nil, content, signature, UnknownKey},
+		{"tampered body", v, tampered, signature, Invalid},
+		{"malformed", v, content, []byte("-----BEGIN PGP SIGNATURE-----\n\nbm90\n-----END PGP SIGNATURE-----\n"), Malformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.verifier.VerifyPGP(tt.content, tt.signature)
+			if r.Status != tt.want {
+				t.Fatalf("Status = %q (%s), want %q", r.Status, r.Detail, tt.want)
+			}
+			if tt.want == Malformed {
+				return
+			}
+			if r.Fingerprint == "" || r.SignedAt.IsZero() {
+				t.Errorf("Result = %+v, want fingerprint and signing time", r)
+			}
+			if tt.want == Valid && (r.Signer != "Alice" || len(r.Emails) != 1 || r.Emails[0] != "alice@example.com") {
+				t.Errorf("Signer = %q %q, want Alice alice@example.com", r.Signer, r.Emails)
+			}
+		})
+	}
+}
+
+func TestVerifyClearsigned(t *testing.T) {
+	alice := newTestEntity(t, "Alice", "alice@example.com")
+	v := &Verifier{keyring: openpgp.EntityList{alice}}
+
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, alice.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("Hello\nWorld"))
+	w.Close()
+	text := append([]byte("Quoted above\n"), buf.Bytes()...)
+
+	out, r, ok := v.VerifyClearsigned(text)
+	if !ok || r.Status != Valid {
+		t.Fatalf("VerifyClearsigned = %v, %q (%s), want valid", ok, r.Status, r.Detail)
+	}
+	if !bytes.HasPrefix(out, []byte("Quoted above\nHello\nWorld")) || bytes.Contains(out, []byte("BEGIN PGP")) {
+		t.Errorf("text = %q", out)
+	}
+
+	_, r, ok = v.VerifyClearsigned(bytes.Replace(text, []byte("World"), []byte("Wurld"), 1))
+	if !ok || r.Status != Invalid {
+		t.Errorf("tampered: VerifyClearsigned = %v, %q, want invalid", ok, r.Status)
+	}
+	if _, _, ok := v.VerifyClearsigned([]byte("Hello\n")); ok {
+		t.Error("VerifyClearsigned found a block in plain text")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, nil, "Test CA", "", time.Now().Add(24*time.Hour))
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600)
+	os.WriteFile(filepath.Join(dir, "ca.der"), ca.cert.Raw, 0600)
+	if _, err := LoadTrustStore(dir); err != nil {
+		t.Errorf("LoadTrustStore: %v", err)
+	}
+
+	keys := t.TempDir()
+	var buf bytes.Buffer
+	w, _ := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	newTestEntity(t, "Alice", "alice@example.com").Serialize(w)
+	w.Close()
+	os.WriteFile(filepath.Join(keys, "alice.asc"), buf.Bytes(), 0600)
+	if keyring, err := LoadKeyring(keys); err != nil || len(keyring) != 1 {
+		t.Errorf("LoadKeyring = %d keys, %v, want 1", len(keyring), err)
+	}
+
+	empty := t.TempDir()
+	os.WriteFile(filepath.Join(empty, "notes.txt"), []byte("nothing here"), 0600)
+	if _, err := LoadTrustStore(empty); err == nil {
+		t.Error("LoadTrustStore of a directory without certificates succeeded")
+	}
+	if _, err := NewVerifier(caFile, filepath.Join(dir, "missing")); err == nil {
+		t.Error("NewVerifier with a missing keyring succeeded")
+	}
+}
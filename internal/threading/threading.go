@@ -0,0 +1,305 @@
+// Package threading groups messages into conversations using the algorithm
+// described by Jamie Zawinski (https://www.jwz.org/doc/threading.html).
+//
+// Messages are linked through Message-ID, In-Reply-To and References; threads
+// whose links were lost by broken clients are then merged by subject.
+package threading
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is the threading-relevant data of one message.
+type Message struct {
+	Key        int      // caller's identifier, returned in Node
+	MessageID  string   // without angle brackets
+	References []string // ancestors, oldest first; In-Reply-To appended if absent
+	Subject    string   // decoded subject
+	Date       time.Time
+}
+
+// Node is a position in a thread tree. Message is nil for a message that was
+// referenced but is not present.
+type Node struct {
+	Message  *Message
+	Children []*Node
+}
+
+// Latest returns the newest date in the subtree.
+func (n *Node) Latest() time.Time {
+	var t time.Time
+	if n.Message != nil {
+		t = n.Message.Date
+	}
+	for _, c := range n.Children {
+		if ct := c.Latest(); ct.After(t) {
+			t = ct
+		}
+	}
+	return t
+}
+
+// Count returns the number of messages in the subtree.
+func (n *Node) Count() int {
+	c := 0
+	if n.Message != nil {
+		c = 1
+	}
+	for _, child := range n.Children {
+		c += child.Count()
+	}
+	return c
+}
+
+type container struct {
+	msg      *Message
+	parent   *container
+	children []*container
+}
+
+func (c *container) hasDescendant(d *container) bool {
+	for _, child := range c.children {
+		if child == d || child.hasDescendant(d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *container) removeChild(child *container) {
+	for i, ch := range c.children {
+		if ch == child {
+			c.children = append(c.children[:i], c.children[i+1:]...)
+			break
+		}
+	}
+	child.parent = nil
+}
+
+func (c *container) addChild(child *container) {
+	if child.parent != nil {
+		child.parent.removeChild(child)
+	}
+	child.parent = c
+	c.children = append(c.children, child)
+}
+
+// Thread builds thread trees from msgs. Threads are ordered newest activity
+// first and replies within a thread oldest first.
+func Thread(msgs []Message) []*Node {
+	idTable := map[string]*container{}
+	var all []*container // every container in creation order
+	get := func(id string) *container {
+		c, ok := idTable[id]
+		if !ok {
+			c = &container{}
+			idTable[id] = c
+			all = append(all, c)
+		}
+		return c
+	}
+
+	for i := range msgs {
+		m := &msgs[i]
+
+		// Messages without (or with duplicate) Message-IDs get a private container
+		var c *container
+		if m.MessageID != "" {
+			if existing, ok := idTable[m.MessageID]; !ok || existing.msg == nil {
+				c = get(m.MessageID)
+			}
+		}
+		if c == nil {
+			c = &container{}
+			all = append(all, c)
+		}
+		c.msg = m
+
+		// Link the references chain together
+		var prev *container
+		for _, ref := range m.References {
+			if ref == m.MessageID {
+				continue
+			}
+			rc := get(ref)
+			if prev != nil && rc.parent == nil && rc != prev && !rc.hasDescendant(prev) {
+				prev.addChild(rc)
+			}
+			prev = rc
+		}
+
+		// The last reference is the parent of this message
+		if c.parent != nil {
+			c.parent.removeChild(c)
+		}
+		if prev != nil && prev != c && !c.hasDescendant(prev) {
+			prev.addChild(c)
+		}
+	}
+
+	root := &container{}
+	for _, c := range all {
+		if c.parent == nil {
+			root.addChild(c)
+		}
+	}
+
+	pruneEmpty(root)
+	groupBySubject(root)
+
+	nodes := make([]*Node, 0, len(root.children))
+	for _, c := range root.children {
+		nodes = append(nodes, toNode(c))
+	}
+	sort.SliceStable(nodes, func(a, b int) bool {
+		return nodes[a].Latest().After(nodes[b].Latest())
+	})
+	return nodes
+}
+
+// pruneEmpty removes placeholder containers that carry no information.
+func pruneEmpty(c *container) {
+	var kept []*container
+	for _, child := range c.children {
+		pruneEmpty(child)
+		switch {
+		case child.msg == nil && len(child.children) == 0:
+			// Nothing to show
+		case child.msg == nil && (c.parent != nil || len(child.children) == 1):
+			// Promote the children; at the top level only a single child
+			// is promoted so that siblings stay grouped
+			for _, gc := range child.children {
+				gc.parent = c
+				kept = append(kept, gc)
+			}
+		default:
+			kept = append(kept, child)
+		}
+	}
+	c.children = kept
+}
+
+// groupBySubject merges top-level threads that share a normalized subject.
+func groupBySubject(root *container) {
+	subjects := map[string]*container{}
+	for _, c := range root.children {
+		subj, reply := threadSubject(c)
+		if subj == "" {
+			continue
+		}
+		old, ok := subjects[subj]
+		if !ok {
+			subjects[subj] = c
+			continue
+		}
+		// Prefer an empty container, or a non-reply, as the representative
+		_, oldReply := threadSubject(old)
+		if (c.msg == nil && old.msg != nil) || (oldReply && !reply) {
+			subjects[subj] = c
+		}
+	}
+
+	// Keep the representatives in place and merge the rest into them
+	var kept, merged []*container
+	for _, c := range root.children {
+		if subj, _ := threadSubject(c); subj == "" || subjects[subj] == c {
+			kept = append(kept, c)
+		} else {
+			merged = append(merged, c)
+		}
+	}
+	for _, c := range merged {
+		subj, reply := threadSubject(c)
+		rep := subjects[subj]
+		_, repReply := threadSubject(rep)
+		switch {
+		case rep.msg == nil && c.msg == nil:
+			for _, gc := range append([]*container(nil), c.children...) {
+				rep.addChild(gc)
+			}
+		case rep.msg == nil:
+			rep.addChild(c)
+		case reply && !repReply:
+			rep.addChild(c)
+		default:
+			// Neither is a reply to the other: make them siblings
+			group := &container{}
+			for i, k := range kept {
+				if k == rep {
+					kept[i] = group
+				}
+			}
+			group.addChild(rep)
+			group.addChild(c)
+			subjects[subj] = group
+		}
+	}
+	for _, c := range kept {
+		c.parent = root
+	}
+	root.children = kept
+}
+
+// threadSubject returns the normalized subject of a thread and whether it
+// looked like a reply or forward.
+func threadSubject(c *container) (string, bool) {
+	if c.msg == nil {
+		if len(c.children) == 0 || c.children[0].msg == nil {
+			return "", false
+		}
+		c = c.children[0]
+	}
+	return NormalizeSubject(c.msg.Subject)
+}
+
+var (
+	replyPrefixRegex = regexp.MustCompile(`(?i)^\s*((re|fwd?|aw|sv|wg|antw|tr|返信|転送)(\[\d+\]|\(\d+\))?\s*[:：]\s*)`)
+	listTagRegex     = regexp.MustCompile(`^\s*\[[^\]]*\]\s*`)
+)
+
+// NormalizeSubject strips reply/forward prefixes and mailing list tags.
+// It reports whether any reply or forward prefix was removed.
+func NormalizeSubject(subject string) (string, bool) {
+	reply := false
+	for {
+		s := listTagRegex.ReplaceAllString(subject, "")
+		if loc := replyPrefixRegex.FindStringIndex(s); loc != nil {
+			s = s[loc[1]:]
+			reply = true
+		}
+		if s == subject {
+			break
+		}
+		subject = s
+	}
+	return strings.ToLower(strings.Join(strings.Fields(subject), " ")), reply
+}
+
+func toNode(c *container) *Node {
+	n := &Node{Message: c.msg}
+	for _, child := range c.children {
+		n.Children = append(n.Children, toNode(child))
+	}
+	sort.SliceStable(n.Children, func(a, b int) bool {
+		return n.Children[a].date().Before(n.Children[b].date())
+	})
+	return n
+}
+
+// date is what replies are ordered by: the message's own date or, for a
+// message that is not present, the oldest date of its replies.
+func (n *Node) date() time.Time {
+	if n.Message != nil {
+		return n.Message.Date
+	}
+	var t time.Time
+	for _, c := range n.Children {
+		if ct := c.date(); t.IsZero() || ct.Before(t) {
+			t = ct
+		}
+	}
+	return t
+}
@@ -0,0 +1,176 @@
+package threading
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// render writes a thread as "a(b c(d))", with "*" standing for a message
+// that was referenced but is missing.
+func render(n *Node) string {
+	var sb strings.Builder
+	if n.Message == nil {
+		sb.WriteString("*")
+	} else {
+		sb.WriteString(n.Message.MessageID)
+	}
+	if len(n.Children) > 0 {
+		sb.WriteString("(")
+		for i, c := range n.Children {
+			if i > 0 {
+				sb.WriteString(" ")
+			}
+			sb.WriteString(render(c))
+		}
+		sb.WriteString(")")
+	}
+	return sb.String()
+}
+
+func renderAll(nodes []*Node) []string {
+	var threads []string
+	for _, n := range nodes {
+		threads = append(threads, render(n))
+	}
+	return threads
+}
+
+// msg makes a message dated day days into 2024; the subject defaults to its
+// ID so that unrelated messages do not merge.
+func msg(id string, day int, subject string, refs ...string) Message {
+	if subject == "" {
+		subject = "subject " + id
+	}
+	return Message{
+		MessageID:  id,
+		References: refs,
+		Subject:    subject,
+		Date:       time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestThread(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs []Message
+		want []string
+	}{
+		{
+			name: "reply chain",
+			msgs: []Message{msg("a", 1, ""), msg("b", 2, "", "a"), msg("c", 3, "", "a", "b")},
+			want: []string{"a(b(c))"},
+		},
+		{
+			name: "replies in any order",
+			msgs: []Message{msg("c", 3, "", "a", "b"), msg("b", 2, "", "a"), msg("a", 1, "")},
+			want: []string{"a(b(c))"},
+		},
+		{
+			name: "missing parent with one reply is dropped",
+			msgs: []Message{msg("b", 2, "", "x")},
+			want: []string{"b"},
+		},
+		{
+			name: "missing parent keeps its replies together",
+			msgs: []Message{msg("b", 2, "", "x"), msg("c", 3, "", "x")},
+			want: []string{"*(b c)"},
+		},
+		{
+			name: "missing message in the middle of a chain",
+			msgs: []Message{msg("a", 1, ""), msg("c", 3, "", "a", "b")},
+			want: []string{"a(c)"},
+		},
+		{
+			name: "missing parents below the top are promoted",
+			msgs: []Message{msg("a", 1, ""), msg("c", 3, "", "a", "b"), msg("d", 4, "", "a", "b")},
+			want: []string{"a(c d)"},
+		},
+		{
+			name: "reference loop keeps the first link",
+			msgs: []Message{msg("a", 1, "", "b"), msg("b", 2, "", "a")},
+			want: []string{"b(a)"},
+		},
+		{
+			name: "self reference",
+			msgs: []Message{msg("a", 1, "", "a")},
+			want: []string{"a"},
+		},
+		{
+			name: "duplicate Message-ID",
+			msgs: []Message{msg("a", 1, "one"), msg("a", 2, "two")},
+			want: []string{"a", "a"},
+		},
+		{
+			name: "reply without references joins by subject",
+			msgs: []Message{msg("a", 1, "Lunch"), msg("b", 2, "Re: [team] lunch")},
+			want: []string{"a(b)"},
+		},
+		{
+			name: "same subject without replies become siblings",
+			msgs: []Message{msg("a", 1, "Report"), msg("b", 2, "report")},
+			want: []string{"*(a b)"},
+		},
+		{
+			name: "replies by their own date",
+			msgs: []Message{msg("a", 1, ""), msg("b", 2, "", "a"), msg("c", 3, "", "a"), msg("d", 9, "", "a", "b")},
+			want: []string{"a(b(d) c)"},
+		},
+		{
+			name: "promoted replies by their own date",
+			msgs: []Message{msg("a", 1, ""), msg("c", 3, "", "a"), msg("d", 2, "", "a", "x"), msg("e", 4, "", "a", "x")},
+			want: []string{"a(d c e)"},
+		},
+		{
+			name: "newest activity first",
+			msgs: []Message{msg("a", 1, ""), msg("b", 2, ""), msg("c", 3, "", "a")},
+			want: []string{"a(c)", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderAll(Thread(tt.msgs))
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeCounts(t *testing.T) {
+	nodes := Thread([]Message{msg("b", 2, "", "x"), msg("c", 5, "", "x")})
+	if len(nodes) != 1 {
+		t.Fatalf("got %d threads, want 1", len(nodes))
+	}
+	if n := nodes[0].Count(); n != 2 {
+		t.Errorf("Count() = %d, want 2", n)
+	}
+	if latest := nodes[0].Latest(); latest.Day() != 5 {
+		t.Errorf("Latest() = %v, want day 5", latest)
+	}
+}
+
+func TestNormalizeSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+		reply   bool
+	}{
+		{"Hello", "hello", false},
+		{"Re: Hello", "hello", true},
+		{"RE: Fwd: hello", "hello", true},
+		{"Re[2]: hello", "hello", true},
+		{"[ml:123] Re: Hello  World", "hello world", true},
+		{"Re: [ml:124] Re: hello", "hello", true},
+		{"AW: Termin", "termin", true},
+		{"返信：会議", "会議", true},
+		{"[announce] Release", "release", false},
+		{"Reply hazy", "reply hazy", false},
+	}
+	for _, tt := range tests {
+		got, reply := NormalizeSubject(tt.subject)
+		if got != tt.want || reply != tt.reply {
+			t.Errorf("NormalizeSubject(%q) = %q, %v, want %q, %v", tt.subject, got, reply, tt.want, tt.reply)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package tnef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/emurenMRz/mboxview/internal/charset"
+)
+
+// The dictionary compressed RTF starts from ([MS-OXRTFCP] 2.1.2.1).
+const rtfPrebuf = "{\\rtf1\\ansi\\mac\\deff0\\deftab720{\\fonttbl;}{\\f0\\fnil \\froman " +
+	"\\fswiss \\fmodern \\fscript \\fdecor MS Sans SerifSymbolArialTimes New RomanCourier" +
+	"{\\colortbl\\red0\\green0\\blue0\r\n\\par \\pard\\plain\\f0\\fs20\\b\\i\\u\\tab\\tx"
+
+const (
+	rtfCompressed   = 0x75465A4C // "LZFu"
+	rtfUncompressed = 0x414C454D // "MELA"
+)
+
+var errBadRTF = errors.New("tnef: malformed compressed RTF")
+
+// DecompressRTF expands the PR_RTF_COMPRESSED property of a message.
+func DecompressRTF(data []byte) ([]byte, error) {
+	if len(data) < 16 {
+		return nil, errBadRTF
+	}
+	compSize := int(binary.LittleEndian.Uint32(data))
+	rawSize := int(binary.LittleEndian.Uint32(data[4:]))
+	body := data[16:]
+	if compSize >= 12 && compSize-12 < len(body) {
+		body = body[:compSize-12]
+	}
+
+	switch binary.LittleEndian.Uint32(data[8:]) {
+	case rtfUncompressed:
+		if rawSize < len(body) {
+			body = body[:rawSize]
+		}
+		return body, nil
+	case rtfCompressed:
+	default:
+		return nil, errBadRTF
+	}
+
+	var dict [4096]byte
+	copy(dict[:], rtfPrebuf)
+	w := len(rtfPrebuf)
+	out := make([]byte, 0, min(rawSize, 4*len(body)+len(rtfPrebuf)))
+	for i := 0; i < len(body); {
+		control := body[i]
+		i++
+		for bit := 0; bit < 8 && i < len(body); bit++ {
+			if control&(1<<bit) == 0 {
+				dict[w] = body[i]
+				w = (w + 1) % len(dict)
+				out = append(out, body[i])
+				i++
+				continue
+			}
+			if i+1 >= len(body) {
+				return nil, errBadRTF
+			}
+			ref := int(body[i])<<8 | int(body[i+1])
+			i += 2
+			offset, length := ref>>4, ref&0xF+2
+			if offset == w {
+				return out, nil
+			}
+			for n := 0; n < length; n++ {
+				c := dict[(offset+n)%len(dict)]
+				dict[w] = c
+				w = (w + 1) % len(dict)
+				out = append(out, c)
+			}
+		}
+	}
+	return out, nil
+}
+
+// IsEncapsulated reports whether RTF only wraps HTML or plain text, as
+// Outlook writes the body of mail it did not compose as rich text.
+func IsEncapsulated(rtf []byte) bool {
+	head := rtf[:min(len(rtf), 1024)]
+	return bytes.Contains(head, []byte(`\fromhtml`)) || bytes.Contains(head, []byte(`\fromtext`))
+}
+
+// RTFToHTML recovers HTML encapsulated in RTF ([MS-OXRTFEX]). It reports
+// false when the RTF does not encapsulate HTML.
+func RTFToHTML(rtf []byte) (string, bool) {
+	if !bytes.Contains(rtf[:min(len(rtf), 1024)], []byte(`\fromhtml`)) {
+		return "", false
+	}
+	return interpretRTF(rtf, true), true
+}
+
+// RTFToText returns the text of RTF, without its formatting.
+func RTFToText(rtf []byte) string {
+	return interpretRTF(rtf, false)
+}
+
+// Destinations whose text is not part of the document.
+var skippedDestinations = map[string]bool{
+	"fonttbl": true, "colortbl": true, "stylesheet": true, "info": true,
+	"pict": true, "object": true, "fldinst": true, "listtable": true,
+	"listoverridetable": true, "rsidtbl": true, "xmlnstbl": true,
+	"header": true, "headerl": true, "headerr": true, "headerf": true,
+	"footer": true, "footerl": true, "footerr": true, "footerf": true,
+	"themedata": true, "colorschememapping": true, "datastore": true,
+	"latentstyles": true, "generator": true, "pgdsctbl": true,
+}
+
+var rtfSymbols = map[string]string{
+	"lquote": "‘", "rquote": "’", "ldblquote": "“", "rdblquote": "”",
+	"bullet": "•", "endash": "–", "emdash": "—", "emspace": " ",
+	"enspace": " ", "qmspace": " ",
+}
+
+type rtfGroup struct {
+	skip    bool // in a destination that is not text
+	htmlrtf bool // in RTF-only text of encapsulated HTML
+	uc      int  // characters standing in for a \u character
+}
+
+// interpretRTF walks RTF and returns its text. With html set, the text is
+// the encapsulated HTML: \htmltag destinations are kept and text marked
+// \htmlrtf is dropped.
+func interpretRTF(rtf []byte, html bool) string {
+	var out strings.Builder
+	var pending []byte // 8-bit text, in the codepage of the document
+	cp := "windows-1252"
+	flush := func() {
+		if len(pending) > 0 {
+			out.WriteString(charset.Decode(pending, cp))
+			pending = pending[:0]
+		}
+	}
+
+	g := rtfGroup{uc: 1}
+	var stack []rtfGroup
+	skipChars := 0 // fallback characters still to drop after \u
+	var high rune  // the first half of a surrogate pair
+	groupStart := false
+	suppressed := func() bool { return g.skip || html && g.htmlrtf }
+	emitByte := func(c byte) {
+		if skipChars > 0 {
+			skipChars--
+			return
+		}
+		if !suppressed() {
+			pending = append(pending, c)
+		}
+	}
+	emit := func(s string) {
+		skipChars = 0
+		if !suppressed() {
+			flush()
+			out.WriteString(s)
+		}
+	}
+
+	for i := 0; i < len(rtf); {
+		c := rtf[i]
+		switch c {
+		case '{':
+			stack = append(stack, g)
+			groupStart = true
+			i++
+			continue
+		case '}':
+			if len(stack) > 0 {
+				g = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			skipChars = 0
+			i++
+		case '\r', '\n':
+			i++
+		case '\\':
+			i++
+			if i >= len(rtf) {
+				break
+			}
+			c = rtf[i]
+			if !isASCIILetter(c) {
+				i++
+				switch c {
+				case '*':
+					// An optional destination; only HTML tags are understood
+					j := i
+					for j < len(rtf) && rtf[j] == ' ' {
+						j++
+					}
+					if !(html && bytes.HasPrefix(rtf[j:], []byte(`\htmltag`))) {
+						g.skip = true
+					}
+				case '\'':
+					if i+2 <= len(rtf) {
+						if v, err := strconv.ParseUint(string(rtf[i:i+2]), 16, 8); err == nil {
+							emitByte(byte(v))
+						}
+						i += 2
+					}
+				case '~':
+					emit(" ")
+				case '_':
+					emit("-")
+				case '{', '}', '\\':
+					emitByte(c)
+				case '\r', '\n':
+					emit("\n")
+				}
+				break
+			}
+
+			start := i
+			for i < len(rtf) && isASCIILetter(rtf[i]) {
+				i++
+			}
+			word := string(rtf[start:i])
+			param, hasParam := 0, false
+			if i < len(rtf) && (rtf[i] == '-' || isDigit(rtf[i])) {
+				start = i
+				i++
+				for i < len(rtf) && isDigit(rtf[i]) {
+					i++
+				}
+				param, _ = strconv.Atoi(string(rtf[start:i]))
+				hasParam = true
+			}
+			if i < len(rtf) && rtf[i] == ' ' {
+				i++
+			}
+
+			if groupStart && skippedDestinations[word] {
+				g.skip = true
+			}
+			switch word {
+			case "par", "line", "sect", "page":
+				emit("\n")
+			case "row":
+				if !html {
+					emit("\n")
+				}
+			case "tab":
+				emit("\t")
+			case "cell":
+				if !html {
+					emit("\t")
+				}
+			case "ansicpg":
+				if name := charsetName(param); name != "" {
+					flush()
+					cp = name
+				}
+			case "uc":
+				g.uc = param
+			case "u":
+				if param < 0 {
+					param += 0x10000
+				}
+				r := rune(param)
+				if utf16.IsSurrogate(r) && r < 0xDC00 {
+					// The first half of a pair, completed by the next \u
+					high = r
+				} else {
+					if high != 0 {
+						r, high = utf16.DecodeRune(high, r), 0
+					}
+					emit(string(r))
+				}
+				skipChars = g.uc
+			case "htmlrtf":
+				g.htmlrtf = !hasParam || param != 0
+			case "bin":
+				i += max(param, 0)
+			default:
+				if s, ok := rtfSymbols[word]; ok {
+					emit(s)
+				}
+			}
+		default:
+			emitByte(c)
+			i++
+		}
+		groupStart = false
+	}
+	flush()
+	return out.String()
+}
+
+func isASCIILetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
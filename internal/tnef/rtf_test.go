@@ -0,0 +1,115 @@
+package tnef
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDecompressRTF(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{
+			// The examples of [MS-OXRTFCP] 3.1
+			name: "literals",
+			data: "2d0000002b0000004c5a4675f1c5c7a703000a007263706731323542320af32068656c090020627705b06c647d0a800fa0",
+			want: "{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n",
+		},
+		{
+			name: "reference to its own output",
+			data: "1a0000001c0000004c5a4675e2d44b51410004205758595a0d6e7d010eb0",
+			want: "{\\rtf1 WXYZWXYZWXYZWXYZWXYZ}",
+		},
+		{
+			name: "uncompressed",
+			data: "160000000a0000004d454c41000000007b5c727466312068697d0a",
+			want: "{\\rtf1 hi}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.data)
+			got, err := DecompressRTF(data)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	for _, data := range []string{
+		"",
+		"1a0000001c0000004c5a4675",
+		"1a0000001c000000585858580000000041",
+		"1300000010000000" + "4c5a4675" + "00000000" + "01ff",
+	} {
+		b, _ := hex.DecodeString(data)
+		if got, err := DecompressRTF(b); err == nil {
+			t.Errorf("DecompressRTF(%s) = %q, want an error", data, got)
+		}
+	}
+}
+
+func TestRTFToText(t *testing.T) {
+	tests := []struct {
+		rtf, want string
+	}{
+		{`{\rtf1\ansi{\fonttbl{\f0\fswiss Arial;}}Hello\par World}`, "Hello\nWorld"},
+		{`{\rtf1\ansi\ansicpg932 \'89\'ef\'8b\'63}`, "会議"},
+		{`{\rtf1\ansi\ansicpg1252 \'e9t\'e9}`, "été"},
+		{`{\rtf1\uc1 caf\u233?}`, "café"},
+		{`{\rtf1\uc0\u233 x}`, "éx"},
+		{`{\rtf1\uc2\u233 XYz}`, "éz"},
+		{`{\rtf1 \u-10179?\u-8704?}`, "😀"},
+		{`{\rtf1 a\~b\_c\{\}\\}`, "a\u00a0b-c{}\\"},
+		{`{\rtf1 \lquote x\rquote\tab y\emdash}`, "‘x’\ty—"},
+		{`{\rtf1{\*\generator Foo;}{\info{\title T}}text}`, "text"},
+		{`{\rtf1 \bin3 abcde}`, "de"},
+		{"{\\rtf1 line\r\nbreak\\\r\nend}", "linebreak\nend"},
+		{`{\rtf1 a\cell b\row}`, "a\tb\n"},
+	}
+	for _, tt := range tests {
+		if got := RTFToText([]byte(tt.rtf)); got != tt.want {
+			t.Errorf("RTFToText(%q) = %q, want %q", tt.rtf, got, tt.want)
+		}
+	}
+}
+
+func TestRTFToHTML(t *testing.T) {
+	rtf := strings.Join([]string{
+		`{\rtf1\ansi\ansicpg1252\fromhtml1 \deff0{\fonttbl{\f0\fswiss Arial;}}`,
+		`{\*\htmltag19 <html>}{\*\htmltag34 <body>}`,
+		`\htmlrtf {\b \htmlrtf0 `,
+		`{\*\htmltag84 <p>}Caf\'e9 \u8364?`,
+		`{\*\htmltag84 &amp;}\htmlrtf &\htmlrtf0 `,
+		`{\*\htmltag84 </p>}\htmlrtf }\htmlrtf0 `,
+		`{\*\htmltag84 <table><tr><td>}a\cell{\*\htmltag84 </td></tr></table>}\row `,
+		`{\*\htmltag35 </body>}{\*\htmltag27 </html>}}`,
+	}, "\r\n")
+	want := "<html><body><p>Café €&amp;</p><table><tr><td>a</td></tr></table></body></html>"
+	got, ok := RTFToHTML([]byte(rtf))
+	if !ok || got != want {
+		t.Errorf("RTFToHTML = %q, %v, want %q", got, ok, want)
+	}
+
+	if _, ok := RTFToHTML([]byte(`{\rtf1\ansi\fromtext text}`)); ok {
+		t.Error("RTFToHTML accepted RTF that does not encapsulate HTML")
+	}
+}
+
+func TestIsEncapsulated(t *testing.T) {
+	tests := []struct {
+		rtf  string
+		want bool
+	}{
+		{`{\rtf1\ansi\fromhtml1 x}`, true},
+		{`{\rtf1\ansi\fromtext x}`, true},
+		{`{\rtf1\ansi x}`, false},
+		{`{\rtf1 ` + strings.Repeat(" ", 1024) + `\fromhtml1}`, false},
+	}
+	for _, tt := range tests {
+		if got := IsEncapsulated([]byte(tt.rtf)); got != tt.want {
+			t.Errorf("IsEncapsulated(%.20q) = %v, want %v", tt.rtf, got, tt.want)
+		}
+	}
+}
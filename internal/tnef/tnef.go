@@ -0,0 +1,395 @@
+// Package tnef decodes Transport Neutral Encapsulation Format data, the
+// winmail.dat attachment Outlook and Exchange send in place of MIME.
+//
+// Decode returns the files attached inside and the message body in the
+// forms TNEF carries it: plain text, HTML and compressed RTF. RTF that
+// encapsulates HTML, as Outlook writes it for HTML mail, can be turned back
+// into HTML with RTFToHTML.
+package tnef
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"unicode/utf16"
+
+	"github.com/emurenMRz/mboxview/internal/charset"
+)
+
+const signature = 0x223E9F78
+
+// Attribute levels and IDs. An ID carries its type in the high word.
+const (
+	levelMessage = 1
+
+	attOemCodepage    = 0x00069007
+	attMessageClass   = 0x00078008
+	attSubject        = 0x00018004
+	attBody           = 0x0002800C
+	attMsgProps       = 0x00069003
+	attAttachRendData = 0x00069002
+	attAttachTitle    = 0x00018010
+	attAttachData     = 0x0006800F
+	attAttachment     = 0x00069005
+)
+
+// MAPI property IDs.
+const (
+	propBody             = 0x1000
+	propRTFCompressed    = 0x1009
+	propHTML             = 0x1013
+	propInternetCodepage = 0x3FDE
+	propDisplayName      = 0x3001
+	propAttachDataBin    = 0x3701
+	propAttachFilename   = 0x3704
+	propAttachMethod     = 0x3705
+	propAttachLongName   = 0x3707
+	propAttachMIMETag    = 0x370E
+	propAttachContentID  = 0x3712
+	propAttachHidden     = 0x7FFE
+
+	attachByValue = 1
+)
+
+// Message is the decoded content of a TNEF stream, its text in UTF-8.
+type Message struct {
+	Class       string // e.g. IPM.Note or IPM.Microsoft Schedule.MtgReq
+	Subject     string
+	Body        string // plain text
+	HTML        string
+	RTF         []byte // decompressed, as written
+	Attachments []Attachment
+}
+
+// Attachment is a file attached inside a TNEF stream.
+type Attachment struct {
+	Name      string
+	MIMEType  string // as Outlook recorded it; may be empty
+	ContentID string
+	Hidden    bool // an inline resource of the HTML body
+	Data      []byte
+}
+
+var errNotTNEF = errors.New("tnef: bad signature")
+
+// IsTNEF reports whether data starts with the TNEF signature.
+func IsTNEF(data []byte) bool {
+	return len(data) >= 6 && binary.LittleEndian.Uint32(data) == signature
+}
+
+// Decode reads a TNEF stream. Attributes it does not need are skipped, and
+// a stream cut short yields what was read before the cut.
+func Decode(data []byte) (*Message, error) {
+	if !IsTNEF(data) {
+		return nil, errNotTNEF
+	}
+	msg := &Message{}
+	// 8-bit strings are in the OEM codepage, which may come after them
+	var codepage, htmlCodepage int
+	var class, subject, body, html []byte
+	var atts []*attachment
+	var att *attachment
+
+	r := &reader{data: data[6:]}
+	for r.remaining() >= 9 {
+		level := r.u8()
+		id := r.u32()
+		length := r.u32()
+		value := r.bytes(int(length))
+		r.u16() // checksum
+		if r.err != nil {
+			break
+		}
+
+		switch {
+		case id == attOemCodepage && len(value) >= 4:
+			codepage = int(binary.LittleEndian.Uint32(value))
+		case level == levelMessage && id == attMessageClass:
+			class = cString(value)
+		case level == levelMessage && id == attSubject:
+			subject = cString(value)
+		case level == levelMessage && id == attBody:
+			body = cString(value)
+		case level == levelMessage && id == attMsgProps:
+			props := parseProps(value)
+			if p, ok := props[propBody]; ok && body == nil {
+				if p.unicode() {
+					msg.Body = p.text()
+				} else {
+					body = cString(p.first())
+				}
+			}
+			if p, ok := props[propHTML]; ok {
+				html = p.first()
+			}
+			if p, ok := props[propRTFCompressed]; ok {
+				if rtf, err := DecompressRTF(p.first()); err == nil {
+					msg.RTF = rtf
+				}
+			}
+			if p, ok := props[propInternetCodepage]; ok {
+				htmlCodepage = int(p.long())
+			}
+		case id == attAttachRendData:
+			att = &attachment{}
+			atts = append(atts, att)
+		case att != nil && id == attAttachTitle:
+			att.title = cString(value)
+		case att != nil && id == attAttachData:
+			att.Data = value
+		case att != nil && id == attAttachment:
+			att.props = parseProps(value)
+		}
+	}
+
+	name := charsetName(codepage)
+	msg.Class = charset.Decode(class, name)
+	msg.Subject = charset.Decode(subject, name)
+	if body != nil {
+		msg.Body = charset.Decode(body, name)
+	}
+	if html != nil {
+		msg.HTML = charset.Decode(html, charsetName(htmlCodepage))
+	}
+	for _, a := range atts {
+		a.resolve(name)
+		if a.Data == nil {
+			// An embedded message or OLE object: nothing we can hand out
+			continue
+		}
+		msg.Attachments = append(msg.Attachments, a.Attachment)
+	}
+	return msg, nil
+}
+
+// attachment is an Attachment as read, before its strings are decoded.
+type attachment struct {
+	Attachment
+	title []byte
+	props map[uint16]prop
+}
+
+func (a *attachment) resolve(name string) {
+	str := func(p prop) string {
+		if p.unicode() {
+			return p.text()
+		}
+		return charset.Decode(cString(p.first()), name)
+	}
+	for _, id := range []uint16{propAttachLongName, propAttachFilename, propDisplayName} {
+		if p, ok := a.props[id]; ok && a.Name == "" {
+			a.Name = str(p)
+		}
+	}
+	if a.Name == "" {
+		a.Name = charset.Decode(a.title, name)
+	}
+	if p, ok := a.props[propAttachMIMETag]; ok {
+		a.MIMEType = str(p)
+	}
+	if p, ok := a.props[propAttachContentID]; ok {
+		a.ContentID = str(p)
+	}
+	if p, ok := a.props[propAttachHidden]; ok {
+		a.Hidden = p.long() != 0
+	}
+	if p, ok := a.props[propAttachMethod]; ok && p.long() == attachByValue && a.Data == nil {
+		if d, ok := a.props[propAttachDataBin]; ok {
+			a.Data = d.first()
+		}
+	}
+}
+
+// charsetName returns the charset name of a Windows codepage, or "" for
+// codepages it does not know.
+func charsetName(codepage int) string {
+	switch {
+	case codepage == 65001:
+		return "utf-8"
+	case codepage == 20127:
+		return "us-ascii"
+	case codepage == 932, codepage == 936, codepage == 949, codepage == 950:
+		return "cp" + strconv.Itoa(codepage)
+	case codepage >= 50220 && codepage <= 50222:
+		return "iso-2022-jp"
+	case codepage == 51932, codepage == 20932:
+		return "euc-jp"
+	case codepage >= 28591 && codepage <= 28605:
+		return "iso-8859-" + strconv.Itoa(codepage-28590)
+	case codepage >= 1250 && codepage <= 1258:
+		return "windows-" + strconv.Itoa(codepage)
+	case codepage == 437, codepage == 850, codepage == 852, codepage == 866:
+		return "ibm" + strconv.Itoa(codepage)
+	}
+	return ""
+}
+
+// cString returns an 8-bit string up to its terminating NUL.
+func cString(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
+
+// reader reads little-endian values, remembering the first overrun.
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+var errShort = errors.New("tnef: truncated data")
+
+func (r *reader) remaining() int { return len(r.data) - r.pos }
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > r.remaining() {
+		r.err = errShort
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) skip(n int) { r.bytes(n) }
+
+func (r *reader) u8() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// MAPI property types.
+const (
+	ptShort     = 0x0002
+	ptLong      = 0x0003
+	ptFloat     = 0x0004
+	ptDouble    = 0x0005
+	ptCurrency  = 0x0006
+	ptAppTime   = 0x0007
+	ptError     = 0x000A
+	ptBoolean   = 0x000B
+	ptObject    = 0x000D
+	ptI8        = 0x0014
+	ptString8   = 0x001E
+	ptUnicode   = 0x001F
+	ptSysTime   = 0x0040
+	ptCLSID     = 0x0048
+	ptBinary    = 0x0102
+	mvFlag      = 0x1000
+	namedPropID = 0x8000
+)
+
+// prop is a MAPI property value; multi-valued properties have several.
+type prop struct {
+	typ    uint16
+	values [][]byte
+}
+
+func (p prop) first() []byte {
+	if len(p.values) == 0 {
+		return nil
+	}
+	return p.values[0]
+}
+
+func (p prop) long() uint32 {
+	if v := p.first(); len(v) >= 4 {
+		return binary.LittleEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (p prop) unicode() bool {
+	return p.typ&^mvFlag == ptUnicode
+}
+
+// text returns a UTF-16 string property in UTF-8, without its terminator.
+func (p prop) text() string {
+	v := p.first()
+	units := make([]uint16, 0, len(v)/2)
+	for i := 0; i+1 < len(v); i += 2 {
+		u := binary.LittleEndian.Uint16(v[i:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units))
+}
+
+// parseProps reads a MAPI property list. Named properties are read past but
+// not returned; reading stops at the first malformed property.
+func parseProps(data []byte) map[uint16]prop {
+	props := map[uint16]prop{}
+	r := &reader{data: data}
+	count := r.u32()
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		typ := r.u16()
+		id := r.u16()
+		if id >= namedPropID {
+			r.skip(16) // GUID
+			if kind := r.u32(); kind == 0 {
+				r.skip(4)
+			} else {
+				n := int(r.u32())
+				r.skip(pad4(n))
+			}
+		}
+
+		base := typ &^ mvFlag
+		n := uint32(1)
+		if typ&mvFlag != 0 || base == ptString8 || base == ptUnicode || base == ptBinary || base == ptObject {
+			n = r.u32()
+		}
+		p := prop{typ: typ}
+		for j := uint32(0); j < n && r.err == nil; j++ {
+			var v []byte
+			switch base {
+			case ptString8, ptUnicode, ptBinary, ptObject:
+				size := int(r.u32())
+				v = r.bytes(size)
+				r.skip(pad4(size) - size)
+			case ptShort, ptLong, ptFloat, ptError, ptBoolean:
+				v = r.bytes(4)
+			case ptDouble, ptCurrency, ptAppTime, ptI8, ptSysTime:
+				v = r.bytes(8)
+			case ptCLSID:
+				v = r.bytes(16)
+			default:
+				v = r.bytes(4)
+			}
+			p.values = append(p.values, v)
+		}
+		if r.err != nil {
+			break
+		}
+		if id < namedPropID {
+			props[id] = p
+		}
+	}
+	return props
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
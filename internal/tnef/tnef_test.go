@@ -0,0 +1,208 @@
+package tnef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+	"unicode/utf16"
+)
+
+// stream builds a TNEF stream for the tests.
+type stream struct{ bytes.Buffer }
+
+func newStream() *stream {
+	s := &stream{}
+	binary.Write(s, binary.LittleEndian, uint32(signature))
+	binary.Write(s, binary.LittleEndian, uint16(0x0001)) // key
+	return s
+}
+
+func (s *stream) attr(level byte, id uint32, value []byte) *stream {
+	s.WriteByte(level)
+	binary.Write(s, binary.LittleEndian, id)
+	binary.Write(s, binary.LittleEndian, uint32(len(value)))
+	s.Write(value)
+	var sum uint16
+	for _, c := range value {
+		sum += uint16(c)
+	}
+	binary.Write(s, binary.LittleEndian, sum)
+	return s
+}
+
+func u32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+func utf16z(s string) []byte {
+	var b []byte
+	for _, u := range append(utf16.Encode([]rune(s)), 0) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// props builds a MAPI property list.
+type props struct {
+	count uint32
+	buf   []byte
+}
+
+func (p *props) add(typ, id uint16, value []byte) *props {
+	p.count++
+	p.buf = binary.LittleEndian.AppendUint16(p.buf, typ)
+	p.buf = binary.LittleEndian.AppendUint16(p.buf, id)
+	switch typ {
+	case ptString8, ptUnicode, ptBinary, ptObject:
+		p.buf = append(p.buf, u32(1)...)
+		p.buf = append(p.buf, u32(uint32(len(value)))...)
+		p.buf = append(p.buf, value...)
+		p.buf = append(p.buf, make([]byte, pad4(len(value))-len(value))...)
+	default:
+		p.buf = append(p.buf, value...)
+	}
+	return p
+}
+
+// named adds a named string property, which Decode reads past.
+func (p *props) named(name string, value []byte) *props {
+	p.count++
+	p.buf = binary.LittleEndian.AppendUint16(p.buf, ptString8)
+	p.buf = binary.LittleEndian.AppendUint16(p.buf, 0x8001)
+	p.buf = append(p.buf, make([]byte, 16)...) // GUID
+	p.buf = append(p.buf, u32(1)...)           // named by string
+	n := utf16z(name)
+	p.buf = append(p.buf, u32(uint32(len(n)))...)
+	p.buf = append(p.buf, n...)
+	p.buf = append(p.buf, make([]byte, pad4(len(n))-len(n))...)
+	p.buf = append(p.buf, u32(1)...)
+	p.buf = append(p.buf, u32(uint32(len(value)))...)
+	p.buf = append(p.buf, value...)
+	p.buf = append(p.buf, make([]byte, pad4(len(value))-len(value))...)
+	return p
+}
+
+func (p *props) bytes() []byte {
+	return append(u32(p.count), p.buf...)
+}
+
+func testStream() *stream {
+	rtf := append([]byte{22, 0, 0, 0, 10, 0, 0, 0, 'M', 'E', 'L', 'A', 0, 0, 0, 0}, `{\rtf1 hi}`...)
+	msgProps := (&props{}).
+		named("X-Custom", []byte("ignored\x00")).
+		add(ptUnicode, propBody, utf16z("本文")).
+		add(ptBinary, propHTML, []byte("<p>\x89\xef\x8b\x63</p>")).
+		add(ptLong, propInternetCodepage, u32(932)).
+		add(ptBinary, propRTFCompressed, rtf)
+
+	s := newStream()
+	s.attr(levelMessage, attMessageClass, []byte("IPM.Note\x00"))
+	s.attr(levelMessage, attSubject, []byte("\x89\xef\x8b\x63\x00"))
+	s.attr(levelMessage, attOemCodepage, append(u32(932), u32(0)...))
+	s.attr(levelMessage, attMsgProps, msgProps.bytes())
+
+	// An attachment with the data and title as attributes
+	s.attr(2, attAttachRendData, make([]byte, 14))
+	s.attr(2, attAttachTitle, []byte("REPORT~1.TXT\x00"))
+	s.attr(2, attAttachData, []byte("hello"))
+	s.attr(2, attAttachment, (&props{}).
+		add(ptUnicode, propAttachLongName, utf16z("報告書.txt")).
+		add(ptString8, propAttachMIMETag, []byte("text/plain\x00")).
+		bytes())
+
+	// An inline image with its data in the properties
+	s.attr(2, attAttachRendData, make([]byte, 14))
+	s.attr(2, attAttachment, (&props{}).
+		add(ptLong, propAttachMethod, u32(attachByValue)).
+		add(ptString8, propAttachFilename, []byte("\x89\xe6\x91\x9c.png\x00")).
+		add(ptString8, propAttachContentID, []byte("img1@example.com\x00")).
+		add(ptBoolean, propAttachHidden, u32(1)).
+		add(ptBinary, propAttachDataBin, []byte("\x89PNG")).
+		bytes())
+
+	// An embedded message: no data to hand out
+	s.attr(2, attAttachRendData, make([]byte, 14))
+	s.attr(2, attAttachTitle, []byte("Forwarded\x00"))
+	return s
+}
+
+func TestDecode(t *testing.T) {
+	msg, err := Decode(testStream().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Class != "IPM.Note" || msg.Subject != "会議" || msg.Body != "本文" || msg.HTML != "<p>会議</p>" || string(msg.RTF) != `{\rtf1 hi}` {
+		t.Errorf("message = %+v", msg)
+	}
+	want := []Attachment{
+		{Name: "報告書.txt", MIMEType: "text/plain", Data: []byte("hello")},
+		{Name: "画像.png", ContentID: "img1@example.com", Hidden: true, Data: []byte("\x89PNG")},
+	}
+	if !slices.EqualFunc(msg.Attachments, want, func(a, b Attachment) bool {
+		return a.Name == b.Name && a.MIMEType == b.MIMEType && a.ContentID == b.ContentID && a.Hidden == b.Hidden && bytes.Equal(a.Data, b.Data)
+	}) {
+		t.Errorf("attachments = %+v, want %+v", msg.Attachments, want)
+	}
+}
+
+func TestDecodeBodyAttribute(t *testing.T) {
+	// attBody is 8-bit text in the OEM codepage, which comes after it
+	s := newStream()
+	s.attr(levelMessage, attBody, []byte("caf\xe9\x00"))
+	s.attr(levelMessage, attMsgProps, (&props{}).add(ptUnicode, propBody, utf16z("ignored")).bytes())
+	s.attr(levelMessage, attOemCodepage, u32(1252))
+	msg, err := Decode(s.Bytes())
+	if err != nil || msg.Body != "café" {
+		t.Errorf("got %+v, %v, want body café", msg, err)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	data := testStream().Bytes()
+	// Cut inside the first attachment's data attribute
+	cut := bytes.Index(data, []byte("hello")) + 2
+	msg, err := Decode(data[:cut])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "会議" || len(msg.Attachments) != 0 {
+		t.Errorf("got subject %q and %d attachments", msg.Subject, len(msg.Attachments))
+	}
+}
+
+func TestDecodeNotTNEF(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("winmail"), u32(signature)} {
+		if IsTNEF(data) {
+			t.Errorf("IsTNEF(%q) = true", data)
+		}
+		if _, err := Decode(data); err == nil {
+			t.Errorf("Decode(%q) succeeded", data)
+		}
+	}
+}
+
+func TestCharsetName(t *testing.T) {
+	tests := []struct {
+		codepage int
+		want     string
+	}{
+		{65001, "utf-8"},
+		{20127, "us-ascii"},
+		{932, "cp932"},
+		{936, "cp936"},
+		{50220, "iso-2022-jp"},
+		{51932, "euc-jp"},
+		{28591, "iso-8859-1"},
+		{28605, "iso-8859-15"},
+		{1252, "windows-1252"},
+		{850, "ibm850"},
+		{0, ""},
+		{1200, ""},
+	}
+	for _, tt := range tests {
+		if got := charsetName(tt.codepage); got != tt.want {
+			t.Errorf("charsetName(%d) = %q, want %q", tt.codepage, got, tt.want)
+		}
+	}
+}
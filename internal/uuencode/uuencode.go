@@ -0,0 +1,180 @@
+// Package uuencode extracts files embedded in plain text: uuencoded blocks
+// ("begin 644 name" ... "end"), their base64 variant written by
+// "uuencode -m", and yEnc blocks ("=ybegin" ... "=yend"), uuencode's
+// successor on Usenet.
+package uuencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"hash/crc32"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// File is a file found in text.
+type File struct {
+	Name string // without any directory
+	Data []byte
+}
+
+var (
+	beginRegex  = regexp.MustCompile(`^begin(-base64)? +[0-7]{3,4} +(\S.*)$`)
+	ybeginRegex = regexp.MustCompile(`^=ybegin .*\bname=(.+)$`)
+	yParamRegex = regexp.MustCompile(`\b(size|crc32|pcrc32)=([0-9A-Fa-f]+)`)
+)
+
+// Extract returns text without the files encoded in it, and the files.
+// Blocks that do not decode cleanly, or lack their end line, are left in
+// the text.
+func Extract(text []byte) ([]byte, []File) {
+	if !bytes.Contains(text, []byte("begin")) {
+		return text, nil
+	}
+	lines := bytes.SplitAfter(text, []byte("\n"))
+	var rest []byte
+	var files []File
+	for i := 0; i < len(lines); i++ {
+		line := trimEOL(lines[i])
+		var f File
+		var n int
+		ok := false
+		if m := beginRegex.FindSubmatch(line); m != nil {
+			f.Name = baseName(string(m[2]))
+			if len(m[1]) > 0 {
+				f.Data, n, ok = decodeBase64(lines[i+1:])
+			} else {
+				f.Data, n, ok = decodeUU(lines[i+1:])
+			}
+		} else if m := ybeginRegex.FindSubmatch(line); m != nil {
+			f.Name = baseName(string(m[1]))
+			f.Data, n, ok = decodeYEnc(lines[i+1:])
+		}
+		if !ok || f.Name == "" {
+			rest = append(rest, lines[i]...)
+			continue
+		}
+		files = append(files, f)
+		i += n
+	}
+	return rest, files
+}
+
+// decodeUU decodes uuencoded lines up to the "end" line, returning the
+// data and the number of lines read, end included.
+func decodeUU(lines [][]byte) ([]byte, int, bool) {
+	var data []byte
+	for i, raw := range lines {
+		line := trimEOL(raw)
+		if string(bytes.TrimSpace(line)) == "end" {
+			return data, i + 1, true
+		}
+		if len(line) == 0 {
+			return nil, 0, false
+		}
+		n := int(line[0]-' ') & 63
+		if n == 0 {
+			continue // the empty line before "end", "`" or " "
+		}
+		chars := (n + 2) / 3 * 4
+		body := line[1:]
+		// Trailing spaces, which stand for zero bits, are often trimmed,
+		// and some encoders add a checksum character
+		if len(body) > chars+1 || len(body) < (n*4+2)/3 {
+			return nil, 0, false
+		}
+		decoded := make([]byte, 0, chars/4*3)
+		for j := 0; j < chars; j += 4 {
+			var g [4]byte
+			for k := range g {
+				c := byte(' ')
+				if j+k < len(body) {
+					c = body[j+k]
+				}
+				if c < ' ' || c > '`' {
+					return nil, 0, false
+				}
+				g[k] = (c - ' ') & 63
+			}
+			decoded = append(decoded, g[0]<<2|g[1]>>4, g[1]<<4|g[2]>>2, g[2]<<6|g[3])
+		}
+		data = append(data, decoded[:n]...)
+	}
+	return nil, 0, false
+}
+
+// decodeBase64 decodes the lines of "uuencode -m" up to its "====" line.
+func decodeBase64(lines [][]byte) ([]byte, int, bool) {
+	var encoded []byte
+	for i, raw := range lines {
+		line := bytes.TrimSpace(raw)
+		if string(line) == "====" {
+			data, err := base64.StdEncoding.DecodeString(string(encoded))
+			if err != nil {
+				return nil, 0, false
+			}
+			return data, i + 1, true
+		}
+		encoded = append(encoded, line...)
+	}
+	return nil, 0, false
+}
+
+// decodeYEnc decodes yEnc lines up to the "=yend" line. A size or CRC in
+// that line that does not match the data fails the block. A part of a
+// multipart post is returned on its own.
+func decodeYEnc(lines [][]byte) ([]byte, int, bool) {
+	var data []byte
+	part := false
+	for i, raw := range lines {
+		line := trimEOL(raw)
+		if bytes.HasPrefix(line, []byte("=ypart ")) {
+			part = true
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("=yend")) {
+			params := map[string]string{}
+			for _, m := range yParamRegex.FindAllSubmatch(line, -1) {
+				params[string(m[1])] = string(m[2])
+			}
+			if s, ok := params["size"]; ok && s != strconv.Itoa(len(data)) {
+				return nil, 0, false
+			}
+			// crc32 of a part is that of the whole file
+			crc, ok := params["pcrc32"]
+			if !ok && !part {
+				crc, ok = params["crc32"]
+			}
+			if ok {
+				want, err := strconv.ParseUint(crc, 16, 32)
+				if err != nil || uint32(want) != crc32.ChecksumIEEE(data) {
+					return nil, 0, false
+				}
+			}
+			return data, i + 1, true
+		}
+		for j := 0; j < len(line); j++ {
+			c := line[j]
+			if c == '=' && j+1 < len(line) {
+				j++
+				c = line[j] - 64
+			}
+			data = append(data, c-42)
+		}
+	}
+	return nil, 0, false
+}
+
+func trimEOL(line []byte) []byte {
+	return bytes.TrimRight(line, "\r\n")
+}
+
+// baseName drops the directory, Unix or DOS, from a file name.
+func baseName(name string) string {
+	name = strings.TrimSpace(strings.Trim(strings.TrimSpace(name), `"`))
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
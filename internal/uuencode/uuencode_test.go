@@ -0,0 +1,168 @@
+package uuencode
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+// uu encodes data as uuencode does, "`" standing for zero bits.
+func uu(data []byte) string {
+	var b strings.Builder
+	enc := func(c byte) byte {
+		if c == 0 {
+			return '`'
+		}
+		return c + ' '
+	}
+	for len(data) > 0 {
+		n := min(len(data), 45)
+		line := data[:n]
+		data = data[n:]
+		b.WriteByte(enc(byte(n)))
+		for i := 0; i < n; i += 3 {
+			var g [3]byte
+			copy(g[:], line[i:])
+			b.Write([]byte{enc(g[0] >> 2), enc(g[0]<<4&63 | g[1]>>4), enc(g[1]<<2&63 | g[2]>>6), enc(g[2] & 63)})
+		}
+		b.WriteString("\n")
+	}
+	return b.String() + "`\n"
+}
+
+// yEnc encodes data in lines of up to width characters.
+func yEnc(data []byte, width int) string {
+	var b strings.Builder
+	col := 0
+	for _, c := range data {
+		c += 42
+		switch c {
+		case 0, '\n', '\r', '=':
+			b.WriteByte('=')
+			c += 64
+			col++
+		}
+		b.WriteByte(c)
+		if col++; col >= width {
+			b.WriteString("\n")
+			col = 0
+		}
+	}
+	if col > 0 {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func allBytes() []byte {
+	data := make([]byte, 300)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func TestExtract(t *testing.T) {
+	data := allBytes()
+	crc := crc32.ChecksumIEEE(data)
+	tests := []struct {
+		name  string
+		text  string
+		rest  string
+		files []File
+	}{
+		{
+			name:  "uuencode",
+			text:  "Here it is.\n\nbegin 644 /tmp/all.bin\n" + uu(data) + "end\nBye.\n",
+			rest:  "Here it is.\n\nBye.\n",
+			files: []File{{"all.bin", data}},
+		},
+		{
+			name:  "uuencode with CRLF",
+			text:  strings.ReplaceAll("begin 0644 all.bin\n"+uu(data)+"end\nBye.\n", "\n", "\r\n"),
+			rest:  "Bye.\r\n",
+			files: []File{{"all.bin", data}},
+		},
+		{
+			name:  "trailing spaces trimmed",
+			text:  "begin 644 ab.txt\n\"86(\n \nend\n",
+			files: []File{{"ab.txt", []byte("ab")}},
+		},
+		{
+			name:  "uuencode -m",
+			text:  "begin-base64 644 C:\\Temp\\hello.txt\naGVsbG8s\nIHdvcmxk\n====\n",
+			files: []File{{"hello.txt", []byte("hello, world")}},
+		},
+		{
+			name:  "yEnc",
+			text:  "=ybegin line=128 size=300 name=all bin.dat\n" + yEnc(data, 128) + fmt.Sprintf("=yend size=300 crc32=%08x\n", crc),
+			files: []File{{"all bin.dat", data}},
+		},
+		{
+			name: "yEnc part",
+			text: "=ybegin part=1 total=2 line=128 size=600 name=big.bin\n=ypart begin=1 end=300\n" + yEnc(data, 128) +
+				fmt.Sprintf("=yend size=300 part=1 pcrc32=%08x crc32=00000000\n", crc),
+			files: []File{{"big.bin", data}},
+		},
+		{
+			name:  "two files",
+			text:  "begin 644 a\n" + uu([]byte("A")) + "end\n-- \n=ybegin size=1 name=b\n" + yEnc([]byte("B"), 128) + "=yend size=1\n",
+			rest:  "-- \n",
+			files: []File{{"a", []byte("A")}, {"b", []byte("B")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rest, files := Extract([]byte(tt.text))
+			if string(rest) != tt.rest {
+				t.Errorf("rest = %q, want %q", rest, tt.rest)
+			}
+			if len(files) != len(tt.files) {
+				t.Fatalf("got %d files, want %d", len(files), len(tt.files))
+			}
+			for i, f := range files {
+				if f.Name != tt.files[i].Name || !bytes.Equal(f.Data, tt.files[i].Data) {
+					t.Errorf("file %d = %q (%d bytes), want %q (%d bytes)", i, f.Name, len(f.Data), tt.files[i].Name, len(tt.files[i].Data))
+				}
+			}
+		})
+	}
+}
+
+// Text that only looks like an encoded block is left as it is.
+func TestExtractLeavesText(t *testing.T) {
+	data := []byte("some data")
+	for _, text := range []string{
+		"Let us begin the meeting.\n",
+		"begin 644 the meeting agenda\nFirst, the budget.\nend\n",
+		"begin 644 file.bin\n" + uu(data), // no end line
+		"begin 644 file.bin\n" + strings.Replace(uu(data), "\n", "\n\n", 1) + "end\n",
+		"begin-base64 644 file.bin\nnot base64!\n====\n",
+		"begin-base64 644 file.bin\naGVsbG8=\n",
+		"=ybegin size=9 name=file.bin\n" + yEnc(data, 128) + "=yend size=10\n",
+		"=ybegin size=9 name=file.bin\n" + yEnc(data, 128) + "=yend size=9 crc32=12345678\n",
+		"=ybegin size=9 name=file.bin\n" + yEnc(data, 128),
+	} {
+		rest, files := Extract([]byte(text))
+		if string(rest) != text || len(files) != 0 {
+			t.Errorf("Extract(%q) = %q, %d files", text, rest, len(files))
+		}
+	}
+}
+
+func TestBaseName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"file.txt", "file.txt"},
+		{`"quoted name.txt"`, "quoted name.txt"},
+		{"/home/user/file.txt", "file.txt"},
+		{`C:\Documents\file.txt`, "file.txt"},
+		{"dir/", ""},
+	}
+	for _, tt := range tests {
+		if got := baseName(tt.name); got != tt.want {
+			t.Errorf("baseName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
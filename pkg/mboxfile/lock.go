@@ -0,0 +1,237 @@
+package mboxfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockMethod selects how an mbox file is locked. Methods can be combined;
+// all selected locks are taken, dotlock first.
+type LockMethod int
+
+const (
+	// LockDotlock creates a "<mbox>.lock" file next to the mailbox, the
+	// convention used by mail delivery agents and most MUAs.
+	LockDotlock LockMethod = 1 << iota
+	// LockFlock takes a flock(2) lock on the mbox file.
+	LockFlock
+	// LockFcntl takes a POSIX fcntl(2) record lock on the mbox file. These
+	// locks belong to the process, so closing any descriptor of the file
+	// releases them; prefer LockFlock in long-running programs.
+	LockFcntl
+
+	// DefaultLockMethods is used when LockOptions.Methods is zero.
+	DefaultLockMethods = LockDotlock | LockFlock
+	// LockNone disables locking.
+	LockNone LockMethod = -1
+)
+
+const (
+	// DefaultLockTimeout is used when LockOptions.Timeout is zero.
+	DefaultLockTimeout = 30 * time.Second
+	// DefaultStaleAge is used when LockOptions.StaleAge is zero.
+	DefaultStaleAge = 5 * time.Minute
+
+	lockRetryInterval = 100 * time.Millisecond
+)
+
+// ErrLockTimeout is returned when a lock could not be acquired in time.
+var ErrLockTimeout = errors.New("timed out waiting for mbox lock")
+
+// LockOptions configures LockFile.
+type LockOptions struct {
+	Methods  LockMethod
+	Timeout  time.Duration // how long to keep retrying
+	StaleAge time.Duration // dotlock files older than this are removed
+	Shared   bool          // take a shared (read) lock; dotlocks are skipped
+}
+
+// Lock is a held lock on an mbox file.
+type Lock struct {
+	file    *os.File // descriptor holding the flock/fcntl lock
+	dotlock string   // path of the dotlock file we created
+}
+
+// ParseLockMethods parses a comma separated list of "dotlock", "flock" and
+// "fcntl", or "none". An empty string selects DefaultLockMethods.
+func ParseLockMethods(s string) (LockMethod, error) {
+	if s == "" {
+		return DefaultLockMethods, nil
+	}
+	if s == "none" {
+		return LockNone, nil
+	}
+	var m LockMethod
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "dotlock":
+			m |= LockDotlock
+		case "flock":
+			m |= LockFlock
+		case "fcntl":
+			m |= LockFcntl
+		default:
+			return 0, fmt.Errorf("unknown lock method %q", name)
+		}
+	}
+	return m, nil
+}
+
+// LockFile locks the mbox file at path, retrying until opts.Timeout expires.
+// The file must exist; callers that deliver to a new mailbox create it
+// first.
+//
+// Kernel locks are verified to still refer to path after they are acquired,
+// so a writer that replaced the file by renaming a new one over it does not
+// leave waiters holding a lock on the unlinked original.
+func LockFile(path string, opts LockOptions) (*Lock, error) {
+	if opts.Methods == 0 {
+		opts.Methods = DefaultLockMethods
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultLockTimeout
+	}
+	if opts.StaleAge == 0 {
+		opts.StaleAge = DefaultStaleAge
+	}
+	l := &Lock{}
+	if opts.Methods == LockNone {
+		return l, nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(opts.Timeout)
+
+	if opts.Methods&LockDotlock != 0 && !opts.Shared {
+		if err := l.lockDotlock(path, deadline, opts.StaleAge); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Methods&(LockFlock|LockFcntl) != 0 {
+		if err := l.lockKernel(path, deadline, opts); err != nil {
+			l.Unlock()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Unlock releases every lock held by l.
+func (l *Lock) Unlock() error {
+	var firstErr error
+	if l.file != nil {
+		// Closing the descriptor releases flock and fcntl locks
+		if err := l.file.Close(); err != nil {
+			firstErr = err
+		}
+		l.file = nil
+	}
+	if l.dotlock != "" {
+		if err := os.Remove(l.dotlock); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		l.dotlock = ""
+	}
+	return firstErr
+}
+
+func (l *Lock) lockDotlock(path string, deadline time.Time, staleAge time.Duration) error {
+	lockPath := path + ".lock"
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			l.dotlock = lockPath
+			return nil
+		}
+		if errors.Is(err, os.ErrPermission) {
+			// The spool directory is not writable for us (e.g. /var/mail
+			// without setgid mail); rely on the kernel locks instead.
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("creating %s: %w", lockPath, err)
+		}
+
+		if isStaleDotlock(lockPath, staleAge) {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s", ErrLockTimeout, lockPath)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// isStaleDotlock reports whether the dotlock at lockPath was left behind:
+// it is older than staleAge or names a process that no longer exists.
+func isStaleDotlock(lockPath string, staleAge time.Duration) bool {
+	fi, err := os.Stat(lockPath)
+	if err != nil {
+		return false
+	}
+	if time.Since(fi.ModTime()) > staleAge {
+		return true
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+	return !processAlive(pid)
+}
+
+func (l *Lock) lockKernel(path string, deadline time.Time, opts LockOptions) error {
+	flag := os.O_RDWR
+	if opts.Shared {
+		flag = os.O_RDONLY
+	}
+	for {
+		f, err := os.OpenFile(path, flag, 0660)
+		if err != nil {
+			return err
+		}
+		locked, err := tryKernelLock(f, opts.Methods, opts.Shared)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("locking %s: %w", path, err)
+		}
+		if locked {
+			if sameFile(f, path) {
+				l.file = f
+				return nil
+			}
+			// The file was replaced while we waited; lock the new one.
+			f.Close()
+			continue
+		}
+		f.Close()
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s", ErrLockTimeout, path)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func sameFile(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, pi)
+}
@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly)
+
+package mboxfile
+
+import "os"
+
+// tryKernelLock is a no-op on platforms without flock/fcntl; only dotlocks
+// protect the mailbox there.
+func tryKernelLock(f *os.File, methods LockMethod, shared bool) (bool, error) {
+	return true, nil
+}
+
+// processAlive cannot be checked portably; stale dotlocks are then only
+// detected by age.
+func processAlive(pid int) bool {
+	return true
+}
@@ -0,0 +1,52 @@
+package mboxfile
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockFileMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.mbox")
+	for _, methods := range []LockMethod{LockDotlock, LockFlock, LockDotlock | LockFlock} {
+		l, err := LockFile(path, LockOptions{Methods: methods})
+		if err == nil {
+			l.Unlock()
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("methods %d: got %v, want a not-exist error", methods, err)
+		}
+		for _, p := range []string{path, path + ".lock"} {
+			if _, err := os.Stat(p); err == nil {
+				t.Errorf("methods %d: %s was created", methods, filepath.Base(p))
+			}
+		}
+	}
+}
+
+func TestLockFileExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "box.mbox")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := LockFile(path, LockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Errorf("no dotlock: %v", err)
+	}
+	if _, err := LockFile(path, LockOptions{Timeout: lockRetryInterval}); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("second lock: got %v, want ErrLockTimeout", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = LockFile(path, LockOptions{Methods: LockFlock, Timeout: lockRetryInterval})
+	if err != nil {
+		t.Fatalf("lock after Unlock: %v", err)
+	}
+	l.Unlock()
+}
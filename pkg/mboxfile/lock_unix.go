@@ -0,0 +1,45 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package mboxfile
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryKernelLock attempts the selected flock/fcntl locks on f without
+// blocking. It returns false when another process holds a conflicting lock.
+func tryKernelLock(f *os.File, methods LockMethod, shared bool) (bool, error) {
+	fd := int(f.Fd())
+	if methods&LockFcntl != 0 {
+		lk := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: 0}
+		if shared {
+			lk.Type = syscall.F_RDLCK
+		}
+		if err := syscall.FcntlFlock(uintptr(fd), syscall.F_SETLK, &lk); err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	if methods&LockFlock != 0 {
+		how := syscall.LOCK_EX
+		if shared {
+			how = syscall.LOCK_SH
+		}
+		if err := syscall.Flock(fd, how|syscall.LOCK_NB); err != nil {
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
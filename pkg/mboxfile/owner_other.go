@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly)
+
+package mboxfile
+
+import (
+	"io/fs"
+	"os"
+)
+
+// copyOwner is a no-op on platforms without Unix file owners.
+func copyOwner(f *os.File, fi fs.FileInfo) {}
@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package mboxfile
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// copyOwner gives f the owner and group of fi. Only root may give a file
+// away, so failures are ignored: the group is kept where the caller is a
+// member of it, and nothing changes otherwise.
+func copyOwner(f *os.File, fi fs.FileInfo) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	if f.Chown(int(st.Uid), int(st.Gid)) != nil {
+		f.Chown(-1, int(st.Gid))
+	}
+}
@@ -0,0 +1,313 @@
+// Package mboxfile reads mbox files as a stream of messages.
+//
+// Messages are separated by lines starting with "From ". Nothing is loaded
+// into memory beyond the header block of the current message, so files of
+// any size can be processed.
+package mboxfile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"iter"
+	"strings"
+)
+
+// ErrInvalidFormat is yielded when data that is not an envelope line appears
+// before the first message.
+var ErrInvalidFormat = errors.New("invalid mbox format")
+
+var (
+	envelopePrefix = []byte("From ")
+	escapedPrefix  = []byte(">From ")
+)
+
+// Message is a single message yielded by Reader.All.
+//
+// Body and RawBody are two views of the same underlying stream; only one of
+// them may be read, and only until the iteration advances.
+type Message struct {
+	Envelope  string // "From " line without the line ending
+	Header    []byte // raw header block as stored, without the blank separator line
+	Start     int64  // offset of the envelope line
+	BodyStart int64  // offset of the first body line
+	End       int64  // offset just past the message; valid once the body is consumed
+
+	eol   string // line ending of the envelope line
+	blank string // the blank line ending the header block, if there was one
+	body  *bodyReader
+}
+
+// Body returns the message body with ">From " escaping removed and the
+// blank line separating it from the next message dropped.
+func (m *Message) Body() io.Reader {
+	m.body.unescape = true
+	return m.body
+}
+
+// RawBody returns the message body exactly as stored in the file.
+func (m *Message) RawBody() io.Reader {
+	m.body.unescape = false
+	return m.body
+}
+
+// Content returns the RFC 5322 message: the header block and the blank line
+// after it as stored, then the unescaped body. It is suitable for
+// net/mail.ReadMessage, and apart from the ">From " quoting it is the
+// message as it was delivered.
+func (m *Message) Content() io.Reader {
+	var sep string
+	if len(m.Header) > 0 && m.Header[len(m.Header)-1] != '\n' {
+		sep = m.newline()
+	}
+	sep += m.separator()
+	return io.MultiReader(bytes.NewReader(m.Header), strings.NewReader(sep), m.Body())
+}
+
+// separator returns the blank line ending the header block, or a new one
+// when the file had none.
+func (m *Message) separator() string {
+	if m.blank != "" {
+		return m.blank
+	}
+	return m.newline()
+}
+
+// newline returns the line ending the message was read with.
+func (m *Message) newline() string {
+	if m.eol != "" {
+		return m.eol
+	}
+	return "\n"
+}
+
+// Reader reads messages from an mbox stream.
+type Reader struct {
+	br          *bufio.Reader
+	off         int64  // offset of the next byte read from br
+	line        []byte // line read ahead but not consumed yet
+	atLineStart bool
+	err         error
+}
+
+// NewReader returns a Reader for the mbox data in r.
+func NewReader(r io.Reader) *Reader {
+	return NewReaderOffset(r, 0)
+}
+
+// NewReaderOffset returns a Reader for r whose first byte sits at offset
+// base in the mbox file. Offsets reported in Message are relative to the file.
+func NewReaderOffset(r io.Reader, base int64) *Reader {
+	return &Reader{
+		br:          bufio.NewReaderSize(r, 64*1024),
+		off:         base,
+		atLineStart: true,
+	}
+}
+
+// NewSectionReader returns a Reader for the bytes [start, end) of r, typically
+// a single message located through an index.
+func NewSectionReader(r io.ReaderAt, start, end int64) *Reader {
+	return NewReaderOffset(io.NewSectionReader(r, start, end-start), start)
+}
+
+// readChunk returns the next piece of a line. Lines longer than the buffer are
+// returned in several chunks; startsLine reports whether the chunk begins a
+// new line. The returned slice is only valid until the next call.
+func (r *Reader) readChunk() (chunk []byte, startsLine bool, err error) {
+	startsLine = r.atLineStart
+	if r.line != nil {
+		chunk, r.line = r.line, nil
+	} else {
+		if r.err != nil {
+			return nil, startsLine, r.err
+		}
+		chunk, err = r.br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			err = nil
+		}
+		if err != nil {
+			r.err = err
+			if len(chunk) == 0 {
+				return nil, startsLine, err
+			}
+		}
+	}
+	r.off += int64(len(chunk))
+	r.atLineStart = chunk[len(chunk)-1] == '\n'
+	return chunk, startsLine, nil
+}
+
+// unread pushes back a chunk that began a line.
+func (r *Reader) unread(chunk []byte) {
+	r.line = chunk
+	r.off -= int64(len(chunk))
+	r.atLineStart = true
+}
+
+func isEnvelope(chunk []byte, startsLine bool) bool {
+	return startsLine && bytes.HasPrefix(chunk, envelopePrefix)
+}
+
+// lineEnding returns the line ending of the last chunk of a line, "" when
+// the line ends the file without one.
+func lineEnding(chunk []byte) string {
+	if bytes.HasSuffix(chunk, []byte("\r\n")) {
+		return "\r\n"
+	}
+	if bytes.HasSuffix(chunk, []byte("\n")) {
+		return "\n"
+	}
+	return ""
+}
+
+func isBlank(chunk []byte, startsLine bool) bool {
+	return startsLine && len(bytes.TrimRight(chunk, "\r\n")) == 0
+}
+
+// All returns an iterator over the messages of the stream. A non-nil error is
+// yielded with a nil message; I/O errors end the iteration, while
+// ErrInvalidFormat for leading garbage does not.
+func (r *Reader) All() iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		var prev *Message
+		reportedGarbage := false
+		for {
+			if prev != nil {
+				if _, err := io.Copy(io.Discard, prev.body); err != nil {
+					yield(nil, err)
+					return
+				}
+				prev = nil
+			}
+
+			start := r.off
+			chunk, startsLine, err := r.readChunk()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !isEnvelope(chunk, startsLine) {
+				if isBlank(chunk, startsLine) || reportedGarbage {
+					continue
+				}
+				reportedGarbage = true
+				if !yield(nil, ErrInvalidFormat) {
+					return
+				}
+				continue
+			}
+
+			msg := &Message{
+				Envelope: strings.TrimRight(string(chunk), "\r\n"),
+				Start:    start,
+			}
+			// Discard the rest of an overlong envelope line.
+			for !r.atLineStart {
+				if chunk, _, err = r.readChunk(); err != nil {
+					break
+				}
+			}
+			msg.eol = lineEnding(chunk)
+
+			if err := r.readHeader(msg); err != nil {
+				yield(nil, err)
+				return
+			}
+			msg.BodyStart = r.off
+			msg.body = &bodyReader{r: r, msg: msg}
+			prev = msg
+			if !yield(msg, nil) {
+				return
+			}
+		}
+	}
+}
+
+// readHeader collects header lines up to the blank separator line. A message
+// that reaches the next envelope line or EOF without one has no body.
+func (r *Reader) readHeader(msg *Message) error {
+	var header bytes.Buffer
+	for {
+		chunk, startsLine, err := r.readChunk()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if isEnvelope(chunk, startsLine) {
+			r.unread(chunk)
+			break
+		}
+		if isBlank(chunk, startsLine) {
+			msg.blank = string(chunk)
+			break
+		}
+		header.Write(chunk)
+	}
+	msg.Header = header.Bytes()
+	return nil
+}
+
+// bodyReader streams a message body up to the next envelope line.
+type bodyReader struct {
+	r        *Reader
+	msg      *Message
+	unescape bool
+	pending  []byte // bytes ready to be returned
+	held     []byte // blank line withheld until we know it is not the separator
+	done     bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+func (b *bodyReader) fill() error {
+	chunk, startsLine, err := b.r.readChunk()
+	if err == io.EOF || (err == nil && isEnvelope(chunk, startsLine)) {
+		if err == nil {
+			b.r.unread(chunk)
+		}
+		if !b.unescape {
+			b.pending = b.held
+		}
+		b.held = nil
+		b.done = true
+		b.msg.End = b.r.off
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	if b.held != nil {
+		out = append(out, b.held...)
+		b.held = nil
+	}
+	if isBlank(chunk, startsLine) {
+		b.held = append([]byte(nil), chunk...)
+	} else if b.unescape && startsLine && bytes.HasPrefix(chunk, escapedPrefix) {
+		out = append(out, chunk[1:]...)
+	} else {
+		out = append(out, chunk...)
+	}
+	b.pending = out
+	return nil
+}
@@ -0,0 +1,175 @@
+package mboxfile
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+type readMessage struct {
+	envelope, header, body string
+	start, bodyStart, end  int64
+}
+
+func readAll(t *testing.T, data string, raw bool) ([]readMessage, int) {
+	t.Helper()
+	var msgs []readMessage
+	garbage := 0
+	for m, err := range NewReader(strings.NewReader(data)).All() {
+		if err == ErrInvalidFormat {
+			garbage++
+			continue
+		}
+		if err != nil {
+			t.Fatalf("All: %v", err)
+		}
+		body := m.Body()
+		if raw {
+			body = m.RawBody()
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("reading body: %v", err)
+		}
+		msgs = append(msgs, readMessage{m.Envelope, string(m.Header), string(b), m.Start, m.BodyStart, m.End})
+	}
+	return msgs, garbage
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []readMessage
+		garbage int
+	}{
+		{
+			name: "two messages",
+			data: "From a\nA: 1\n\nbody\n\nFrom b\nB: 2\n\nline\n",
+			want: []readMessage{
+				{"From a", "A: 1\n", "body\n", 0, 13, 19},
+				{"From b", "B: 2\n", "line\n", 19, 32, 37},
+			},
+		},
+		{
+			name: "CRLF",
+			data: "From a\r\nA: 1\r\n\r\nbody\r\n\r\nFrom b\r\nB: 2\r\n\r\nline\r\n",
+			want: []readMessage{
+				{"From a", "A: 1\r\n", "body\r\n", 0, 16, 24},
+				{"From b", "B: 2\r\n", "line\r\n", 24, 40, 46},
+			},
+		},
+		{
+			name: "no trailing newline",
+			data: "From a\nA: 1\n\nbody",
+			want: []readMessage{
+				{"From a", "A: 1\n", "body", 0, 13, 17},
+			},
+		},
+		{
+			name: "header only at EOF",
+			data: "From a\nA: 1",
+			want: []readMessage{
+				{"From a", "A: 1", "", 0, 11, 11},
+			},
+		},
+		{
+			name: "header reaching the next envelope",
+			data: "From a\nA: 1\nFrom b\n\nx\n",
+			want: []readMessage{
+				{"From a", "A: 1\n", "", 0, 12, 12},
+				{"From b", "", "x\n", 12, 20, 22},
+			},
+		},
+		{
+			name: "escaped From and blank lines in the body",
+			data: "From a\nA: 1\n\n>From here\n\n\nend\n\nFrom b\n\n",
+			want: []readMessage{
+				{"From a", "A: 1\n", "From here\n\n\nend\n", 0, 13, 31},
+				{"From b", "", "", 31, 39, 39},
+			},
+		},
+		{
+			name: "leading garbage",
+			data: "junk\nmore\nFrom a\nA: 1\n\nx\n",
+			want: []readMessage{
+				{"From a", "A: 1\n", "x\n", 10, 23, 25},
+			},
+			garbage: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, garbage := readAll(t, tt.data, false)
+			if garbage != tt.garbage {
+				t.Errorf("got %d ErrInvalidFormat, want %d", garbage, tt.garbage)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d messages, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("message %d:\n got %+v\nwant %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRawBody(t *testing.T) {
+	data := "From a\nA: 1\n\n>From here\n\nFrom b\n\nx\n"
+	got, _ := readAll(t, data, true)
+	if len(got) != 2 {
+		t.Fatalf("got %d messages, want 2", len(got))
+	}
+	if want := ">From here\n\n"; got[0].body != want {
+		t.Errorf("raw body = %q, want %q", got[0].body, want)
+	}
+	// Offsets cover the file without gaps
+	if got[0].end != got[1].start || got[1].end != int64(len(data)) {
+		t.Errorf("offsets %+v do not cover the file", got)
+	}
+}
+
+func TestSectionReader(t *testing.T) {
+	data := "From a\nA: 1\n\nbody\n\nFrom b\nB: 2\n\nline\n"
+	for m, err := range NewSectionReader(strings.NewReader(data), 19, 37).All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(m.Body())
+		if m.Envelope != "From b" || m.Start != 19 || m.End != 37 || string(b) != "line\n" {
+			t.Errorf("got %q at [%d, %d) with body %q", m.Envelope, m.Start, m.End, b)
+		}
+		return
+	}
+	t.Fatal("no message read")
+}
+
+func TestContent(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{"LF", "From a\nA: 1\n\n>From x\n\n", "A: 1\n\nFrom x\n"},
+		{"CRLF", "From a\r\nA: 1\r\nB: 2\r\n\r\nbody\r\n\r\n", "A: 1\r\nB: 2\r\n\r\nbody\r\n"},
+		{"no blank line", "From a\r\nA: 1\r\n", "A: 1\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for m, err := range NewReader(strings.NewReader(tt.data)).All() {
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(m.Content())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != tt.want {
+					t.Errorf("Content() = %q, want %q", b, tt.want)
+				}
+				return
+			}
+			t.Fatal("no message read")
+		})
+	}
+}
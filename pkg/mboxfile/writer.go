@@ -0,0 +1,41 @@
+package mboxfile
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// WriteMessage writes m back in mbox format with header as its header block,
+// copying the body exactly as stored. Pass m.Header to keep the message
+// unchanged. Line endings added around the header block are those the
+// message was read with, so CRLF mailboxes stay CRLF. It consumes the body
+// of m.
+func WriteMessage(w io.Writer, m *Message, header []byte) error {
+	eol := m.newline()
+	bw := bufio.NewWriter(w)
+	bw.WriteString(m.Envelope)
+	bw.WriteString(eol)
+	bw.Write(header)
+	if len(header) > 0 && header[len(header)-1] != '\n' {
+		bw.WriteString(eol)
+	}
+	bw.WriteString(m.separator())
+	if _, err := io.Copy(bw, m.RawBody()); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// CopyMode gives f, a file about to replace the mailbox described by fi,
+// the permission bits of the mailbox and, as far as the process is allowed
+// to, its owner and group. Without this the MTA or group readers could lose
+// access to a mailbox rewritten through a temp file.
+func CopyMode(f *os.File, fi fs.FileInfo) error {
+	if err := f.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+	copyOwner(f, fi)
+	return nil
+}
@@ -0,0 +1,75 @@
+package mboxfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name, data string
+	}{
+		{"LF", "From a\nA: b\n\nbody\n\nFrom c\nC: d\n\n>From x\n"},
+		{"CRLF", "From a\r\nA: b\r\n\r\nbody\r\n\r\nFrom c\r\nC: d\r\n\r\n>From x\r\n"},
+		{"empty body", "From a\nA: b\n\nFrom c\nC: d\n\n"},
+		{"no trailing newline", "From a\r\nA: b\r\n\r\nbody"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			for m, err := range NewReader(strings.NewReader(tt.data)).All() {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := WriteMessage(&out, m, m.Header); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if out.String() != tt.data {
+				t.Errorf("round trip changed the mailbox:\n got %q\nwant %q", out.String(), tt.data)
+			}
+		})
+	}
+}
+
+func TestWriteMessageHeader(t *testing.T) {
+	tests := []struct {
+		name, data, header, want string
+	}{
+		{
+			name:   "CRLF header edited",
+			data:   "From a\r\nA: b\r\n\r\nbody\r\n",
+			header: "A: b\r\nStatus: RO\r\n",
+			want:   "From a\r\nA: b\r\nStatus: RO\r\n\r\nbody\r\n",
+		},
+		{
+			name:   "header without its last line ending",
+			data:   "From a\r\nA: b\r\n\r\nbody\r\n",
+			header: "A: c",
+			want:   "From a\r\nA: c\r\n\r\nbody\r\n",
+		},
+		{
+			name:   "separator added where the file had none",
+			data:   "From a\nA: b\n",
+			header: "A: b\n",
+			want:   "From a\nA: b\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			for m, err := range NewReader(strings.NewReader(tt.data)).All() {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := WriteMessage(&out, m, []byte(tt.header)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if out.String() != tt.want {
+				t.Errorf("got %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -20,25 +20,40 @@ document.addEventListener('DOMContentLoaded', () => {
     batchDeleteButton.id = 'batch-delete-btn';
     batchDeleteButton.textContent = '選択したメールを削除';
     batchDeleteButton.addEventListener('click', () => {
-        deleteBatchEmails(currentMailbox);
+        if (showDeleted)
+            undeleteBatchEmails(currentMailbox);
+        else
+            deleteBatchEmails(currentMailbox);
     });
     batchDeleteContainer.appendChild(batchDeleteButton);
     emailFilter.appendChild(batchDeleteContainer);
 
+    const showMailbox = (folder) => {
+        // Load emails for the selected folder
+        loadEmails(emailListBody, folder, (mailboxName, emailId) => {
+            // When email is selected: load content and mark as read
+            loadEmail(emailContent, mailboxName, emailId);
+            markEmailAsRead(mailboxName, emailId);
+        });
+        emailContent.innerHTML = '<p>Select an email to view its content.</p>';
+    };
+
     // Initialize folder pane with callback
     loadFolders(folderList, (folder) => {
         if (currentMailbox !== folder) {
             currentMailbox = folder;
-            // Load emails for the selected folder
-            loadEmails(emailListBody, folder, (mailboxName, emailId) => {
-                // When email is selected: load content and mark as read
-                loadEmail(emailContent, mailboxName, emailId);
-                markEmailAsRead(mailboxName, emailId);
-            });
-            emailContent.innerHTML = '<p>Select an email to view its content.</p>';
+            showMailbox(folder);
         }
     });
 
+    // Toggle between the normal list and the deleted messages
+    document.getElementById('show-deleted').addEventListener('change', (e) => {
+        showDeleted = e.target.checked;
+        batchDeleteButton.textContent = showDeleted ? '選択したメールを復元' : '選択したメールを削除';
+        if (currentMailbox !== null)
+            showMailbox(currentMailbox);
+    });
+
     // Initialize pane resizers
     resizeFolderPane();
     resizeEmailsPane();
@@ -62,7 +77,7 @@ async function deleteBatchEmails(mailboxName) {
         return;
     }
 
-    const emailIds = checkboxes.map(cb => parseInt(cb.dataset.emailId));
+    const emailIds = checkboxes.map(cb => cb.dataset.emailId);
 
     if (!confirm(`${emailIds.length}件のメールを削除しますか？`))
         return;
@@ -89,7 +104,7 @@ async function deleteBatchEmails(mailboxName) {
 
         // Update allEmails by filtering out deleted emails
         if (typeof allEmails !== 'undefined' && Array.isArray(allEmails))
-            allEmails = allEmails.filter(email => !emailIds.some(deletedId => Number(email.id) === Number(deletedId)));
+            allEmails = allEmails.filter(email => !emailIds.includes(email.uid));
 
         // Reset button and show summary
         const container = document.getElementById('batch-delete-container');
@@ -101,3 +116,38 @@ async function deleteBatchEmails(mailboxName) {
     }
 }
 
+
+async function undeleteBatchEmails(mailboxName) {
+    const checkboxes = Array.from(document.querySelectorAll('.email-checkbox:checked'));
+    if (checkboxes.length === 0)
+        return;
+
+    const emailIds = checkboxes.map(cb => cb.dataset.emailId);
+    try {
+        const response = await fetch(`/api/mailboxes/${encodeURIComponent(mailboxName)}/emails/undelete-batch`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ ids: emailIds })
+        });
+
+        if (!response.ok)
+            throw new Error(`HTTP error! status: ${response.status}`);
+
+        const result = await response.json();
+
+        // Restored emails leave the deleted view
+        checkboxes.forEach(cb => {
+            const row = cb.closest('tr');
+            if (row) row.remove();
+        });
+        allEmails = allEmails.filter(email => !emailIds.includes(email.uid));
+
+        document.getElementById('batch-delete-container').style.display = 'none';
+        alert(`${result.undeleted}件復元、${result.failed}件失敗しました。`);
+    } catch (error) {
+        console.error('Failed to undelete batch emails:', error);
+        alert('メールの復元に失敗しました。');
+    }
+}
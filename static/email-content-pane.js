@@ -3,11 +3,14 @@
  * Manages loading and displaying email content and attachments
  */
 
-async function loadEmail(emailContent, mailboxName, emailId) {
+async function loadEmail(emailContent, mailboxName, emailId, allowRemote = false) {
 	// Load email content when an email is selected
 	emailContent.innerHTML = '<p>Loading content...</p>';
 	try {
-		const response = await fetch(`/api/mailboxes/${encodeURIComponent(mailboxName)}/emails/${emailId}`);
+		const params = new URLSearchParams({ textHTML: '1' });
+		if (allowRemote)
+			params.set('remote', 'allow');
+		const response = await fetch(`/api/mailboxes/${encodeURIComponent(mailboxName)}/emails/${emailId}?${params}`);
 		if (!response.ok) {
 			throw new Error(`HTTP error! status: ${response.status}`);
 		}
@@ -21,6 +24,33 @@ async function loadEmail(emailContent, mailboxName, emailId) {
 		displayContainer.style.flexDirection = 'column';
 		displayContainer.style.height = '100%';
 
+		// 署名の検証結果と暗号化の有無を表示
+		createSecurityNotices(content).forEach(notice => displayContainer.appendChild(notice));
+
+		// 配信エラー・開封確認の内容を表示
+		(content.reports || []).forEach(report => {
+			displayContainer.appendChild(createReportCard(report));
+		});
+
+		// 会議の招待などの予定を表示
+		(content.calendars || []).forEach(calendar => {
+			displayContainer.appendChild(createCalendarCard(calendar, mailboxName, emailId));
+		});
+
+		// 外部画像・CSS をブロックした場合は、読み込むためのボタンを表示
+		if (content.remoteBlocked) {
+			const notice = document.createElement('div');
+			notice.className = 'remote-content-notice';
+			notice.textContent = '外部の画像やスタイルをブロックしました。';
+			const allowBtn = document.createElement('button');
+			allowBtn.textContent = '表示する';
+			allowBtn.addEventListener('click', () => {
+				loadEmail(emailContent, mailboxName, emailId, true);
+			});
+			notice.appendChild(allowBtn);
+			displayContainer.appendChild(notice);
+		}
+
 		// Display Body with view toggle if both versions are available
 		if (content.hasAlternate) {
 			// Create toggle buttons
@@ -88,14 +118,7 @@ async function loadEmail(emailContent, mailboxName, emailId) {
 				textBtn.style.color = 'white';
 
 				contentContainer.style.overflow = 'auto';
-				const pre = document.createElement('pre');
-				pre.style.whiteSpace = 'pre-wrap';
-				pre.style.wordWrap = 'break-word';
-				pre.style.margin = '0';
-				pre.style.padding = '8px';
-				pre.style.boxSizing = 'border-box';
-				pre.textContent = content.bodyText || 'No text version available.';
-				contentContainer.appendChild(pre);
+				contentContainer.appendChild(createTextBody(content, 'No text version available.'));
 			};
 
 			// Event listeners
@@ -120,14 +143,7 @@ async function loadEmail(emailContent, mailboxName, emailId) {
 			// Text-only version
 			emailContent.style.overflow = 'auto';
 
-			const pre = document.createElement('pre');
-			pre.style.whiteSpace = 'pre-wrap';
-			pre.style.wordWrap = 'break-word';
-			pre.style.margin = '0';
-			pre.style.padding = '8px';
-			pre.style.boxSizing = 'border-box';
-			pre.textContent = content.bodyText || 'No viewable content.';
-			displayContainer.appendChild(pre);
+			displayContainer.appendChild(createTextBody(content, 'No viewable content.'));
 		}
 
 		emailContent.appendChild(displayContainer);
@@ -140,17 +156,257 @@ async function loadEmail(emailContent, mailboxName, emailId) {
 			attachmentsDiv.style.borderTop = '1px solid #ccc';
 			attachmentsDiv.innerHTML = '<strong>Attachments:</strong>';
 			const ul = document.createElement('ul');
-			content.attachments.forEach(name => {
+			content.attachments.forEach(attachment => {
 				const li = document.createElement('li');
-				li.textContent = name;
+				const a = document.createElement('a');
+				a.href = `/api/mailboxes/${encodeURIComponent(mailboxName)}/emails/${emailId}/attachments/${attachment.partId}`;
+				a.textContent = attachment.filename || `part-${attachment.partId}`;
+				li.appendChild(a);
+				li.appendChild(document.createTextNode(` (${attachment.contentType}, ${formatSize(attachment.size)})`));
 				ul.appendChild(li);
 			});
 			attachmentsDiv.appendChild(ul);
 			emailContent.appendChild(attachmentsDiv);
 		}
 
+		// Display enclosed messages (forwarded mail)
+		if (content.messages) {
+			content.messages.forEach(message => {
+				emailContent.appendChild(createEmbeddedMessage(message, mailboxName, emailId));
+			});
+		}
+
 	} catch (error) {
 		console.error(`Failed to load email content for ID ${emailId}:`, error);
 		emailContent.innerHTML = '<p>Error loading email content.</p>';
 	}
 }
+
+const SIGNATURE_STATUS_TEXT = {
+	valid: '署名は有効です',
+	untrusted: '署名は改ざんされていませんが、証明書が信頼されていません',
+	unknownKey: '署名者の鍵がキーリングにありません',
+	expired: '署名または鍵の有効期限が切れています',
+	revoked: '署名者の鍵は失効しています',
+	invalid: '署名が一致しません（改ざんされている可能性があります）',
+	malformed: '署名を読み取れません',
+};
+
+// Build the notices for the signatures and encrypted parts of a message
+function createSecurityNotices(content) {
+	const notices = [];
+	(content.signatures || []).forEach(sig => {
+		const notice = document.createElement('div');
+		notice.className = `signature-notice signature-${sig.status === 'valid' ? 'valid' : 'warning'}`;
+		const protocol = sig.protocol === 'smime' ? 'S/MIME' : 'OpenPGP';
+		const signer = [sig.signer, (sig.emails || []).join(', ')].filter(Boolean).join(' ');
+		let text = `${protocol}: ${SIGNATURE_STATUS_TEXT[sig.status] || sig.status}`;
+		if (signer)
+			text += `（署名者: ${signer}）`;
+		if (sig.fromMismatch)
+			text += ' 差出人のアドレスと署名者が一致しません。';
+		notice.textContent = text;
+		notice.title = [sig.fingerprint && `Fingerprint: ${sig.fingerprint}`, sig.issuer && `Issuer: ${sig.issuer}`, sig.signedAt && `Signed: ${sig.signedAt}`, sig.detail]
+			.filter(Boolean).join('\n');
+		notices.push(notice);
+	});
+	(content.encrypted || []).forEach(part => {
+		const notice = document.createElement('div');
+		notice.className = 'signature-notice signature-warning';
+		notice.textContent = `${part.protocol === 'smime' ? 'S/MIME' : 'OpenPGP'} で暗号化されたパートがあります。この画面では復号できません。`;
+		notices.push(notice);
+	});
+	return notices;
+}
+
+const DSN_ACTION_TEXT = {
+	failed: '配信失敗',
+	delayed: '配信遅延',
+	delivered: '配信済み',
+	relayed: '転送済み',
+	expanded: '展開済み',
+};
+
+// Build the summary of a delivery status notification or read receipt
+function createReportCard(report) {
+	const div = document.createElement('div');
+	div.className = 'report-card';
+	const title = document.createElement('div');
+	title.className = 'report-card-title';
+	if (report.type === 'delivery-status') {
+		title.textContent = '配信状況の通知';
+	} else {
+		title.textContent = `開封確認（${report.disposition || '不明'}）`;
+	}
+	div.appendChild(title);
+
+	const lines = [];
+	if (report.originalMessageId || report.originalSubject)
+		lines.push(`元のメール: ${[report.originalSubject, report.originalMessageId].filter(Boolean).join(' ')}`);
+	(report.recipients || []).forEach(rcpt => {
+		let line = rcpt.finalRecipient || rcpt.originalRecipient;
+		if (rcpt.action)
+			line += `: ${DSN_ACTION_TEXT[rcpt.action] || rcpt.action}`;
+		if (rcpt.status)
+			line += ` (${rcpt.status})`;
+		if (rcpt.diagnosticCode)
+			line += ` ${rcpt.diagnosticCode}`;
+		lines.push(line);
+	});
+	lines.forEach(text => {
+		const line = document.createElement('div');
+		line.textContent = text;
+		div.appendChild(line);
+	});
+	return div;
+}
+
+const CALENDAR_METHOD_TEXT = {
+	REQUEST: '会議の招待',
+	CANCEL: '会議のキャンセル',
+	REPLY: '出欠の返信',
+	COUNTER: '日時の変更の提案',
+	PUBLISH: '予定',
+};
+
+const PARTSTAT_TEXT = {
+	'NEEDS-ACTION': '未回答',
+	ACCEPTED: '承諾',
+	DECLINED: '辞退',
+	TENTATIVE: '仮承諾',
+	DELEGATED: '委任',
+};
+
+// Format an event time from the server: a date for all-day events, a wall
+// clock time for floating ones, and a local time for the rest
+function formatCalendarTime(t, allDay) {
+	if (!t) return '';
+	if (allDay) return new Date(`${t.time}T00:00:00`).toLocaleDateString();
+	const text = new Date(t.time).toLocaleString();
+	return t.tzid ? `${text}（${t.tzid}）` : text;
+}
+
+// Build the summary card of an iCalendar part
+function createCalendarCard(calendar, mailboxName, emailId) {
+	const div = document.createElement('div');
+	div.className = 'calendar-card';
+
+	const title = document.createElement('div');
+	title.className = 'calendar-card-title';
+	title.textContent = CALENDAR_METHOD_TEXT[calendar.method] || '予定';
+	const download = document.createElement('a');
+	download.href = `/api/mailboxes/${encodeURIComponent(mailboxName)}/emails/${emailId}/attachments/${calendar.partId}`;
+	download.textContent = '.ics をダウンロード';
+	title.appendChild(download);
+	div.appendChild(title);
+
+	calendar.events.forEach(event => {
+		const eventDiv = document.createElement('div');
+		eventDiv.className = 'calendar-event';
+		const summary = document.createElement('strong');
+		summary.textContent = event.summary || '（件名なし）';
+		if (event.status === 'CANCELLED')
+			summary.classList.add('calendar-cancelled');
+		eventDiv.appendChild(summary);
+
+		const rows = [];
+		if (event.start) {
+			let when = formatCalendarTime(event.start, event.allDay);
+			if (event.end)
+				when += ` – ${formatCalendarTime(event.end, event.allDay)}`;
+			rows.push(['日時', when]);
+		}
+		if (event.recurrence)
+			rows.push(['繰り返し', event.recurrence.join('\n')]);
+		if (event.location)
+			rows.push(['場所', event.location]);
+		if (event.organizer)
+			rows.push(['主催者', event.organizer.name ? `${event.organizer.name} <${event.organizer.email}>` : event.organizer.email]);
+		if (event.attendees) {
+			rows.push(['出席者', event.attendees.map(a => {
+				const name = a.name ? `${a.name} <${a.email}>` : a.email;
+				return a.status ? `${name}（${PARTSTAT_TEXT[a.status] || a.status}）` : name;
+			}).join('\n')]);
+		}
+		rows.forEach(([name, value]) => {
+			const line = document.createElement('div');
+			line.className = 'calendar-event-row';
+			const label = document.createElement('span');
+			label.className = 'calendar-event-label';
+			label.textContent = name;
+			const text = document.createElement('span');
+			text.textContent = value;
+			line.appendChild(label);
+			line.appendChild(text);
+			eventDiv.appendChild(line);
+		});
+		div.appendChild(eventDiv);
+	});
+	return div;
+}
+
+// Display the plain text body, using the server's HTML rendering (escaped,
+// with links and quote levels) when it is available
+function createTextBody(content, emptyMessage) {
+	if (content.bodyTextHTML) {
+		const div = document.createElement('div');
+		div.className = 'text-body';
+		div.innerHTML = content.bodyTextHTML;
+		return div;
+	}
+	const pre = document.createElement('pre');
+	pre.style.whiteSpace = 'pre-wrap';
+	pre.style.wordWrap = 'break-word';
+	pre.style.margin = '0';
+	pre.style.padding = '8px';
+	pre.style.boxSizing = 'border-box';
+	pre.textContent = content.bodyText || emptyMessage;
+	return pre;
+}
+
+// Display a message enclosed as message/rfc822 with its header summary
+function createEmbeddedMessage(message, mailboxName, emailId) {
+	const div = document.createElement('div');
+	div.className = 'embedded-message';
+
+	const header = document.createElement('div');
+	header.className = 'embedded-message-header';
+	[['From', message.from], ['To', message.to], ['Cc', message.cc], ['Date', message.date], ['Subject', message.subject]].forEach(([name, value]) => {
+		if (!value) return;
+		const line = document.createElement('div');
+		const label = document.createElement('strong');
+		label.textContent = `${name}: `;
+		line.appendChild(label);
+		line.appendChild(document.createTextNode(value));
+		header.appendChild(line);
+	});
+	const download = document.createElement('a');
+	download.href = `/api/mailboxes/${encodeURIComponent(mailboxName)}/emails/${emailId}/attachments/${message.partId}`;
+	download.textContent = 'EML をダウンロード';
+	header.appendChild(download);
+	div.appendChild(header);
+	createSecurityNotices(message).forEach(notice => div.appendChild(notice));
+
+	if (message.bodyText || !message.bodyHTML) {
+		div.appendChild(createTextBody(message, 'No viewable content.'));
+	} else {
+		const iframe = document.createElement('iframe');
+		iframe.setAttribute('sandbox', 'allow-same-origin');
+		iframe.className = 'embedded-message-html';
+		iframe.srcdoc = message.bodyHTML;
+		div.appendChild(iframe);
+	}
+
+	if (message.messages) {
+		message.messages.forEach(inner => {
+			div.appendChild(createEmbeddedMessage(inner, mailboxName, emailId));
+		});
+	}
+	return div;
+}
+
+function formatSize(bytes) {
+	if (bytes < 1024) return `${bytes} B`;
+	if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} KB`;
+	return `${(bytes / 1024 / 1024).toFixed(1)} MB`;
+}
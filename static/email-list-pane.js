@@ -2,17 +2,33 @@
  * Email List Pane Handler
  * Manages loading and displaying email list
  */
+const EMAIL_PAGE_SIZE = 500;
 let allEmails = [];
+let totalEmails = 0;
+let nextCursor = null;
+// 削除済みメールの表示中は true
+let showDeleted = false;
+
+// Fetch one page of the email list, starting after the cursor if given
+async function fetchEmailPage(mailboxName, cursor) {
+    const params = new URLSearchParams({ limit: EMAIL_PAGE_SIZE });
+    if (cursor)
+        params.set('cursor', cursor);
+    if (showDeleted)
+        params.set('deleted', '1');
+    const response = await fetch(`/api/mailboxes/${encodeURIComponent(mailboxName)}/emails?${params}`);
+    if (!response.ok)
+        throw new Error(`HTTP error! status: ${response.status}`);
+    return response.json();
+}
 
 async function loadEmails(emailListBody, mailboxName, onEmailSelected) {
     emailListBody.innerHTML = '<tr><td colspan="3">Loading...</td></tr>';
     try {
-        const response = await fetch(`/api/mailboxes/${encodeURIComponent(mailboxName)}/emails`);
-        if (!response.ok)
-            throw new Error(`HTTP error! status: ${response.status}`);
-
-        const emails = await response.json();
-        allEmails = emails;
+        const page = await fetchEmailPage(mailboxName, null);
+        allEmails = page.emails;
+        totalEmails = page.total;
+        nextCursor = page.nextCursor || null;
 
         document.getElementById('filter-string').value = '';
 
@@ -53,9 +69,9 @@ function renderEmailRows(emails, mailboxName, onEmailSelected) {
 
     emails.forEach(email => {
         const row = document.createElement('tr');
-        row.dataset.emailId = email.id;
-        // 新着メール（StatusにNまたはUが含まれる）は太字
-        if (email.status.includes('N') || email.status.includes('U'))
+        row.dataset.emailId = email.uid;
+        // 未読メール（seen フラグが無い）は太字
+        if (!email.flags.includes('seen'))
             row.classList.add('new-mail-row');
 
         // Add checkbox cell
@@ -64,7 +80,7 @@ function renderEmailRows(emails, mailboxName, onEmailSelected) {
         const checkbox = document.createElement('input');
         checkbox.type = 'checkbox';
         checkbox.className = 'email-checkbox';
-        checkbox.dataset.emailId = email.id;
+        checkbox.dataset.emailId = email.uid;
         checkbox.addEventListener('change', () => {
             updateBatchDeleteButton();
             if (typeof updateSelectAllHeader === 'function') updateSelectAllHeader();
@@ -85,14 +101,18 @@ function renderEmailRows(emails, mailboxName, onEmailSelected) {
         row.appendChild(fromCell);
         row.appendChild(subjectCell);
 
-        // Add delete button
+        // Add delete button, or an undelete button in the deleted view
         const deleteCell = document.createElement('td');
         const deleteButton = document.createElement('button');
-        deleteButton.textContent = '✖';
+        deleteButton.textContent = showDeleted ? '↺' : '✖';
         deleteButton.className = 'delete-email';
+        deleteButton.title = showDeleted ? '元に戻す' : '削除';
         deleteButton.addEventListener('click', (e) => {
             e.stopPropagation(); // Prevent row click
-            deleteEmail(mailboxName, email.id, row);
+            if (showDeleted)
+                undeleteEmail(mailboxName, email.uid, row);
+            else
+                deleteEmail(mailboxName, email.uid, row);
         });
         deleteCell.appendChild(deleteButton);
         row.appendChild(deleteCell);
@@ -104,21 +124,50 @@ function renderEmailRows(emails, mailboxName, onEmailSelected) {
             });
             // Mark clicked row as selected
             row.classList.add('selected');
-            onEmailSelected(mailboxName, email.id);
+            onEmailSelected(mailboxName, email.uid);
         });
 
         // Also mark as read when double-clicked
         row.addEventListener('dblclick', () => {
-            markEmailAsRead(mailboxName, email.id);
+            markEmailAsRead(mailboxName, email.uid);
         });
 
         emailListBody.appendChild(row);
     });
 
+    // Offer the next page while unfiltered rows remain on the server
+    if (nextCursor && document.getElementById('filter-string').value === '')
+        emailListBody.appendChild(createLoadMoreRow(mailboxName, onEmailSelected));
+
     // Sync header checkbox state with rendered rows
     if (typeof updateSelectAllHeader === 'function') updateSelectAllHeader();
 }
 
+function createLoadMoreRow(mailboxName, onEmailSelected) {
+    const row = document.createElement('tr');
+    row.className = 'load-more-row';
+    const cell = document.createElement('td');
+    cell.colSpan = 5;
+    const button = document.createElement('button');
+    button.textContent = `さらに読み込む（${allEmails.length} / ${totalEmails}件）`;
+    button.addEventListener('click', async () => {
+        button.disabled = true;
+        try {
+            const page = await fetchEmailPage(mailboxName, nextCursor);
+            allEmails = allEmails.concat(page.emails);
+            totalEmails = page.total;
+            nextCursor = page.nextCursor || null;
+            renderEmailRows(allEmails, mailboxName, onEmailSelected);
+        } catch (error) {
+            console.error(`Failed to load more emails for ${mailboxName}:`, error);
+            button.disabled = false;
+        }
+    });
+    cell.appendChild(button);
+    row.appendChild(cell);
+    return row;
+}
+
 function applyFilters(mailboxName, onEmailSelected) {
     const filterString = document.getElementById('filter-string').value.toLowerCase();
     const filteredEmails = filterString === ''
@@ -165,7 +214,7 @@ async function deleteEmail(mailboxName, emailId, rowElement) {
         rowElement.remove();
         // Also remove from client-side allEmails so filters won't re-show it
         if (typeof allEmails !== 'undefined' && Array.isArray(allEmails))
-            allEmails = allEmails.filter(email => String(email.id) !== String(emailId));
+            allEmails = allEmails.filter(email => email.uid !== emailId);
         if (typeof updateSelectAllHeader === 'function') updateSelectAllHeader();
         if (typeof updateBatchDeleteButton === 'function') updateBatchDeleteButton();
     } catch (error) {
@@ -174,6 +223,25 @@ async function deleteEmail(mailboxName, emailId, rowElement) {
     }
 }
 
+async function undeleteEmail(mailboxName, emailId, rowElement) {
+    try {
+        const response = await fetch(`/api/mailboxes/${encodeURIComponent(mailboxName)}/emails/${emailId}/undelete`, {
+            method: 'POST',
+        });
+        if (!response.ok)
+            throw new Error(`HTTP error! status: ${response.status}`);
+
+        // The message leaves the deleted view
+        rowElement.remove();
+        allEmails = allEmails.filter(email => email.uid !== emailId);
+        updateSelectAllHeader();
+        updateBatchDeleteButton();
+    } catch (error) {
+        console.error(`Failed to undelete email ${emailId}:`, error);
+        alert('メールの復元に失敗しました。');
+    }
+}
+
 // Update the header select-all checkbox state based on visible row checkboxes
 function updateSelectAllHeader() {
     const headerCb = document.getElementById('select-all-checkbox');
@@ -5,29 +5,50 @@
 
 async function loadFolders(folderList, onFolderSelected) {
     try {
-        const response = await fetch('/api/mailboxes');
+        const response = await fetch('/api/mailboxes?tree=1');
         if (!response.ok) {
             throw new Error(`HTTP error! status: ${response.status}`);
         }
-        const folders = await response.json();
+        const nodes = await response.json();
 
         folderList.innerHTML = ''; // Clear existing list
-        folders.forEach(folder => {
-            const li = document.createElement('li');
-            li.textContent = folder;
-            li.dataset.mailbox = folder;
+        appendFolderNodes(folderList, nodes, 0, onFolderSelected);
+    } catch (error) {
+        console.error('Failed to load folders:', error);
+        folderList.innerHTML = '<li>Error loading folders.</li>';
+    }
+}
+
+// Flatten the mailbox tree into the list, indenting each level
+function appendFolderNodes(folderList, nodes, depth, onFolderSelected) {
+    nodes.forEach(node => {
+        const li = document.createElement('li');
+        li.textContent = node.name;
+        li.style.paddingLeft = `${12 + depth * 16}px`;
+
+        if (node.selectable) {
+            li.dataset.mailbox = node.path;
+            if (node.unread > 0) {
+                const count = document.createElement('span');
+                count.className = 'unread-count';
+                count.textContent = node.unread;
+                li.appendChild(count);
+            }
             li.addEventListener('click', () => {
                 // Update selected visual state
                 document.querySelectorAll('#folder-list li').forEach(item => item.classList.remove('selected'));
                 li.classList.add('selected');
 
                 // Notify parent of folder selection
-                onFolderSelected(folder);
+                onFolderSelected(node.path);
             });
-            folderList.appendChild(li);
-        });
-    } catch (error) {
-        console.error('Failed to load folders:', error);
-        folderList.innerHTML = '<li>Error loading folders.</li>';
-    }
+        } else {
+            li.classList.add('folder-group');
+        }
+        folderList.appendChild(li);
+
+        if (node.children) {
+            appendFolderNodes(folderList, node.children, depth + 1, onFolderSelected);
+        }
+    });
 }